@@ -1,14 +1,27 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"github.com/make0x20/driplet/internal/config"
+	"github.com/make0x20/driplet/internal/enrichment"
+	"github.com/make0x20/driplet/internal/introspection"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/metrics"
+	"github.com/make0x20/driplet/internal/nonce"
+	"github.com/make0x20/driplet/internal/revocation"
+	"github.com/make0x20/driplet/internal/session"
+	"github.com/make0x20/driplet/internal/tcp"
+	"github.com/make0x20/driplet/internal/websocket"
 	"github.com/make0x20/driplet/logger"
 	"log"
 	"log/slog"
 	"os"
+	"slices"
 	"strings"
+	"time"
 )
 
 // loadConfig loads the config from the given path.
@@ -44,17 +57,265 @@ func setupLogger(cfg *config.Config) *slog.Logger {
 	return logger.New(logLevel, file)
 }
 
+// hubOptions returns the websocket hub options for the given config.
+func hubOptions(cfg *config.Config, registry *metrics.Registry) []websocket.Option {
+	options := []websocket.Option{
+		websocket.WithMetrics(registry),
+		websocket.WithPingInterval(time.Duration(cfg.Global.PingInterval) * time.Second),
+	}
+
+	for name, e := range cfg.Endpoints {
+		options = append(options, websocket.WithCompression(name, websocket.CompressionOptions{
+			Enabled: e.Compression.Enabled,
+			Level:   e.Compression.Level,
+			MinSize: e.Compression.MinSize,
+		}))
+		options = append(options, websocket.WithHistory(name, websocket.HistoryOptions{
+			Size:   e.History.Size,
+			MaxAge: time.Duration(e.History.MaxAge) * time.Second,
+		}))
+	}
+
+	return options
+}
+
+// openRevocations loads the revocation list of client tokens.
+func openRevocations(cfg *config.Config) *revocation.List {
+	revocations, err := revocation.Open(cfg.Global.RevocationFile)
+	if err != nil {
+		log.Fatalf("error loading revocation list: %v", err)
+	}
+	return revocations
+}
+
+// newValidator creates the JWT validator with the client token keys and API secrets of every endpoint.
+// Key sets are refreshed until ctx is done.
+func newValidator(ctx context.Context, cfg *config.Config, logger *slog.Logger, registry *metrics.Registry, revocations *revocation.List) *jwt.Validator {
+	options := []jwt.Option{jwt.WithMetrics(registry), jwt.WithRevoker(revocations)}
+	for name, e := range cfg.Endpoints {
+		keys, err := endpointKeys(ctx, e, logger)
+		if err != nil {
+			log.Fatalf("error loading JWT keys of endpoint %s: %v", name, err)
+		}
+		apiSecrets, err := secretKeys(e.Name, "APISecret", e.APISecret, e.APISecrets, logger)
+		if err != nil {
+			log.Fatalf("error loading API secrets of endpoint %s: %v", name, err)
+		}
+		options = append(options, jwt.WithEndpointKeys(name, keys), jwt.WithEndpointAPISecrets(name, apiSecrets))
+		options = append(options, jwt.WithEndpointRules(name, jwt.Rules{
+			Issuer:   e.JWT.Issuer,
+			Audience: e.JWT.Audience,
+			Required: e.JWT.RequiredClaims,
+			Leeway:   time.Duration(e.JWT.Leeway) * time.Second,
+		}))
+
+		var mapping []jwt.ClaimMapping
+		for _, m := range e.JWT.Claims.Mapping {
+			mapping = append(mapping, jwt.ClaimMapping{Path: m.Path, Attribute: m.Attribute})
+		}
+		source, err := jwt.NewClaimSource(e.JWT.Claims.Source, e.JWT.Claims.Namespace, mapping)
+		if err != nil {
+			log.Fatalf("error loading claim source of endpoint %s: %v", name, err)
+		}
+		options = append(options, jwt.WithEndpointClaimSource(name, source))
+
+		auth, err := authOptions(name, e, apiSecrets)
+		if err != nil {
+			log.Fatalf("error loading auth of endpoint %s: %v", name, err)
+		}
+		options = append(options, auth...)
+
+		if e.Auth.Enrichment.URL != "" {
+			secret := callbackSecret(apiSecrets)
+			if secret() == "" {
+				log.Fatalf("error loading enrichment of endpoint %s: enrichment requires an API secret", name)
+			}
+			options = append(options, jwt.WithEndpointEnricher(name, enrichment.New(enrichment.Options{
+				URL:      e.Auth.Enrichment.URL,
+				Secret:   secret,
+				Timeout:  time.Duration(e.Auth.Enrichment.Timeout) * time.Second,
+				CacheTTL: time.Duration(e.Auth.Enrichment.CacheTTL) * time.Second,
+				FailOpen: e.Auth.Enrichment.FailOpen,
+			}, logger)))
+		}
+	}
+
+	return jwt.NewValidator(nonce.NewMemoryStore(), options...)
+}
+
+// authOptions returns the validator options of the endpoint auth mode, none for JWTs.
+func authOptions(name string, e config.EndpointConfig, apiSecrets []jwt.Key) ([]jwt.Option, error) {
+	switch e.Auth.Mode {
+	case "", "jwt":
+		return nil, nil
+
+	case "introspection":
+		if e.Auth.Introspection.URL == "" {
+			return nil, fmt.Errorf("introspection requires a URL")
+		}
+		return []jwt.Option{jwt.WithEndpointIntrospector(name, introspection.New(introspection.Options{
+			URL:              e.Auth.Introspection.URL,
+			ClientID:         e.Auth.Introspection.ClientID,
+			ClientSecret:     e.Auth.Introspection.ClientSecret,
+			Timeout:          time.Duration(e.Auth.Introspection.Timeout) * time.Second,
+			CacheTTL:         time.Duration(e.Auth.Introspection.CacheTTL) * time.Second,
+			NegativeCacheTTL: time.Duration(e.Auth.Introspection.NegativeCacheTTL) * time.Second,
+		}))}, nil
+
+	case "session":
+		if e.Auth.Session.URL == "" {
+			return nil, fmt.Errorf("session requires a URL")
+		}
+		secret := callbackSecret(apiSecrets)
+		if secret() == "" {
+			return nil, fmt.Errorf("session requires an API secret")
+		}
+		return []jwt.Option{jwt.WithEndpointRequestAuthenticator(name, session.New(session.Options{
+			URL:      e.Auth.Session.URL,
+			Endpoint: name,
+			Secret:   secret,
+			Cookies:  e.Auth.Session.Cookies,
+			Headers:  e.Auth.Session.Headers,
+			Timeout:  time.Duration(e.Auth.Session.Timeout) * time.Second,
+		}))}, nil
+	}
+	return nil, fmt.Errorf("unknown mode %q", e.Auth.Mode)
+}
+
+// callbackSecret returns a func returning the first API secret that has not expired at the time of the call,
+// backend callbacks are signed with it so they follow secret rotation.
+func callbackSecret(apiSecrets []jwt.Key) func() string {
+	return func() string {
+		for _, key := range apiSecrets {
+			if key.NotAfter.IsZero() || time.Now().Before(key.NotAfter) {
+				return string(key.Key.([]byte))
+			}
+		}
+		return ""
+	}
+}
+
+// endpointKeys returns the keys client tokens of the endpoint are verified with, a JWKS is loaded and refreshed
+// in the background until ctx is done.
+func endpointKeys(ctx context.Context, e config.EndpointConfig, logger *slog.Logger) (*jwt.Keys, error) {
+	keys, err := secretKeys(e.Name, "JWTSecret", e.JWTSecret, e.JWTSecrets, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range e.JWT.PublicKeys {
+		data := []byte(k.PEM)
+		if k.File != "" {
+			var err error
+			if data, err = os.ReadFile(k.File); err != nil {
+				return nil, err
+			}
+		}
+
+		parsed, err := jwt.ParsePublicKeys(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range parsed {
+			keys = append(keys, jwt.Key{ID: k.ID, Key: key})
+		}
+	}
+
+	var jwks *jwt.JWKS
+	if e.JWT.JWKS != "" {
+		jwks = jwt.NewJWKS(e.JWT.JWKS, time.Duration(e.JWT.JWKSRefresh)*time.Second, logger)
+
+		// Start without keys if the set can not be loaded yet, it is retried on the interval and on unknown kids
+		loadCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		if err := jwks.Refresh(loadCtx); err != nil {
+			logger.Error("Could not load JWKS", "endpoint", e.Name, "error", err)
+		}
+		cancel()
+		go jwks.Run(ctx)
+	}
+
+	return jwt.NewKeys(keys, jwks, e.JWT.Algorithms)
+}
+
+// secretKeys returns the single secret followed by the rotation list as HMAC keys, an empty single secret is skipped.
+// Secrets of the list are labeled by position if they have no label or id.
+func secretKeys(endpoint, name, secret string, list []config.SecretConfig, logger *slog.Logger) ([]jwt.Key, error) {
+	var keys []jwt.Key
+	if secret != "" {
+		keys = append(keys, jwt.Key{Label: name, Key: []byte(secret)})
+	}
+
+	for i, s := range list {
+		if s.Secret == "" {
+			return nil, fmt.Errorf("%ss[%d] has no secret", name, i)
+		}
+		key := jwt.Key{ID: s.ID, Label: s.Label, Key: []byte(s.Secret)}
+		if key.Label == "" && key.ID == "" {
+			key.Label = fmt.Sprintf("%ss[%d]", name, i)
+		}
+
+		if s.NotAfter != "" {
+			notAfter, err := parseNotAfter(s.NotAfter)
+			if err != nil {
+				return nil, fmt.Errorf("%ss[%d]: %w", name, i, err)
+			}
+			if time.Now().After(notAfter) {
+				logger.Warn("Secret has expired", "endpoint", endpoint, "secret", fmt.Sprintf("%ss[%d]", name, i), "notAfter", s.NotAfter)
+			}
+			key.NotAfter = notAfter
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// parseNotAfter parses an RFC 3339 time, or a date which is valid until its end in UTC
+func parseNotAfter(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	date, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid NotAfter %q, expected an RFC 3339 time or a date", value)
+	}
+	return date.Add(24*time.Hour - time.Nanosecond), nil
+}
+
+// tcpServer returns the newline-delimited JSON over TCP server and its TLS config, nil if no certificate is set.
+func tcpServer(cfg *config.Config, logger *slog.Logger, hub *websocket.Hub, validator *jwt.Validator) (*tcp.Server, *tls.Config) {
+	server := tcp.NewServer(logger, hub, func(ctx context.Context, endpoint, token string) (*jwt.Claims, error) {
+		if _, exists := cfg.Endpoints[endpoint]; !exists {
+			return nil, fmt.Errorf("unknown endpoint %q", endpoint)
+		}
+		return validator.ValidateEndpointToken(ctx, token, endpoint)
+	})
+
+	if cfg.Global.TCP.TLSCert == "" && cfg.Global.TCP.TLSKey == "" {
+		return server, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.Global.TCP.TLSCert, cfg.Global.TCP.TLSKey)
+	if err != nil {
+		log.Fatalf("error loading TCP TLS certificate: %v", err)
+	}
+	return server, &tls.Config{Certificates: []tls.Certificate{cert}}
+}
+
 // configEndpoints returns a string with the names of the endpoints in the config.
 func configEndpoints(c *config.Config) string {
+	output := "Loaded Driplet config endpoints:"
+	output += "  " + strings.Join(endpointNames(c), ", ")
+
+	return output
+}
+
+// endpointNames returns the sorted names of the endpoints in the config, their settings hold secrets and are not logged.
+func endpointNames(c *config.Config) []string {
 	var endpoints []string
 	for _, e := range c.Endpoints {
 		endpoints = append(endpoints, e.Name)
 	}
-
-	output := "Loaded Driplet config endpoints:"
-	output += "  " + strings.Join(endpoints, ", ")
-
-	return output
+	slices.Sort(endpoints)
+	return endpoints
 }
 
 // splash returns the splash screen for Driplet.
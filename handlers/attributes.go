@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/make0x20/driplet/internal/config"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/websocket"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// attributesRequest is an attributes API request, match selects clients by the claims of their token
+type attributesRequest struct {
+	Match  map[string]interface{} `json:"match"`
+	Set    map[string]interface{} `json:"set"`
+	Remove []string               `json:"remove"`
+}
+
+// UpdateAttributes handles attribute updates on the API endpoint, the targeting attributes of matching live connections are set or removed
+func UpdateAttributes(logger *slog.Logger, cfg *config.Config, hub *websocket.Hub, validator *jwt.Validator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoint := r.PathValue("name")
+
+		// Check if endpoint exists - is valid
+		if _, exists := cfg.Endpoints[endpoint]; !exists {
+			logger.Debug("Invalid endpoint", "endpoint", endpoint)
+			http.Error(w, "Invalid endpoint", http.StatusNotFound)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			logger.Error("Error reading body", "error", err)
+			http.Error(w, "Error reading body", http.StatusInternalServerError)
+			return
+		}
+
+		// Validate signature from header
+		signature := r.Header.Get("X-Driplet-Signature")
+		if signature == "" {
+			logger.Debug("Missing signature", "endpoint", endpoint)
+			http.Error(w, "Missing signature", http.StatusUnauthorized)
+			return
+		}
+		if err := validator.ValidateEndpointAPIToken(signature, body, endpoint); err != nil {
+			logger.Debug("Invalid signature", "endpoint", endpoint, "error", err)
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var req attributesRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			logger.Debug("Invalid attributes format", "endpoint", endpoint, "error", err)
+			http.Error(w, "Invalid attributes format", http.StatusBadRequest)
+			return
+		}
+
+		updated, err := hub.UpdateAttributes(endpoint, req.Match, req.Set, req.Remove)
+		if err != nil {
+			logger.Debug("Invalid attributes update", "endpoint", endpoint, "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"updated": updated})
+	}
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"github.com/make0x20/driplet/internal/config"
+	"github.com/make0x20/driplet/internal/graphql"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/websocket"
+	"log/slog"
+	"net/http"
+)
+
+// GraphQL handles graphql-transport-ws connections on the API endpoint, the JWT is sent in connection_init
+func GraphQL(logger *slog.Logger, cfg *config.Config, hub *websocket.Hub, server *graphql.Server, validator *jwt.Validator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoint := r.PathValue("name")
+
+		// Check if endpoint exists and has GraphQL enabled
+		endpointCfg, exists := cfg.Endpoints[endpoint]
+		if !exists || !endpointCfg.GraphQL.Enabled {
+			logger.Debug("Invalid GraphQL endpoint", "endpoint", endpoint)
+			http.Error(w, "Invalid endpoint", http.StatusNotFound)
+			return
+		}
+
+		// Map subscription root fields to topic templates
+		mapping := make(graphql.Mapping, len(endpointCfg.GraphQL.Subscriptions))
+		for _, sub := range endpointCfg.GraphQL.Subscriptions {
+			mapping[sub.Field] = sub.Topic
+		}
+
+		conn, err := hub.Upgrade(w, r, endpoint, http.Header{"Sec-Websocket-Protocol": {graphql.Subprotocol}})
+		if err != nil {
+			logger.Error("Could not upgrade connection", "error", err)
+			return
+		}
+
+		server.Serve(conn, endpoint, mapping, func(token string) (*jwt.Claims, error) {
+			return validator.ValidateEndpointRequest(r, token, endpoint)
+		})
+	}
+}
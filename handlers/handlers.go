@@ -3,8 +3,10 @@ package handlers
 import (
 	"github.com/make0x20/driplet/internal/config"
 	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/sse"
 	"github.com/make0x20/driplet/internal/websocket"
 	"encoding/json"
+	gorilla "github.com/gorilla/websocket"
 	"io"
 	"log/slog"
 	"net/http"
@@ -38,7 +40,7 @@ func WebSocket(logger *slog.Logger, cfg *config.Config, hub *websocket.Hub, vali
 		}
 
 		// Validate JWT token
-		claims, err := validator.ValidateClientToken(token, cfg.Endpoints[endpoint].JWTSecret)
+		claims, err := validator.ValidateEndpointRequest(r, token, endpoint)
 		if err != nil {
 			logger.Debug("Invalid token", "endpoint", endpoint, "error", err)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -90,7 +92,7 @@ func PublishMessage(logger *slog.Logger, cfg *config.Config, hub *websocket.Hub,
 		}
 
 		// Validate JWT token
-		if err := validator.ValidateAPIToken(signature, body, endpoint, cfg.Endpoints[endpoint].APISecret); err != nil {
+		if err := validator.ValidateEndpointAPIToken(signature, body, endpoint); err != nil {
 			logger.Debug("Invalid signature", "endpoint", endpoint, "error", err)
 			http.Error(w, "Invalid signature", http.StatusUnauthorized)
 			return
@@ -104,6 +106,15 @@ func PublishMessage(logger *slog.Logger, cfg *config.Config, hub *websocket.Hub,
 			return
 		}
 
+		// Check that HTML fragment messages can be rendered
+		if msg.Format != "" {
+			if _, err := sse.Fragment(msg.Format, msg.Event, msg.Message); err != nil {
+				logger.Debug("Invalid message format", "endpoint", endpoint, "format", msg.Format, "error", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
 		// Set endpoint from URL parameter
 		msg.Endpoint = endpoint
 
@@ -139,3 +150,16 @@ func Ping(logger *slog.Logger, cfg *config.Config) http.HandlerFunc {
 		})
 	}
 }
+
+// Subprotocols dispatches WebSocket connections to the handler of the first subprotocol offered by the client
+func Subprotocols(fallback http.HandlerFunc, protocols map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, protocol := range gorilla.Subprotocols(r) {
+			if handler, ok := protocols[protocol]; ok {
+				handler(w, r)
+				return
+			}
+		}
+		fallback(w, r)
+	}
+}
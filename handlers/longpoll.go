@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/make0x20/driplet/internal/config"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/longpoll"
+	"github.com/make0x20/driplet/internal/websocket"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LongPollSession creates a long-polling session on the API endpoint
+func LongPollSession(logger *slog.Logger, cfg *config.Config, polls *longpoll.Manager, validator *jwt.Validator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoint := r.PathValue("name")
+
+		// Check if endpoint exists - is valid
+		if _, exists := cfg.Endpoints[endpoint]; !exists {
+			logger.Debug("Invalid endpoint", "endpoint", endpoint)
+			http.Error(w, "Invalid endpoint", http.StatusNotFound)
+			return
+		}
+
+		// Token is passed as a bearer token or query parameter
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+
+		// Validate JWT token
+		claims, err := validator.ValidateEndpointRequest(r, token, endpoint)
+		if err != nil {
+			logger.Debug("Invalid token", "endpoint", endpoint, "error", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		session, err := polls.Create(endpoint, claims)
+		if err != nil {
+			logger.Error("Could not create session", "error", err)
+			http.Error(w, "Could not create session", http.StatusInternalServerError)
+			return
+		}
+
+		// Respond with the session id
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"session":      session.ID,
+			"idle_timeout": cfg.Global.PollSessionTimeout,
+		})
+	}
+}
+
+// LongPoll holds a poll request until messages arrive or the timeout passes
+func LongPoll(logger *slog.Logger, cfg *config.Config, polls *longpoll.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := polls.Get(r.PathValue("name"), r.PathValue("session"))
+		if !ok {
+			http.Error(w, "Invalid session", http.StatusNotFound)
+			return
+		}
+
+		// Clients may ask for a shorter timeout than the configured one
+		timeout := time.Duration(cfg.Global.PollTimeout) * time.Second
+		if requested, err := strconv.Atoi(r.URL.Query().Get("timeout")); err == nil && requested >= 0 {
+			timeout = min(timeout, time.Duration(requested)*time.Second)
+		}
+
+		// Messages of earlier polls are acknowledged up to the cursor, or all of them without one
+		ack := longpoll.AckAll
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			parsed, err := strconv.ParseUint(cursor, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+			ack = parsed
+		}
+
+		result, err := polls.Poll(r.Context(), session, ack, timeout)
+		if errors.Is(err, longpoll.ErrPollInProgress) {
+			http.Error(w, "Poll already in progress", http.StatusConflict)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// LongPollSubscribe subscribes or unsubscribes a long-polling session
+func LongPollSubscribe(logger *slog.Logger, polls *longpoll.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := polls.Get(r.PathValue("name"), r.PathValue("session"))
+		if !ok {
+			http.Error(w, "Invalid session", http.StatusNotFound)
+			return
+		}
+
+		// Parse subscription
+		var msg websocket.SubscriptionMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			http.Error(w, "Invalid message format", http.StatusBadRequest)
+			return
+		}
+
+		switch r.PathValue("action") {
+		case websocket.MessageTypeSubscribe:
+			if err := session.Subscribe(msg); err != nil {
+				logger.Debug("Invalid subscription", "topic", msg.Topic, "error", err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(websocket.ErrorMessage{
+					Type:  websocket.MessageTypeError,
+					Topic: msg.Topic,
+					Error: err.Error(),
+				})
+				return
+			}
+		case websocket.MessageTypeUnsubscribe:
+			session.Unsubscribe(msg.Topic)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// LongPollClose closes a long-polling session
+func LongPollClose(polls *longpoll.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := polls.Get(r.PathValue("name"), r.PathValue("session"))
+		if !ok {
+			http.Error(w, "Invalid session", http.StatusNotFound)
+			return
+		}
+
+		polls.Close(session)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
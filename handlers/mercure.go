@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/make0x20/driplet/internal/config"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/mercure"
+	"github.com/make0x20/driplet/internal/sse"
+	"github.com/make0x20/driplet/internal/websocket"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MercureSubscribe streams updates to Mercure subscribers on the API endpoint
+func MercureSubscribe(logger *slog.Logger, cfg *config.Config, hub *websocket.Hub, validator *jwt.Validator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoint := r.PathValue("name")
+
+		// Check if endpoint exists and has Mercure enabled
+		endpointCfg, exists := cfg.Endpoints[endpoint]
+		if !exists || !endpointCfg.Mercure.Enabled {
+			logger.Debug("Invalid Mercure endpoint", "endpoint", endpoint)
+			http.Error(w, "Invalid endpoint", http.StatusNotFound)
+			return
+		}
+		mercureCORS(w, r, endpointCfg.Mercure)
+
+		// Anonymous subscribers only receive public updates
+		claims := &jwt.Claims{}
+		token := mercureToken(r, true)
+		if token == "" && !endpointCfg.Mercure.Anonymous {
+			logger.Debug("Missing Mercure token", "endpoint", endpoint)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if token != "" {
+			var err error
+			claims, err = validator.ValidateEndpointToken(r.Context(), token, endpoint)
+			if err != nil {
+				logger.Debug("Invalid token", "endpoint", endpoint, "error", err)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		topics := r.URL.Query()["topic"]
+		if len(topics) == 0 {
+			http.Error(w, "Missing topic parameter", http.StatusBadRequest)
+			return
+		}
+
+		// Reconnecting subscribers receive the updates published after their last event id from the history
+		lastEventID := r.Header.Get("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = r.URL.Query().Get("lastEventID")
+		}
+		since := mercureSince(hub, endpoint, topics, lastEventID)
+
+		// Private updates are delivered for topics matching the mercure.subscribe selectors
+		authorized := mercure.NewSelectors(mercure.ClaimFrom(claims.Raw).Subscribe)
+		client := hub.Attach(endpoint, claims, websocket.WithAuthorizer(authorized.Match))
+		defer client.Detach()
+
+		for _, topic := range topics {
+			var err error
+			if mercure.IsPattern(topic) {
+				var selector *mercure.Selector
+				if selector, err = mercure.NewSelector(topic); err == nil {
+					err = client.SubscribePattern(topic, selector.Match, "")
+				}
+			} else {
+				sub := websocket.SubscriptionMessage{
+					Type:  websocket.MessageTypeSubscribe,
+					Topic: topic,
+				}
+				if sequence, ok := since[topic]; ok {
+					sub.Since = &sequence
+				}
+				err = client.Subscribe(sub)
+			}
+			if err != nil {
+				logger.Debug("Invalid topic selector", "endpoint", endpoint, "topic", topic, "error", err)
+				http.Error(w, fmt.Sprintf("Invalid topic selector: %s", topic), http.StatusBadRequest)
+				return
+			}
+		}
+
+		stream, err := sse.NewWriter(w)
+		if err != nil {
+			logger.Error("Could not start event stream", "error", err)
+			http.Error(w, "Could not start event stream", http.StatusInternalServerError)
+			return
+		}
+
+		// Heartbeat comments keep proxies from closing idle streams
+		heartbeat := time.NewTicker(hub.PingInterval())
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+
+			case <-heartbeat.C:
+				if err := stream.WriteComment("heartbeat"); err != nil {
+					return
+				}
+
+			case delivery, ok := <-client.Deliveries():
+				if !ok {
+					return
+				}
+				msg := delivery.Message
+				if msg == nil {
+					continue
+				}
+
+				data, _ := websocket.DecodePayload(msg.Message)
+				if err := stream.WriteEvent(sse.Event{
+					ID:    mercureID(*msg),
+					Event: msg.Event,
+					Data:  data,
+				}); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// MercurePreflight answers CORS preflight requests of Mercure subscribers and publishers on the API endpoint
+func MercurePreflight(logger *slog.Logger, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoint := r.PathValue("name")
+
+		// Check if endpoint exists and has Mercure enabled
+		endpointCfg, exists := cfg.Endpoints[endpoint]
+		if !exists || !endpointCfg.Mercure.Enabled {
+			logger.Debug("Invalid Mercure endpoint", "endpoint", endpoint)
+			http.Error(w, "Invalid endpoint", http.StatusNotFound)
+			return
+		}
+
+		// Only allowed origins get the allowed methods and headers
+		mercureCORS(w, r, endpointCfg.Mercure)
+		if w.Header().Get("Access-Control-Allow-Origin") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Cache-Control, Content-Type, Last-Event-ID")
+			w.Header().Set("Access-Control-Max-Age", "600")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// MercurePublish handles Mercure form-encoded publish requests on the API endpoint
+func MercurePublish(logger *slog.Logger, cfg *config.Config, hub *websocket.Hub, validator *jwt.Validator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoint := r.PathValue("name")
+
+		// Check if endpoint exists and has Mercure enabled
+		endpointCfg, exists := cfg.Endpoints[endpoint]
+		if !exists || !endpointCfg.Mercure.Enabled {
+			logger.Debug("Invalid Mercure endpoint", "endpoint", endpoint)
+			http.Error(w, "Invalid endpoint", http.StatusNotFound)
+			return
+		}
+		mercureCORS(w, r, endpointCfg.Mercure)
+
+		// Publisher tokens are signed with one of the API secrets
+		claims, err := validator.ValidatePublisherToken(mercureToken(r, false), endpoint)
+		if err != nil {
+			logger.Debug("Invalid publisher token", "endpoint", endpoint, "error", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid form", http.StatusBadRequest)
+			return
+		}
+		topics := r.PostForm["topic"]
+		if len(topics) == 0 {
+			http.Error(w, "Missing topic parameter", http.StatusBadRequest)
+			return
+		}
+
+		// The publisher must be allowed to publish to every topic of the update
+		allowed := mercure.NewSelectors(mercure.ClaimFrom(claims.Raw).Publish)
+		for _, topic := range topics {
+			if !allowed.Match(topic) {
+				logger.Debug("Publisher not allowed to publish to topic", "endpoint", endpoint, "topic", topic)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+
+		id := r.PostForm.Get("id")
+		if id == "" {
+			id = mercure.NewUpdateID()
+		}
+		data, _ := json.Marshal(r.PostForm.Get("data"))
+
+		msg := websocket.BroadcastMessage{
+			Message:  data,
+			Endpoint: endpoint,
+			Topic:    topics[0],
+			Topics:   topics[1:],
+			ID:       id,
+			Event:    r.PostForm.Get("type"),
+			Private:  r.PostForm.Has("private"),
+		}
+
+		// Broadcast the update
+		if err := hub.Broadcast(msg); err != nil {
+			logger.Error("Error broadcasting message", "error", err)
+			http.Error(w, "Error broadcasting message", http.StatusInternalServerError)
+			return
+		}
+
+		// Respond with the update id
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(id))
+	}
+}
+
+// mercureToken returns the JWT from the Authorization header, the mercureAuthorization cookie
+// or, for subscribers, the authorization query parameter
+func mercureToken(r *http.Request, subscriber bool) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if cookie, err := r.Cookie("mercureAuthorization"); err == nil {
+		return cookie.Value
+	}
+	if subscriber {
+		return r.URL.Query().Get("authorization")
+	}
+	return ""
+}
+
+// mercureID returns the update id of a message, messages published through the driplet API have none
+// and get one from their topic and sequence. Messages without history have no sequence to resume from,
+// they get a random id.
+func mercureID(msg websocket.BroadcastMessage) string {
+	if msg.ID != "" {
+		return msg.ID
+	}
+	if msg.Sequence == 0 {
+		return mercure.NewUpdateID()
+	}
+	return fmt.Sprintf("urn:driplet:%s:%d", msg.Topic, msg.Sequence)
+}
+
+// mercureSince returns the sequences the exact topics are replayed from for a last event id.
+// "earliest" replays the whole history, unknown ids replay nothing.
+func mercureSince(hub *websocket.Hub, endpoint string, topics []string, lastEventID string) map[string]uint64 {
+	if lastEventID == "" {
+		return nil
+	}
+
+	var exact []string
+	for _, topic := range topics {
+		if !mercure.IsPattern(topic) {
+			exact = append(exact, topic)
+		}
+	}
+	if lastEventID == "earliest" {
+		since := make(map[string]uint64, len(exact))
+		for _, topic := range exact {
+			since[topic] = 0
+		}
+		return since
+	}
+
+	since, _ := hub.HistoryPosition(endpoint, exact, func(msg websocket.BroadcastMessage) bool {
+		return mercureID(msg) == lastEventID
+	})
+	return since
+}
+
+// mercureCORS allows cross-origin requests with credentials from the configured origins
+func mercureCORS(w http.ResponseWriter, r *http.Request, cfg config.MercureConfig) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	if slices.Contains(cfg.CorsOrigins, "*") || slices.Contains(cfg.CorsOrigins, origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Add("Vary", "Origin")
+	}
+}
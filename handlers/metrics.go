@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"github.com/make0x20/driplet/internal/config"
+	"github.com/make0x20/driplet/internal/websocket"
+	"net/http"
+)
+
+// Metrics serves the hub metrics in the Prometheus text format, requiring the bearer token if one is configured
+func Metrics(cfg *config.Config, hub *websocket.Hub) http.HandlerFunc {
+	metrics := hub.Metrics().Handler()
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := cfg.Global.Metrics.Token; token != "" {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		metrics.ServeHTTP(w, r)
+	}
+}
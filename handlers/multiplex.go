@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/make0x20/driplet/internal/config"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/multiplex"
+	"github.com/make0x20/driplet/internal/websocket"
+	"log/slog"
+	"net/http"
+)
+
+// Multiplex handles WebSocket connections carrying several endpoints, each channel authenticates with its own token
+func Multiplex(logger *slog.Logger, cfg *config.Config, hub *websocket.Hub, server *multiplex.Server, validator *jwt.Validator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Channels are authenticated after the upgrade, compression settings of endpoints do not apply
+		conn, err := hub.Upgrade(w, r, "", nil)
+		if err != nil {
+			logger.Error("Could not upgrade connection", "error", err)
+			return
+		}
+
+		server.Serve(conn, func(endpoint, token string) (*jwt.Claims, error) {
+			// Check if endpoint exists - is valid
+			if _, exists := cfg.Endpoints[endpoint]; !exists {
+				return nil, fmt.Errorf("invalid endpoint %q", endpoint)
+			}
+			return validator.ValidateEndpointRequest(r, token, endpoint)
+		})
+	}
+}
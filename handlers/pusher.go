@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	gorilla "github.com/gorilla/websocket"
+	"github.com/make0x20/driplet/internal/config"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/pusher"
+	"github.com/make0x20/driplet/internal/websocket"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// pusherEvent is a single event of a Pusher REST API trigger request
+type pusherEvent struct {
+	Name     string   `json:"name"`
+	Data     string   `json:"data"`
+	Channels []string `json:"channels"`
+	Channel  string   `json:"channel"`
+	SocketID string   `json:"socket_id"`
+}
+
+// PusherWebSocket handles Pusher protocol WebSocket connections for the app key
+func PusherWebSocket(logger *slog.Logger, cfg *config.Config, hub *websocket.Hub, server *pusher.Server, validator *jwt.Validator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Check if an endpoint has Pusher enabled for the app key
+		app, exists := pusherApp(cfg, validator, func(c config.PusherConfig) bool {
+			return c.Key == r.PathValue("key")
+		})
+		if !exists {
+			logger.Debug("Invalid Pusher app key", "key", r.PathValue("key"))
+			http.Error(w, "Invalid app key", http.StatusNotFound)
+			return
+		}
+
+		conn, err := hub.Upgrade(w, r, app.Endpoint, nil)
+		if err != nil {
+			logger.Error("Could not upgrade connection", "error", err)
+			return
+		}
+
+		// Clients speaking an older protocol are told not to reconnect
+		if version, _ := strconv.Atoi(r.URL.Query().Get("protocol")); version != 0 && version < 5 {
+			conn.WriteMessage(gorilla.TextMessage, pusher.ErrorFrame("Unsupported protocol version", pusher.CodeUnsupportedProtocol))
+			conn.Close()
+			return
+		}
+
+		server.Serve(conn, app)
+	}
+}
+
+// PusherEvents handles Pusher REST API trigger and batch trigger requests
+func PusherEvents(logger *slog.Logger, cfg *config.Config, hub *websocket.Hub, validator *jwt.Validator, batch bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Check if an endpoint has Pusher enabled for the app id
+		app, exists := pusherApp(cfg, validator, func(c config.PusherConfig) bool {
+			return c.AppID == r.PathValue("id")
+		})
+		if !exists {
+			logger.Debug("Invalid Pusher app id", "id", r.PathValue("id"))
+			http.Error(w, "Invalid app id", http.StatusNotFound)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			logger.Error("Error reading body", "error", err)
+			http.Error(w, "Error reading body", http.StatusInternalServerError)
+			return
+		}
+
+		// Validate the request signature
+		if err := pusher.VerifyRequest(app, r.Method, r.URL.Path, r.URL.Query(), body, time.Now()); err != nil {
+			logger.Debug("Invalid Pusher request signature", "endpoint", app.Endpoint, "error", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		var events []pusherEvent
+		if batch {
+			var request struct {
+				Batch []pusherEvent `json:"batch"`
+			}
+			err = json.Unmarshal(body, &request)
+			events = request.Batch
+		} else {
+			var event pusherEvent
+			err = json.Unmarshal(body, &event)
+			events = []pusherEvent{event}
+		}
+		if err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		for _, event := range events {
+			if event.Channel != "" {
+				event.Channels = append(event.Channels, event.Channel)
+			}
+			if event.Name == "" || len(event.Channels) == 0 {
+				http.Error(w, "Missing event name or channels", http.StatusBadRequest)
+				return
+			}
+
+			// Every channel is published separately so private channels stay private
+			for _, channel := range event.Channels {
+				if err := hub.Broadcast(websocket.BroadcastMessage{
+					Message:       websocket.EncodePayload([]byte(event.Data)),
+					Endpoint:      app.Endpoint,
+					Topic:         channel,
+					Event:         event.Name,
+					Private:       pusher.IsPrivate(channel),
+					ExcludeClient: event.SocketID,
+				}); err != nil {
+					logger.Debug("Error broadcasting message", "endpoint", app.Endpoint, "error", err)
+					http.Error(w, fmt.Sprintf("Error broadcasting message: %s", err), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}
+}
+
+// pusherApp returns the Pusher app of the first endpoint with Pusher enabled accepted by match
+func pusherApp(cfg *config.Config, validator *jwt.Validator, match func(config.PusherConfig) bool) (pusher.App, bool) {
+	for name, endpoint := range cfg.Endpoints {
+		if endpoint.Pusher.Enabled && match(endpoint.Pusher) {
+			return pusher.App{
+				ID:       endpoint.Pusher.AppID,
+				Key:      endpoint.Pusher.Key,
+				Secrets:  validator.APISecrets(name),
+				Endpoint: name,
+			}, true
+		}
+	}
+	return pusher.App{}, false
+}
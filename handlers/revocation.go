@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/make0x20/driplet/internal/config"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/revocation"
+	"github.com/make0x20/driplet/internal/websocket"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// revokeRequest is a revocation API request, the expiry is a unix timestamp or a number of seconds
+type revokeRequest struct {
+	JTI       string      `json:"jti"`
+	Sub       string      `json:"sub"`
+	Claim     string      `json:"claim"`
+	Value     interface{} `json:"value"`
+	ExpiresAt int64       `json:"expires_at"`
+	TTL       int64       `json:"ttl"`
+}
+
+// Revoke handles revocation requests on the API endpoint, matching live connections are kicked
+func Revoke(logger *slog.Logger, cfg *config.Config, hub *websocket.Hub, validator *jwt.Validator, revocations *revocation.List) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoint := r.PathValue("name")
+
+		// Check if endpoint exists - is valid
+		if _, exists := cfg.Endpoints[endpoint]; !exists {
+			logger.Debug("Invalid endpoint", "endpoint", endpoint)
+			http.Error(w, "Invalid endpoint", http.StatusNotFound)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			logger.Error("Error reading body", "error", err)
+			http.Error(w, "Error reading body", http.StatusInternalServerError)
+			return
+		}
+
+		// Validate signature from header
+		signature := r.Header.Get("X-Driplet-Signature")
+		if signature == "" {
+			logger.Debug("Missing signature", "endpoint", endpoint)
+			http.Error(w, "Missing signature", http.StatusUnauthorized)
+			return
+		}
+		if err := validator.ValidateEndpointAPIToken(signature, body, endpoint); err != nil {
+			logger.Debug("Invalid signature", "endpoint", endpoint, "error", err)
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var req revokeRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			logger.Debug("Invalid revocation format", "endpoint", endpoint, "error", err)
+			http.Error(w, "Invalid revocation format", http.StatusBadRequest)
+			return
+		}
+
+		entry := revocation.Entry{
+			Endpoint: endpoint,
+			JTI:      req.JTI,
+			Sub:      req.Sub,
+			Claim:    req.Claim,
+			Value:    req.Value,
+		}
+		switch {
+		case req.ExpiresAt > 0:
+			entry.ExpiresAt = time.Unix(req.ExpiresAt, 0)
+		case req.TTL > 0:
+			entry.ExpiresAt = time.Now().Add(time.Duration(req.TTL) * time.Second)
+		}
+		if err := entry.Validate(); err != nil {
+			logger.Debug("Invalid revocation", "endpoint", endpoint, "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := revocations.Revoke(entry); err != nil {
+			logger.Error("Error saving revocation list", "error", err)
+			http.Error(w, "Error saving revocation list", http.StatusInternalServerError)
+			return
+		}
+
+		// Close live connections of revoked tokens right away
+		kicked := hub.Kick(endpoint, func(claims *jwt.Claims) bool {
+			return entry.Matches(claims.Raw)
+		})
+		logger.Info("Revoked tokens", "endpoint", endpoint, "jti", entry.JTI, "sub", entry.Sub, "claim", entry.Claim, "disconnected", kicked)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"disconnected": kicked})
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/make0x20/driplet/internal/config"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/sse"
+	"github.com/make0x20/driplet/internal/websocket"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SSE streams messages as server-sent events on the API endpoint
+func SSE(logger *slog.Logger, cfg *config.Config, hub *websocket.Hub, validator *jwt.Validator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoint := r.PathValue("name")
+		query := r.URL.Query()
+
+		// Check if endpoint exists - is valid
+		if _, exists := cfg.Endpoints[endpoint]; !exists {
+			logger.Debug("Invalid endpoint", "endpoint", endpoint)
+			http.Error(w, "Invalid endpoint", http.StatusNotFound)
+			return
+		}
+
+		// Validate JWT token
+		claims, err := validator.ValidateEndpointRequest(r, query.Get("token"), endpoint)
+		if err != nil {
+			logger.Debug("Invalid token", "endpoint", endpoint, "error", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// Topics are passed as query parameters
+		topics := query["topic"]
+		if len(topics) == 0 {
+			http.Error(w, "At least one topic is required", http.StatusBadRequest)
+			return
+		}
+		history, _ := strconv.Atoi(query.Get("history"))
+
+		// Resume from the last received event, EventSource polyfills may pass it as a query parameter
+		lastEventID := r.Header.Get("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = query.Get("lastEventId")
+		}
+		sequences := sse.ParseEventID(lastEventID)
+
+		// Subscribe before the stream starts so invalid subscriptions can be rejected
+		client := hub.Attach(endpoint, claims)
+		defer client.Detach()
+
+		for _, topic := range topics {
+			sub := websocket.SubscriptionMessage{
+				Type:    websocket.MessageTypeSubscribe,
+				Topic:   topic,
+				Filter:  query.Get("filter"),
+				History: history,
+			}
+			if seq, ok := sequences[topic]; ok {
+				sub.Since = &seq
+			}
+			if err := client.Subscribe(sub); err != nil {
+				logger.Debug("Invalid subscription", "endpoint", endpoint, "topic", topic, "error", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		stream, err := sse.NewWriter(w)
+		if err != nil {
+			logger.Error("Could not start event stream", "error", err)
+			http.Error(w, "Could not start event stream", http.StatusInternalServerError)
+			return
+		}
+
+		// Heartbeat comments keep proxies from closing idle streams
+		heartbeat := time.NewTicker(hub.PingInterval())
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+
+			case <-heartbeat.C:
+				if err := stream.WriteComment("heartbeat"); err != nil {
+					return
+				}
+
+			case delivery, ok := <-client.Deliveries():
+				if !ok {
+					// Tell the client why the stream ended and when to reconnect
+					hint := client.CloseReason().Hint()
+					data, _ := json.Marshal(hint)
+					stream.WriteEvent(sse.Event{
+						Event: "close",
+						Data:  data,
+						Retry: int(hint.RetryAfter * 1000),
+					})
+					return
+				}
+
+				event := sse.Event{Data: delivery.Data}
+				if msg := delivery.Message; msg != nil {
+					// HTML fragments are sent as is for Turbo and htmx
+					if msg.Format != "" {
+						fragment, err := sse.Fragment(msg.Format, msg.Event, msg.Message)
+						if err != nil {
+							logger.Debug("Could not render message", "endpoint", endpoint, "format", msg.Format, "error", err)
+							continue
+						}
+						event = fragment
+					}
+					// Resume from the topic the subscription matched, messages without history have no sequence
+					if sequence := msg.Sequences[delivery.Topic]; sequence != 0 {
+						sequences[delivery.Topic] = sequence
+						event.ID = sse.EventID(sequences)
+					}
+				}
+				if err := stream.WriteEvent(event); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
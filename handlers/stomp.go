@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"github.com/make0x20/driplet/internal/config"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/stomp"
+	"github.com/make0x20/driplet/internal/websocket"
+	"log/slog"
+	"net/http"
+)
+
+// STOMP handles STOMP over WebSocket connections on the API endpoint, the JWT is sent in the CONNECT headers
+func STOMP(logger *slog.Logger, cfg *config.Config, hub *websocket.Hub, server *stomp.Server, validator *jwt.Validator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoint := r.PathValue("name")
+
+		// Check if endpoint exists and has STOMP enabled
+		endpointCfg, exists := cfg.Endpoints[endpoint]
+		if !exists || !endpointCfg.STOMP.Enabled {
+			logger.Debug("Invalid STOMP endpoint", "endpoint", endpoint)
+			http.Error(w, "Invalid endpoint", http.StatusNotFound)
+			return
+		}
+
+		conn, err := hub.Upgrade(w, r, endpoint, http.Header{"Sec-Websocket-Protocol": {stomp.Subprotocol}})
+		if err != nil {
+			logger.Error("Could not upgrade connection", "error", err)
+			return
+		}
+
+		server.Serve(conn, endpoint, func(token string) (*jwt.Claims, error) {
+			return validator.ValidateEndpointRequest(r, token, endpoint)
+		})
+	}
+}
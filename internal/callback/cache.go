@@ -0,0 +1,61 @@
+package callback
+
+import (
+	"sync"
+	"time"
+)
+
+// cacheEntry is a cached value with its expiry
+type cacheEntry[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+// Cache is a bounded cache of callback results keyed by a hash, e.g. of a token
+type Cache[V any] struct {
+	size    int
+	timeNow func() time.Time
+
+	mu      sync.Mutex
+	entries map[[32]byte]cacheEntry[V]
+}
+
+// NewCache creates a cache of at most size entries, timeNow returns the current time
+func NewCache[V any](size int, timeNow func() time.Time) *Cache[V] {
+	return &Cache[V]{
+		size:    size,
+		timeNow: timeNow,
+		entries: make(map[[32]byte]cacheEntry[V]),
+	}
+}
+
+// Get returns a value that has not expired
+func (c *Cache[V]) Get(key [32]byte) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !c.timeNow().Before(entry.expiresAt) {
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// Set caches a value until expiresAt. Expired values are dropped when the cache is full,
+// the value is not cached if it is still full.
+func (c *Cache[V]) Set(key [32]byte, value V, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.size {
+		now := c.timeNow()
+		for k, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.size {
+			return
+		}
+	}
+	c.entries[key] = cacheEntry[V]{value: value, expiresAt: expiresAt}
+}
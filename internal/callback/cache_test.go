@@ -0,0 +1,34 @@
+package callback
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCache verifies the bounded cache:
+// - Values are returned until they expire
+// - Expired values are dropped when the cache is full, new values are not cached while it is still full
+func TestCache(t *testing.T) {
+	now := time.Now()
+	cache := NewCache[string](2, func() time.Time { return now })
+
+	cache.Set([32]byte{1}, "one", now.Add(time.Minute))
+	cache.Set([32]byte{2}, "two", now.Add(time.Hour))
+	if value, ok := cache.Get([32]byte{1}); !ok || value != "one" {
+		t.Errorf("expected a cached value, got %q", value)
+	}
+
+	cache.Set([32]byte{3}, "three", now.Add(time.Hour))
+	if _, ok := cache.Get([32]byte{3}); ok {
+		t.Error("expected no value cached while the cache is full")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := cache.Get([32]byte{1}); ok {
+		t.Error("expected the value to expire")
+	}
+	cache.Set([32]byte{3}, "three", now.Add(time.Hour))
+	if value, ok := cache.Get([32]byte{3}); !ok || value != "three" {
+		t.Errorf("expected expired values to make room, got %q", value)
+	}
+}
@@ -0,0 +1,107 @@
+// Package callback calls backends with signed JSON requests and caches their results.
+package callback
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultTimeout is the default time allowed for a callback
+	DefaultTimeout = 5 * time.Second
+	// maxResponseSize is the maximum size of a callback response
+	maxResponseSize = 1 << 20
+)
+
+// Stamp is the nonce and timestamp of a signed request body, it is embedded in the body types
+type Stamp struct {
+	Nonce     string `json:"nonce"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// SetStamp sets the nonce and timestamp of the body
+func (s *Stamp) SetStamp(nonce string, timestamp int64) {
+	s.Nonce = nonce
+	s.Timestamp = timestamp
+}
+
+// Stamped is a request body with a Stamp
+type Stamped interface {
+	SetStamp(nonce string, timestamp int64)
+}
+
+// Client posts JSON bodies signed like API requests to a backend
+type Client struct {
+	// name prefixes errors, e.g. "session"
+	name   string
+	url    string
+	secret func() string
+	client *http.Client
+}
+
+// New creates a callback client, secret is called for every callback so rotated secrets apply. A zero timeout uses the default.
+func New(name, url string, secret func() string, timeout time.Duration) *Client {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Client{
+		name:   name,
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Post stamps the body with a random nonce and the current time, posts it signed in the X-Driplet-Signature header
+// and returns the response status and body
+func (c *Client) Post(ctx context.Context, body Stamped) (int, []byte, error) {
+	secret := c.secret()
+	if secret == "" {
+		return 0, nil, fmt.Errorf("%s callback failed: no API secret to sign it with", c.name)
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, nil, err
+	}
+	body.SetStamp(hex.EncodeToString(nonce), time.Now().Unix())
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(data))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Driplet-Signature", Sign(secret, data))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s callback failed: %w", c.name, err)
+	}
+	defer resp.Body.Close()
+
+	response, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s callback failed: %w", c.name, err)
+	}
+	return resp.StatusCode, response, nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 signature of a callback body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,48 @@
+package callback
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestPost verifies signed callbacks:
+// - The body is stamped with a nonce and timestamp and signed with the current secret
+// - The response status and body are returned
+// - Callbacks without a secret are not sent
+func TestPost(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Driplet-Signature") != Sign("api-secret", body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var stamp Stamp
+		if err := json.Unmarshal(body, &stamp); err != nil || stamp.Nonce == "" || stamp.Timestamp == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	secret := "api-secret"
+	client := New("test", server.URL, func() string { return secret }, 0)
+	status, body, err := client.Post(context.Background(), &Stamp{})
+	if err != nil || status != http.StatusOK || string(body) != `{"ok":true}` {
+		t.Fatalf("expected a signed callback, got %d %s: %v", status, body, err)
+	}
+
+	secret = "rotated"
+	if status, _, _ := client.Post(context.Background(), &Stamp{}); status != http.StatusUnauthorized {
+		t.Errorf("expected the rotated secret to sign the callback, got %d", status)
+	}
+
+	secret = ""
+	if _, _, err := client.Post(context.Background(), &Stamp{}); err == nil {
+		t.Error("expected an error without a secret")
+	}
+}
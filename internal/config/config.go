@@ -5,6 +5,7 @@ import (
 	"github.com/pelletier/go-toml/v2"
 	"github.com/spf13/viper"
 	"os"
+	"sort"
 	"strings"
 )
 
@@ -16,10 +17,37 @@ type Config struct {
 
 // GlobalConfig is the global config struct
 type GlobalConfig struct {
+	BindAddress        string `mapstructure:"BindAddress"`
+	Port               int    `mapstructure:"Port"`
+	LogFile            string `mapstructure:"LogFile"`
+	LogLevel           string `mapstructure:"LogLevel"`
+	PingInterval       int    `mapstructure:"PingInterval"`
+	PollTimeout        int    `mapstructure:"PollTimeout"`
+	PollSessionTimeout int    `mapstructure:"PollSessionTimeout"`
+	// RevocationFile persists the revocation list of client tokens, it is kept in memory only by default
+	RevocationFile string `mapstructure:"RevocationFile"`
+
+	TCP     TCPConfig     `mapstructure:"TCP"`
+	Metrics MetricsConfig `mapstructure:"Metrics"`
+}
+
+// MetricsConfig is the metrics endpoint config struct
+type MetricsConfig struct {
+	Enabled bool `mapstructure:"Enabled"`
+	// Token is the bearer token required to read the metrics, not checked if empty
+	Token string `mapstructure:"Token"`
+	// BindAddress and Port are a separate metrics listener, metrics are served on the main listener if Port is 0
+	BindAddress string `mapstructure:"BindAddress"`
+	Port        int    `mapstructure:"Port"`
+}
+
+// TCPConfig is the newline-delimited JSON over TCP listener config struct
+type TCPConfig struct {
+	Enabled     bool   `mapstructure:"Enabled"`
 	BindAddress string `mapstructure:"BindAddress"`
 	Port        int    `mapstructure:"Port"`
-	LogFile     string `mapstructure:"LogFile"`
-	LogLevel    string `mapstructure:"LogLevel"`
+	TLSCert     string `mapstructure:"TLSCert"`
+	TLSKey      string `mapstructure:"TLSKey"`
 }
 
 // EndpointConfig is the endpoint config struct
@@ -27,6 +55,163 @@ type EndpointConfig struct {
 	Name      string `mapstructure:"Name"`
 	APISecret string `mapstructure:"APISecret"`
 	JWTSecret string `mapstructure:"JWTSecret"`
+	// APISecrets and JWTSecrets are additional secrets for rotation, tried in order after APISecret and JWTSecret
+	APISecrets []SecretConfig `mapstructure:"APISecrets"`
+	JWTSecrets []SecretConfig `mapstructure:"JWTSecrets"`
+
+	Auth        AuthConfig        `mapstructure:"Auth"`
+	JWT         JWTConfig         `mapstructure:"JWT"`
+	Compression CompressionConfig `mapstructure:"Compression"`
+	History     HistoryConfig     `mapstructure:"History"`
+	Mercure     MercureConfig     `mapstructure:"Mercure"`
+	Pusher      PusherConfig      `mapstructure:"Pusher"`
+	MQTT        MQTTConfig        `mapstructure:"MQTT"`
+	STOMP       STOMPConfig       `mapstructure:"STOMP"`
+	GraphQL     GraphQLConfig     `mapstructure:"GraphQL"`
+}
+
+// AuthConfig selects how clients of the endpoint authenticate
+type AuthConfig struct {
+	// Mode is jwt, introspection or session (default: jwt)
+	Mode          string              `mapstructure:"Mode"`
+	Introspection IntrospectionConfig `mapstructure:"Introspection"`
+	Session       SessionConfig       `mapstructure:"Session"`
+	// Enrichment adds attributes from a backend to the claims of authenticated clients
+	Enrichment EnrichmentConfig `mapstructure:"Enrichment"`
+}
+
+// EnrichmentConfig is the claims enrichment callback config struct
+type EnrichmentConfig struct {
+	URL string `mapstructure:"URL"`
+	// Timeout and CacheTTL are in seconds
+	Timeout  int `mapstructure:"Timeout"`
+	CacheTTL int `mapstructure:"CacheTTL"`
+	// FailOpen accepts clients without attributes when the callback fails
+	FailOpen bool `mapstructure:"FailOpen"`
+}
+
+// SessionConfig is the backend session callback config struct
+type SessionConfig struct {
+	URL string `mapstructure:"URL"`
+	// Cookies and Headers are the names forwarded from the client request
+	Cookies []string `mapstructure:"Cookies"`
+	Headers []string `mapstructure:"Headers"`
+	// Timeout is in seconds
+	Timeout int `mapstructure:"Timeout"`
+}
+
+// IntrospectionConfig is the OAuth 2.0 token introspection (RFC 7662) config struct
+type IntrospectionConfig struct {
+	URL          string `mapstructure:"URL"`
+	ClientID     string `mapstructure:"ClientID"`
+	ClientSecret string `mapstructure:"ClientSecret"`
+	// Timeout, CacheTTL and NegativeCacheTTL are in seconds
+	Timeout          int `mapstructure:"Timeout"`
+	CacheTTL         int `mapstructure:"CacheTTL"`
+	NegativeCacheTTL int `mapstructure:"NegativeCacheTTL"`
+}
+
+// SecretConfig is a secret of a rotation list
+type SecretConfig struct {
+	Secret string `mapstructure:"Secret"`
+	// ID is matched against the kid header of client tokens, secrets without an id are tried for any token
+	ID string `mapstructure:"ID"`
+	// Label names the secret in metrics, the ID is used if empty
+	Label string `mapstructure:"Label"`
+	// NotAfter is an RFC 3339 time or a date after which the secret is no longer accepted
+	NotAfter string `mapstructure:"NotAfter"`
+}
+
+// JWTConfig is the client token verification config struct
+type JWTConfig struct {
+	// Algorithms are the allowed signing algorithms, all algorithms of the configured keys if empty
+	Algorithms []string          `mapstructure:"Algorithms"`
+	PublicKeys []PublicKeyConfig `mapstructure:"PublicKeys"`
+	// JWKS is the file path or URL of a JSON Web Key Set
+	JWKS string `mapstructure:"JWKS"`
+	// JWKSRefresh is the refresh interval of the JWKS in seconds
+	JWKSRefresh int `mapstructure:"JWKSRefresh"`
+	// Issuer and Audience are the required iss and aud claims, not checked if empty
+	Issuer   string `mapstructure:"Issuer"`
+	Audience string `mapstructure:"Audience"`
+	// RequiredClaims are the top-level claims tokens must contain
+	RequiredClaims []string `mapstructure:"RequiredClaims"`
+	// Leeway is the clock skew in seconds allowed for exp, nbf and iat
+	Leeway int `mapstructure:"Leeway"`
+	// Claims selects the claims targeting sees
+	Claims ClaimsConfig `mapstructure:"Claims"`
+}
+
+// ClaimsConfig is the claim source config struct
+type ClaimsConfig struct {
+	// Source is custom, payload, namespace or mapping (default: custom)
+	Source string `mapstructure:"Source"`
+	// Namespace is the top-level claim used by the namespace source
+	Namespace string `mapstructure:"Namespace"`
+	// Mapping maps JWT paths to attributes for the mapping source
+	Mapping []ClaimMappingConfig `mapstructure:"Mapping"`
+}
+
+// ClaimMappingConfig maps the claim at a dot-separated JWT path to a targeting attribute
+type ClaimMappingConfig struct {
+	Path      string `mapstructure:"Path"`
+	Attribute string `mapstructure:"Attribute"`
+}
+
+// PublicKeyConfig is a PEM encoded public key or certificate, read from File or given inline as PEM
+type PublicKeyConfig struct {
+	ID   string `mapstructure:"ID"`
+	File string `mapstructure:"File"`
+	PEM  string `mapstructure:"PEM"`
+}
+
+// CompressionConfig is the permessage-deflate config struct
+type CompressionConfig struct {
+	Enabled bool `mapstructure:"Enabled"`
+	Level   int  `mapstructure:"Level"`
+	MinSize int  `mapstructure:"MinSize"`
+}
+
+// HistoryConfig is the per-topic message history config struct
+type HistoryConfig struct {
+	Size   int `mapstructure:"Size"`
+	MaxAge int `mapstructure:"MaxAge"`
+}
+
+// MercureConfig is the Mercure protocol config struct
+type MercureConfig struct {
+	Enabled     bool     `mapstructure:"Enabled"`
+	Anonymous   bool     `mapstructure:"Anonymous"`
+	CorsOrigins []string `mapstructure:"CorsOrigins"`
+}
+
+// PusherConfig is the Pusher protocol config struct, the app secret is the endpoint APISecret
+type PusherConfig struct {
+	Enabled bool   `mapstructure:"Enabled"`
+	AppID   string `mapstructure:"AppID"`
+	Key     string `mapstructure:"Key"`
+}
+
+// MQTTConfig is the MQTT over WebSocket config struct
+type MQTTConfig struct {
+	Enabled bool `mapstructure:"Enabled"`
+}
+
+// STOMPConfig is the STOMP over WebSocket config struct
+type STOMPConfig struct {
+	Enabled bool `mapstructure:"Enabled"`
+}
+
+// GraphQLConfig is the graphql-transport-ws config struct
+type GraphQLConfig struct {
+	Enabled       bool                  `mapstructure:"Enabled"`
+	Subscriptions []GraphQLSubscription `mapstructure:"Subscriptions"`
+}
+
+// GraphQLSubscription maps a subscription root field to a topic template
+type GraphQLSubscription struct {
+	Field string `mapstructure:"Field"`
+	Topic string `mapstructure:"Topic"`
 }
 
 // NewWithPath creates a new config from the given path.
@@ -53,6 +238,17 @@ func loadConfig(configPath string) (*Config, error) {
 	v.SetDefault("Global.Port", 4719)
 	v.SetDefault("Global.LogFile", "")
 	v.SetDefault("Global.LogLevel", "normal")
+	v.SetDefault("Global.PingInterval", 30)
+	v.SetDefault("Global.PollTimeout", 25)
+	v.SetDefault("Global.PollSessionTimeout", 60)
+	v.SetDefault("Global.RevocationFile", "")
+	v.SetDefault("Global.TCP.Enabled", false)
+	v.SetDefault("Global.TCP.BindAddress", "0.0.0.0")
+	v.SetDefault("Global.TCP.Port", 4720)
+	v.SetDefault("Global.Metrics.Enabled", true)
+	v.SetDefault("Global.Metrics.Token", "")
+	v.SetDefault("Global.Metrics.BindAddress", "127.0.0.1")
+	v.SetDefault("Global.Metrics.Port", 4721)
 
 	v.SetConfigFile(configPath)
 	v.SetConfigType("toml")
@@ -68,23 +264,78 @@ func loadConfig(configPath string) (*Config, error) {
 	if err := v.Unmarshal(&cfg); err != nil {
 		return nil, err
 	}
+	if err := validate(&cfg); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
 
+// validate checks settings shared between endpoints
+func validate(cfg *Config) error {
+	names := make([]string, 0, len(cfg.Endpoints))
+	for name := range cfg.Endpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Pusher connections find their endpoint by the app key and REST requests by the app id
+	pusherKeys := make(map[string]string)
+	pusherIDs := make(map[string]string)
+	for _, name := range names {
+		pusher := cfg.Endpoints[name].Pusher
+		if !pusher.Enabled {
+			continue
+		}
+		if other, ok := pusherKeys[pusher.Key]; ok {
+			return fmt.Errorf("invalid config: endpoints %q and %q share the Pusher Key %q", other, name, pusher.Key)
+		}
+		if other, ok := pusherIDs[pusher.AppID]; ok {
+			return fmt.Errorf("invalid config: endpoints %q and %q share the Pusher AppID %q", other, name, pusher.AppID)
+		}
+		pusherKeys[pusher.Key] = name
+		pusherIDs[pusher.AppID] = name
+	}
+	return nil
+}
+
 // getDefaultConfig returns the default config.
 func getDefaultConfig() *Config {
 	return &Config{
 		Global: GlobalConfig{
-			BindAddress: "0.0.0.0",
-			Port:        4719,
-			LogFile:     "",
-			LogLevel:    "normal",
+			BindAddress:        "0.0.0.0",
+			Port:               4719,
+			LogFile:            "",
+			LogLevel:           "normal",
+			PingInterval:       30,
+			PollTimeout:        25,
+			PollSessionTimeout: 60,
+			RevocationFile:     "",
+			TCP: TCPConfig{
+				Enabled:     false,
+				BindAddress: "0.0.0.0",
+				Port:        4720,
+			},
+			Metrics: MetricsConfig{
+				Enabled:     true,
+				Token:       "",
+				BindAddress: "127.0.0.1",
+				Port:        4721,
+			},
 		},
 		Endpoints: map[string]EndpointConfig{
 			"default": {
 				Name:      "default",
 				APISecret: "change-this-api-secret",
 				JWTSecret: "change-this-jwt-secret",
+				Compression: CompressionConfig{
+					Enabled: false,
+					Level:   1,
+					MinSize: 1024,
+				},
+				History: HistoryConfig{
+					Size:   0,
+					MaxAge: 300,
+				},
 			},
 		},
 	}
@@ -3,6 +3,7 @@ package config
 import (
     "os"
     "path/filepath"
+    "strings"
     "testing"
 )
 
@@ -151,3 +152,253 @@ JWTSecret = "api-jwt-secret"
         t.Errorf("api JWTSecret not overridden, got %s", api.JWTSecret)
     }
 }
+
+func TestEndpointCompressionConfig(t *testing.T) {
+    dir := t.TempDir()
+    configPath := filepath.Join(dir, "config.toml")
+
+    content := `
+[Endpoints.web]
+Name = "web"
+APISecret = "web-secret"
+JWTSecret = "web-jwt-secret"
+
+[Endpoints.web.Compression]
+Enabled = true
+Level = 6
+MinSize = 512
+
+[Endpoints.api]
+Name = "api"
+APISecret = "api-secret"
+JWTSecret = "api-jwt-secret"
+`
+    if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    cfg, err := NewWithPath(configPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    web := cfg.Endpoints["web"].Compression
+    if !web.Enabled || web.Level != 6 || web.MinSize != 512 {
+        t.Errorf("web compression not configured correctly, got %+v", web)
+    }
+
+    if cfg.Endpoints["api"].Compression.Enabled {
+        t.Error("expected compression to be disabled when not configured")
+    }
+}
+
+func TestEndpointGraphQLConfig(t *testing.T) {
+    dir := t.TempDir()
+    configPath := filepath.Join(dir, "config.toml")
+
+    content := `
+[Endpoints.web]
+Name = "web"
+APISecret = "web-secret"
+JWTSecret = "web-jwt-secret"
+
+[Endpoints.web.GraphQL]
+Enabled = true
+
+[[Endpoints.web.GraphQL.Subscriptions]]
+Field = "orderUpdated"
+Topic = "orders.{orderId}"
+
+[[Endpoints.web.GraphQL.Subscriptions]]
+Field = "newsPosted"
+Topic = "news"
+`
+    if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    cfg, err := NewWithPath(configPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    graphql := cfg.Endpoints["web"].GraphQL
+    if !graphql.Enabled || len(graphql.Subscriptions) != 2 {
+        t.Fatalf("graphql not configured correctly, got %+v", graphql)
+    }
+
+    // Field names are case sensitive and must survive config loading unchanged
+    first := graphql.Subscriptions[0]
+    if first.Field != "orderUpdated" || first.Topic != "orders.{orderId}" {
+        t.Errorf("unexpected subscription mapping %+v", first)
+    }
+}
+
+func TestEndpointJWTConfig(t *testing.T) {
+    dir := t.TempDir()
+    configPath := filepath.Join(dir, "config.toml")
+
+    content := `
+[Endpoints.web]
+Name = "web"
+APISecret = "web-secret"
+
+[Endpoints.web.JWT]
+Algorithms = ["RS256", "EdDSA"]
+Issuer = "https://sso.example.com"
+Audience = "driplet"
+RequiredClaims = ["exp", "sub"]
+Leeway = 30
+
+[Endpoints.web.JWT.Claims]
+Source = "mapping"
+
+[[Endpoints.web.JWT.Claims.Mapping]]
+Path = "realm_access.roles"
+Attribute = "Roles"
+
+[[Endpoints.web.JWT.PublicKeys]]
+ID = "2025-01"
+File = "/etc/driplet/idp.pem"
+
+[[Endpoints.web.JWT.PublicKeys]]
+PEM = '''
+-----BEGIN PUBLIC KEY-----
+MCowBQYDK2VwAyEAGb9ECWmEzf6FQbrBZ9w7lshQhqowtrbLDFw4rXAxZuE=
+-----END PUBLIC KEY-----
+'''
+`
+    if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    cfg, err := NewWithPath(configPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    jwt := cfg.Endpoints["web"].JWT
+    if len(jwt.Algorithms) != 2 || jwt.Algorithms[0] != "RS256" || len(jwt.PublicKeys) != 2 {
+        t.Fatalf("jwt not configured correctly, got %+v", jwt)
+    }
+    if jwt.PublicKeys[0].ID != "2025-01" || jwt.PublicKeys[0].File != "/etc/driplet/idp.pem" {
+        t.Errorf("unexpected public key %+v", jwt.PublicKeys[0])
+    }
+    if !strings.Contains(jwt.PublicKeys[1].PEM, "BEGIN PUBLIC KEY") {
+        t.Errorf("expected inline PEM, got %q", jwt.PublicKeys[1].PEM)
+    }
+    if jwt.Issuer != "https://sso.example.com" || jwt.Audience != "driplet" || len(jwt.RequiredClaims) != 2 || jwt.Leeway != 30 {
+        t.Errorf("unexpected claim checks %+v", jwt)
+    }
+    if jwt.Claims.Source != "mapping" || len(jwt.Claims.Mapping) != 1 || jwt.Claims.Mapping[0].Attribute != "Roles" {
+        t.Errorf("unexpected claim source %+v", jwt.Claims)
+    }
+}
+
+// TestEndpointSecretsConfig verifies loading of rotated secret lists
+func TestEndpointSecretsConfig(t *testing.T) {
+    dir := t.TempDir()
+    configPath := filepath.Join(dir, "config.toml")
+
+    content := `
+[Endpoints.web]
+Name = "web"
+APISecret = "web-secret"
+
+[[Endpoints.web.APISecrets]]
+Secret = "next-api-secret"
+Label = "2025-q2"
+
+[[Endpoints.web.JWTSecrets]]
+Secret = "old-jwt-secret"
+ID = "v1"
+NotAfter = "2025-06-30"
+`
+    if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    cfg, err := NewWithPath(configPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    endpoint := cfg.Endpoints["web"]
+    if len(endpoint.APISecrets) != 1 || endpoint.APISecrets[0].Secret != "next-api-secret" || endpoint.APISecrets[0].Label != "2025-q2" {
+        t.Errorf("unexpected API secrets %+v", endpoint.APISecrets)
+    }
+    if len(endpoint.JWTSecrets) != 1 || endpoint.JWTSecrets[0].ID != "v1" || endpoint.JWTSecrets[0].NotAfter != "2025-06-30" {
+        t.Errorf("unexpected JWT secrets %+v", endpoint.JWTSecrets)
+    }
+}
+
+// TestDuplicatePusherKeys verifies endpoints cannot share a Pusher app key or id
+func TestDuplicatePusherKeys(t *testing.T) {
+    dir := t.TempDir()
+    configPath := filepath.Join(dir, "config.toml")
+
+    content := `
+[Endpoints.a.Pusher]
+Enabled = true
+AppID = "1"
+Key = "app-key"
+
+[Endpoints.b.Pusher]
+Enabled = true
+AppID = "2"
+Key = "app-key"
+
+[Endpoints.c.Pusher]
+Enabled = false
+AppID = "1"
+Key = "app-key"
+`
+    if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := NewWithPath(configPath); err == nil || !strings.Contains(err.Error(), `share the Pusher Key "app-key"`) {
+        t.Errorf("expected a duplicate key error, got %v", err)
+    }
+
+    content = strings.Replace(content, `Key = "app-key"`, `Key = "other-key"`, 1)
+    if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := NewWithPath(configPath); err != nil {
+        t.Errorf("expected disabled endpoints not to conflict: %v", err)
+    }
+}
+
+// TestMetricsConfig verifies the metrics endpoint defaults and overrides
+func TestMetricsConfig(t *testing.T) {
+    dir := t.TempDir()
+    configPath := filepath.Join(dir, "config.toml")
+
+    if err := os.WriteFile(configPath, []byte("[Endpoints.web]\nName = \"web\"\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    cfg, err := NewWithPath(configPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if metrics := cfg.Global.Metrics; !metrics.Enabled || metrics.Token != "" || metrics.BindAddress != "127.0.0.1" || metrics.Port != 4721 {
+        t.Errorf("expected a loopback metrics listener by default, got %+v", metrics)
+    }
+
+    content := `
+[Global.Metrics]
+Token = "scrape-token"
+BindAddress = "10.0.0.1"
+Port = 0
+`
+    if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+        t.Fatal(err)
+    }
+    cfg, err = NewWithPath(configPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if metrics := cfg.Global.Metrics; !metrics.Enabled || metrics.Token != "scrape-token" || metrics.BindAddress != "10.0.0.1" || metrics.Port != 0 {
+        t.Errorf("unexpected metrics config %+v", metrics)
+    }
+}
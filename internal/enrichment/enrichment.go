@@ -0,0 +1,123 @@
+// Package enrichment adds targeting attributes to verified client claims with a backend callback.
+package enrichment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/make0x20/driplet/internal/callback"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultCacheTTL is the default time attributes are cached
+	DefaultCacheTTL = time.Minute
+	// maxCacheSize is the maximum number of cached results
+	maxCacheSize = 10000
+)
+
+// ErrDenied is returned when the backend denies the client, regardless of FailOpen
+var ErrDenied = errors.New("client denied by enrichment")
+
+// Options configures an enrichment callback
+type Options struct {
+	// URL is the backend callback
+	URL string
+	// Secret returns the secret signing the callback like API requests, it is called for every callback
+	Secret  func() string
+	Timeout time.Duration
+	// CacheTTL is the time attributes are cached per claims
+	CacheTTL time.Duration
+	// FailOpen accepts clients without attributes when the callback fails, they are rejected otherwise
+	FailOpen bool
+}
+
+// Request is the signed callback body
+type Request struct {
+	Endpoint string                 `json:"endpoint"`
+	Claims   map[string]interface{} `json:"claims"`
+	callback.Stamp
+}
+
+// Client calls the enrichment callback of an endpoint and caches the attributes
+type Client struct {
+	options  Options
+	callback *callback.Client
+	logger   *slog.Logger
+	timeNow  func() time.Time
+	cache    *callback.Cache[map[string]interface{}]
+}
+
+// New creates an enrichment client, zero durations use the defaults
+func New(options Options, logger *slog.Logger) *Client {
+	if options.CacheTTL <= 0 {
+		options.CacheTTL = DefaultCacheTTL
+	}
+	c := &Client{
+		options:  options,
+		callback: callback.New("enrichment", options.URL, options.Secret, options.Timeout),
+		logger:   logger,
+		timeNow:  time.Now,
+	}
+	c.cache = callback.NewCache[map[string]interface{}](maxCacheSize, func() time.Time { return c.timeNow() })
+	return c
+}
+
+// Enrich returns the attributes the backend adds to the claims of a client.
+// A failed callback returns no attributes if FailOpen is set and an error otherwise, only attributes are cached.
+func (c *Client) Enrich(ctx context.Context, endpoint string, claims map[string]interface{}) (map[string]interface{}, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	// Map keys are marshalled sorted, equal claims share a cache entry
+	key := sha256.Sum256(append([]byte(endpoint+"\x00"), payload...))
+
+	if attributes, ok := c.cache.Get(key); ok {
+		return attributes, nil
+	}
+
+	attributes, err := c.request(ctx, endpoint, claims)
+	if errors.Is(err, ErrDenied) {
+		return nil, err
+	}
+	if err != nil {
+		if !c.options.FailOpen {
+			return nil, err
+		}
+		if c.logger != nil {
+			c.logger.Warn("Enrichment failed, accepting client without attributes", "endpoint", endpoint, "error", err)
+		}
+		return nil, nil
+	}
+
+	c.cache.Set(key, attributes, c.timeNow().Add(c.options.CacheTTL))
+	return attributes, nil
+}
+
+// request posts the claims to the backend, a 204 response adds no attributes
+func (c *Client) request(ctx context.Context, endpoint string, claims map[string]interface{}) (map[string]interface{}, error) {
+	status, body, err := c.callback.Post(ctx, &Request{Endpoint: endpoint, Claims: claims})
+	if err != nil {
+		return nil, err
+	}
+	switch status {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return map[string]interface{}{}, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, ErrDenied
+	default:
+		return nil, fmt.Errorf("enrichment callback failed: unexpected status %d", status)
+	}
+
+	var attributes map[string]interface{}
+	if err := json.Unmarshal(body, &attributes); err != nil || attributes == nil {
+		return nil, fmt.Errorf("invalid enrichment callback response, expected a JSON object of attributes")
+	}
+	return attributes, nil
+}
@@ -0,0 +1,129 @@
+package enrichment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/make0x20/driplet/internal/callback"
+	"github.com/make0x20/driplet/internal/jwt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer returns a backend adding attributes to sub 42, it denies sub 13 and counts requests
+func newTestServer(t *testing.T, status *atomic.Int32) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Driplet-Signature") != callback.Sign("api-secret", body) {
+			t.Error("invalid callback signature")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if status.Load() != 0 {
+			w.WriteHeader(int(status.Load()))
+			return
+		}
+
+		var req Request
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Error(err)
+			return
+		}
+		if (req.Endpoint != "web" && req.Endpoint != "open") || req.Nonce == "" || req.Timestamp == 0 {
+			t.Errorf("unexpected callback %+v", req)
+		}
+		switch req.Claims["sub"] {
+		case "42":
+			w.Write([]byte(`{"plan":"pro","roles":["admin"]}`))
+		case "13":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &requests
+}
+
+// TestEnrich verifies enrichment callbacks:
+// - The returned attributes are merged into the targeting view of the claims
+// - Attributes are cached, failures are not
+// - A failed callback rejects the client unless FailOpen is set, a denial always does
+func TestEnrich(t *testing.T) {
+	var status atomic.Int32
+	ts, requests := newTestServer(t, &status)
+	keys, err := jwt.NewKeys([]jwt.Key{{Key: []byte("secret")}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(sub string) string {
+		signed, err := gojwt.NewWithClaims(gojwt.SigningMethodHS256, gojwt.MapClaims{
+			"sub":    sub,
+			"exp":    time.Now().Add(time.Hour).Unix(),
+			"custom": map[string]interface{}{"plan": "free", "team": "red"},
+		}).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	closed := New(Options{URL: ts.URL, Secret: func() string { return "api-secret" }, CacheTTL: time.Minute}, nil)
+	open := New(Options{URL: ts.URL, Secret: func() string { return "api-secret" }, FailOpen: true}, nil)
+	v := jwt.NewValidator(nil,
+		jwt.WithEndpointKeys("web", keys), jwt.WithEndpointEnricher("web", closed),
+		jwt.WithEndpointKeys("open", keys), jwt.WithEndpointEnricher("open", open),
+	)
+
+	token := sign("42")
+	claims, err := v.ValidateEndpointToken(context.Background(), token, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan, _ := claims.GetCustomClaim("plan"); plan != "pro" {
+		t.Errorf("expected the backend plan to override the token, got %v", plan)
+	}
+	if team, _ := claims.GetCustomClaim("team"); team != "red" {
+		t.Errorf("expected token attributes to be kept, got %v", team)
+	}
+	if roles, ok := claims.GetCustomClaim("roles"); !ok || roles.([]interface{})[0] != "admin" {
+		t.Errorf("expected backend roles, got %v", roles)
+	}
+
+	v.ValidateEndpointToken(context.Background(), token, "web")
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected cached attributes for the same claims, got %d requests", n)
+	}
+
+	if claims, err := v.ValidateEndpointToken(context.Background(), sign("7"), "web"); err != nil {
+		t.Errorf("expected client without attributes to be accepted: %v", err)
+	} else if plan, _ := claims.GetCustomClaim("plan"); plan != "free" {
+		t.Errorf("expected token attributes, got %v", plan)
+	}
+	if _, err := v.ValidateEndpointToken(context.Background(), sign("13"), "open"); !errors.Is(err, ErrDenied) {
+		t.Errorf("expected denied client even when failing open, got %v", err)
+	}
+
+	// Failures reject the client or fail open
+	status.Store(http.StatusInternalServerError)
+	if _, err := v.ValidateEndpointToken(context.Background(), sign("8"), "web"); err == nil {
+		t.Error("expected failed enrichment to reject the client")
+	}
+	if claims, err := v.ValidateEndpointToken(context.Background(), sign("8"), "open"); err != nil {
+		t.Errorf("expected failed enrichment to fail open: %v", err)
+	} else if plan, _ := claims.GetCustomClaim("plan"); plan != "free" {
+		t.Errorf("expected token attributes when failing open, got %v", plan)
+	}
+	status.Store(0)
+	if _, err := v.ValidateEndpointToken(context.Background(), sign("8"), "web"); err != nil {
+		t.Errorf("expected failures not to be cached: %v", err)
+	}
+}
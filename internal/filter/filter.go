@@ -0,0 +1,242 @@
+// Package filter implements a small expression language for filtering JSON payloads.
+//
+// Expressions compare dot separated paths into the payload with literals, e.g.:
+//
+//	status == "failed" || amount > 1000
+//	!(user.role in ["guest", "bot"]) && priority >= 2
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Limits bounds the complexity of an expression
+type Limits struct {
+	MaxLength int
+	MaxNodes  int
+	MaxDepth  int
+}
+
+// DefaultLimits are the limits used when none are given
+var DefaultLimits = Limits{
+	MaxLength: 512,
+	MaxNodes:  64,
+	MaxDepth:  8,
+}
+
+// Expression is a compiled filter expression
+type Expression struct {
+	source string
+	root   node
+}
+
+// Compile parses an expression and checks it against the given limits
+func Compile(source string, limits Limits) (*Expression, error) {
+	if limits == (Limits{}) {
+		limits = DefaultLimits
+	}
+
+	if strings.TrimSpace(source) == "" {
+		return nil, fmt.Errorf("empty filter expression")
+	}
+	if len(source) > limits.MaxLength {
+		return nil, fmt.Errorf("filter expression exceeds %d characters", limits.MaxLength)
+	}
+
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, limits: limits}
+	root, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+
+	return &Expression{source: source, root: root}, nil
+}
+
+// String returns the source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Match evaluates the expression against a decoded JSON value
+func (e *Expression) Match(payload interface{}) bool {
+	return truthy(e.root.eval(payload))
+}
+
+// MatchJSON decodes a JSON payload and evaluates the expression against it
+func (e *Expression) MatchJSON(data []byte) bool {
+	var payload interface{}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return false
+	}
+	return e.Match(payload)
+}
+
+// node is an expression tree node
+type node interface {
+	eval(payload interface{}) interface{}
+}
+
+// literalNode is a constant value
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(interface{}) interface{} {
+	return n.value
+}
+
+// pathNode looks up a dot separated path in the payload
+type pathNode struct {
+	parts []string
+}
+
+func (n *pathNode) eval(payload interface{}) interface{} {
+	current := payload
+	for _, part := range n.parts {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current, ok = m[part]
+		if !ok {
+			return nil
+		}
+	}
+	return current
+}
+
+// notNode negates its operand
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) eval(payload interface{}) interface{} {
+	return !truthy(n.operand.eval(payload))
+}
+
+// logicalNode combines operands with && or ||
+type logicalNode struct {
+	op          string
+	left, right node
+}
+
+func (n *logicalNode) eval(payload interface{}) interface{} {
+	left := truthy(n.left.eval(payload))
+	if n.op == "&&" {
+		return left && truthy(n.right.eval(payload))
+	}
+	return left || truthy(n.right.eval(payload))
+}
+
+// compareNode compares two operands
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n *compareNode) eval(payload interface{}) interface{} {
+	left := n.left.eval(payload)
+	right := n.right.eval(payload)
+
+	switch n.op {
+	case "==":
+		return equal(left, right)
+	case "!=":
+		return !equal(left, right)
+	case "in":
+		list, ok := right.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, v := range list {
+			if equal(left, v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Ordering only applies to two numbers or two strings
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false
+		}
+		return order(n.op, compareFloat(l, r))
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+		return order(n.op, strings.Compare(l, r))
+	}
+	return false
+}
+
+// Helper functions
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case float64:
+		return t != 0
+	case string:
+		return t != ""
+	default:
+		return true
+	}
+}
+
+func equal(a, b interface{}) bool {
+	switch av := a.(type) {
+	case nil:
+		return b == nil
+	case float64:
+		bv, ok := b.(float64)
+		return ok && av == bv
+	case string:
+		bv, ok := b.(string)
+		return ok && av == bv
+	case bool:
+		bv, ok := b.(bool)
+		return ok && av == bv
+	}
+	return false
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func order(op string, cmp int) bool {
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
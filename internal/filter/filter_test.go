@@ -0,0 +1,88 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestMatch verifies expression evaluation against JSON payloads:
+// - Comparisons on strings, numbers, booleans and null
+// - Logical operators, negation and grouping
+// - Nested paths and missing fields
+// - Membership with in
+func TestMatch(t *testing.T) {
+	payload := []byte(`{"status":"failed","amount":250,"paid":false,"user":{"role":"admin","id":7},"note":null}`)
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`status == "failed"`, true},
+		{`status == "failed" || amount > 1000`, true},
+		{`status == "ok" || amount > 1000`, false},
+		{`amount >= 250 && amount < 251`, true},
+		{`user.role == "admin" && user.id == 7`, true},
+		{`!(user.role == "admin")`, false},
+		{`paid`, false},
+		{`!paid`, true},
+		{`note == null`, true},
+		{`missing == null`, true},
+		{`missing > 1`, false},
+		{`missing.deep != "x"`, true},
+		{`user.role in ["admin", "owner"]`, true},
+		{`amount in [1, 2, 3]`, false},
+		{`status > 1`, false},
+		{`status >= "a"`, true},
+		{`amount > -1e3`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := Compile(tt.expr, DefaultLimits)
+			if err != nil {
+				t.Fatalf("compile error: %v", err)
+			}
+			if got := expr.MatchJSON(payload); got != tt.want {
+				t.Errorf("got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCompileErrors verifies that invalid expressions are rejected
+func TestCompileErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`status ==`,
+		`(status == "a"`,
+		`status == "a`,
+		`status = "a"`,
+		`a in [1, 2`,
+		`a..b == 1`,
+		`a == 1 b == 2`,
+	}
+
+	for _, src := range tests {
+		if _, err := Compile(src, DefaultLimits); err == nil {
+			t.Errorf("expected compile error for %q", src)
+		}
+	}
+}
+
+// TestLimits verifies that expression complexity limits are enforced
+func TestLimits(t *testing.T) {
+	limits := Limits{MaxLength: 64, MaxNodes: 8, MaxDepth: 2}
+
+	if _, err := Compile(`a == "`+strings.Repeat("x", 64)+`"`, limits); err == nil {
+		t.Error("expected length limit to be enforced")
+	}
+	if _, err := Compile(`a == 1 || b == 2 || c == 3 || d == 4`, limits); err == nil {
+		t.Error("expected node limit to be enforced")
+	}
+	if _, err := Compile(`(((a == 1)))`, limits); err == nil {
+		t.Error("expected depth limit to be enforced")
+	}
+	if _, err := Compile(`a == 1 || b == 2`, limits); err != nil {
+		t.Errorf("expected expression within limits to compile: %v", err)
+	}
+}
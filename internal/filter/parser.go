@@ -0,0 +1,325 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenLBracket
+	tokenRBracket
+	tokenComma
+)
+
+// token is a lexical token
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// tokenize splits an expression into tokens
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+		case c == '[':
+			tokens = append(tokens, token{tokenLBracket, "[", i})
+			i++
+		case c == ']':
+			tokens = append(tokens, token{tokenRBracket, "]", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(src) && src[end] != '"' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, token{tokenString, src[i : end+1], i})
+			i = end + 1
+		case c == '-' || (c >= '0' && c <= '9'):
+			end := i + 1
+			for end < len(src) && strings.IndexByte("0123456789.eE+-", src[end]) >= 0 {
+				// A sign is only part of a number directly after an exponent
+				if (src[end] == '+' || src[end] == '-') && src[end-1] != 'e' && src[end-1] != 'E' {
+					break
+				}
+				end++
+			}
+			tokens = append(tokens, token{tokenNumber, src[i:end], i})
+			i = end
+		case isIdentStart(c):
+			end := i + 1
+			for end < len(src) && (isIdentStart(src[end]) || (src[end] >= '0' && src[end] <= '9') || src[end] == '.') {
+				end++
+			}
+			word := src[i:end]
+			if word == "in" {
+				tokens = append(tokens, token{tokenOperator, word, i})
+			} else {
+				tokens = append(tokens, token{tokenIdent, word, i})
+			}
+			i = end
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!"} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+			tokens = append(tokens, token{tokenOperator, op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{tokenEOF, "end of expression", len(src)}), nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// parser is a recursive descent parser over tokens
+type parser struct {
+	tokens []token
+	pos    int
+	nodes  int
+	limits Limits
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// count tracks the number of nodes and the nesting depth against the limits
+func (p *parser) count(depth int) error {
+	p.nodes++
+	if p.nodes > p.limits.MaxNodes {
+		return fmt.Errorf("filter expression exceeds %d nodes", p.limits.MaxNodes)
+	}
+	if depth > p.limits.MaxDepth {
+		return fmt.Errorf("filter expression exceeds nesting depth of %d", p.limits.MaxDepth)
+	}
+	return nil
+}
+
+// parseOr parses: and ('||' and)*
+func (p *parser) parseOr(depth int) (node, error) {
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOperator && p.peek().text == "||" {
+		p.next()
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.count(depth); err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses: unary ('&&' unary)*
+func (p *parser) parseAnd(depth int) (node, error) {
+	left, err := p.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOperator && p.peek().text == "&&" {
+		p.next()
+		right, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.count(depth); err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseUnary parses: '!' unary | comparison
+func (p *parser) parseUnary(depth int) (node, error) {
+	if p.peek().kind == tokenOperator && p.peek().text == "!" {
+		p.next()
+		if err := p.count(depth + 1); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parseComparison(depth)
+}
+
+// parseComparison parses: operand (op operand)?
+func (p *parser) parseComparison(depth int) (node, error) {
+	left, err := p.parseOperand(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	if t.kind != tokenOperator {
+		return left, nil
+	}
+	switch t.text {
+	case "==", "!=", "<", "<=", ">", ">=", "in":
+	default:
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.parseOperand(depth)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.count(depth); err != nil {
+		return nil, err
+	}
+	return &compareNode{op: t.text, left: left, right: right}, nil
+}
+
+// parseOperand parses: literal | path | list | '(' or ')'
+func (p *parser) parseOperand(depth int) (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenLParen:
+		inner, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, fmt.Errorf("expected ) at position %d", closing.pos)
+		}
+		return inner, nil
+
+	case tokenLBracket:
+		if err := p.count(depth + 1); err != nil {
+			return nil, err
+		}
+		var values []interface{}
+		for p.peek().kind != tokenRBracket {
+			item := p.next()
+			value, err := p.literal(item)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.count(depth + 1); err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			if p.peek().kind == tokenComma {
+				p.next()
+			} else if p.peek().kind != tokenRBracket {
+				return nil, fmt.Errorf("expected , or ] at position %d", p.peek().pos)
+			}
+		}
+		p.next()
+		return &literalNode{value: values}, nil
+
+	case tokenIdent:
+		if err := p.count(depth); err != nil {
+			return nil, err
+		}
+		switch t.text {
+		case "true", "false", "null":
+			value, _ := p.literal(t)
+			return &literalNode{value: value}, nil
+		}
+		parts := strings.Split(t.text, ".")
+		for _, part := range parts {
+			if part == "" {
+				return nil, fmt.Errorf("invalid path %q at position %d", t.text, t.pos)
+			}
+		}
+		return &pathNode{parts: parts}, nil
+
+	case tokenString, tokenNumber:
+		if err := p.count(depth); err != nil {
+			return nil, err
+		}
+		value, err := p.literal(t)
+		if err != nil {
+			return nil, err
+		}
+		return &literalNode{value: value}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+}
+
+// literal converts a literal token to its value
+func (p *parser) literal(t token) (interface{}, error) {
+	switch t.kind {
+	case tokenString:
+		var s string
+		if err := json.Unmarshal([]byte(t.text), &s); err != nil {
+			return nil, fmt.Errorf("invalid string at position %d", t.pos)
+		}
+		return s, nil
+	case tokenNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", t.text, t.pos)
+		}
+		return f, nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("expected literal at position %d, got %q", t.pos, t.text)
+}
@@ -0,0 +1,318 @@
+// Package graphql implements the graphql-transport-ws protocol for subscriptions mapped onto hub topics.
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Operation is the root field of a subscription operation
+type Operation struct {
+	Name string
+	// Field is the name of the root field
+	Field string
+	// ResponseKey is the key of the field in results, the alias if one is given
+	ResponseKey string
+}
+
+// definition is an operation definition found in a document
+type definition struct {
+	kind string
+	Operation
+}
+
+// ParseSubscription finds the subscription operation of a document, selected by name when the document has several.
+// Only the first root field of the operation is used, the rest of the document is not validated.
+func ParseSubscription(query, operationName string) (*Operation, error) {
+	l := &lexer{src: query}
+	var operations []definition
+
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok == "" {
+			break
+		}
+
+		switch tok {
+		case "{":
+			// Query shorthand
+			if err := l.skipBlock(); err != nil {
+				return nil, err
+			}
+			operations = append(operations, definition{kind: "query"})
+
+		case "query", "mutation", "subscription":
+			def, err := l.operation(tok)
+			if err != nil {
+				return nil, err
+			}
+			operations = append(operations, *def)
+
+		case "fragment":
+			if err := l.skipUntilBlock(); err != nil {
+				return nil, err
+			}
+			if err := l.skipBlock(); err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, fmt.Errorf("unexpected %q", tok)
+		}
+	}
+
+	var selected *definition
+	for i := range operations {
+		if operationName == "" || operations[i].Name == operationName {
+			if selected != nil {
+				return nil, fmt.Errorf("operationName is required for documents with several operations")
+			}
+			selected = &operations[i]
+		}
+	}
+	if selected == nil {
+		return nil, fmt.Errorf("operation %q not found", operationName)
+	}
+	if selected.kind != "subscription" {
+		return nil, fmt.Errorf("only subscription operations are supported")
+	}
+	return &selected.Operation, nil
+}
+
+// operation parses an operation definition after its type
+func (l *lexer) operation(kind string) (*definition, error) {
+	def := &definition{kind: kind}
+
+	tok, err := l.next()
+	if err != nil {
+		return nil, err
+	}
+	if isName(tok) {
+		def.Name = tok
+		if tok, err = l.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Skip variable definitions and directives
+	for tok != "{" {
+		switch {
+		case tok == "(":
+			if err := l.skipBalanced("(", ")"); err != nil {
+				return nil, err
+			}
+		case tok == "@" || isName(tok):
+		default:
+			return nil, fmt.Errorf("unexpected %q in operation definition", tok)
+		}
+		if tok, err = l.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	// The first root field, with an optional alias
+	field, err := l.next()
+	if err != nil {
+		return nil, err
+	}
+	if !isName(field) {
+		return nil, fmt.Errorf("expected root field, got %q", field)
+	}
+	def.Field, def.ResponseKey = field, field
+	if l.peek() == ':' {
+		l.next()
+		if def.Field, err = l.next(); err != nil {
+			return nil, err
+		}
+		if !isName(def.Field) {
+			return nil, fmt.Errorf("expected root field after alias, got %q", def.Field)
+		}
+	}
+
+	return def, l.skipBlock()
+}
+
+// lexer splits a GraphQL document into names and punctuators, skipping strings, numbers and comments
+type lexer struct {
+	src string
+	pos int
+}
+
+// next returns the next token, an empty string at the end of the document
+func (l *lexer) next() (string, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.pos++
+
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+
+		case c == '"':
+			if err := l.skipString(); err != nil {
+				return "", err
+			}
+			return `"`, nil
+
+		case c == '.':
+			if !strings.HasPrefix(l.src[l.pos:], "...") {
+				return "", fmt.Errorf("unexpected '.' at position %d", l.pos)
+			}
+			l.pos += 3
+			return "...", nil
+
+		case strings.IndexByte("{}()[]:=!$@&|", c) >= 0:
+			l.pos++
+			return string(c), nil
+
+		case c == '-' || (c >= '0' && c <= '9'):
+			start := l.pos
+			l.pos++
+			for l.pos < len(l.src) && strings.IndexByte("0123456789.eE+-", l.src[l.pos]) >= 0 {
+				l.pos++
+			}
+			return l.src[start:l.pos], nil
+
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			start := l.pos
+			for l.pos < len(l.src) && isNameChar(l.src[l.pos]) {
+				l.pos++
+			}
+			return l.src[start:l.pos], nil
+
+		default:
+			return "", fmt.Errorf("unexpected character %q at position %d", c, l.pos)
+		}
+	}
+	return "", nil
+}
+
+// peek returns the next non-ignored character without consuming it
+func (l *lexer) peek() byte {
+	for i := l.pos; i < len(l.src); i++ {
+		switch l.src[i] {
+		case ' ', '\t', '\n', '\r', ',':
+			continue
+		}
+		return l.src[i]
+	}
+	return 0
+}
+
+// skipString skips a string or block string
+func (l *lexer) skipString() error {
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		end := strings.Index(l.src[l.pos+3:], `"""`)
+		if end < 0 {
+			return fmt.Errorf("unterminated block string")
+		}
+		l.pos += end + 6
+		return nil
+	}
+
+	for l.pos++; l.pos < len(l.src); l.pos++ {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos++
+		case '"':
+			l.pos++
+			return nil
+		case '\n':
+			return fmt.Errorf("unterminated string")
+		}
+	}
+	return fmt.Errorf("unterminated string")
+}
+
+// skipBalanced skips tokens until the closing punctuator of an opened one
+func (l *lexer) skipBalanced(open, close string) error {
+	depth := 1
+	for depth > 0 {
+		tok, err := l.next()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case "":
+			return fmt.Errorf("expected %q", close)
+		case open:
+			depth++
+		case close:
+			depth--
+		}
+	}
+	return nil
+}
+
+// skipBlock skips the rest of a selection set
+func (l *lexer) skipBlock() error {
+	return l.skipBalanced("{", "}")
+}
+
+// skipUntilBlock skips tokens up to and including the opening brace of a selection set
+func (l *lexer) skipUntilBlock() error {
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case "":
+			return fmt.Errorf("expected selection set")
+		case "{":
+			return nil
+		case "(":
+			if err := l.skipBalanced("(", ")"); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func isName(tok string) bool {
+	return tok != "" && !(tok[0] >= '0' && tok[0] <= '9') && isNameChar(tok[0])
+}
+
+// ResolveTopic fills {variable} placeholders of a topic template with operation variables
+func ResolveTopic(template string, variables map[string]interface{}) (string, error) {
+	var b strings.Builder
+	rest := template
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			b.WriteString(rest)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated placeholder in topic template %q", template)
+		}
+
+		b.WriteString(rest[:start])
+		name := rest[start+1 : start+end]
+		switch value := variables[name].(type) {
+		case string:
+			b.WriteString(value)
+		case float64:
+			b.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+		case bool:
+			b.WriteString(strconv.FormatBool(value))
+		case nil:
+			return "", fmt.Errorf("missing variable %q", name)
+		default:
+			return "", fmt.Errorf("variable %q must be a string, number or boolean", name)
+		}
+		rest = rest[start+end+1:]
+	}
+}
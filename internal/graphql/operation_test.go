@@ -0,0 +1,121 @@
+package graphql
+
+import (
+	"testing"
+)
+
+func TestParseSubscription(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		operationName string
+		expected      Operation
+		wantErr       bool
+	}{
+		{
+			name:     "anonymous subscription",
+			query:    `subscription { newsPosted { id title } }`,
+			expected: Operation{Field: "newsPosted", ResponseKey: "newsPosted"},
+		},
+		{
+			name: "named subscription with variables and arguments",
+			query: `# order updates
+				subscription OnOrder($orderId: ID!, $filter: Filter = {status: "open"}) @live {
+					orderUpdated(id: $orderId) { id status items { sku } }
+				}`,
+			expected: Operation{Name: "OnOrder", Field: "orderUpdated", ResponseKey: "orderUpdated"},
+		},
+		{
+			name:     "aliased field",
+			query:    `subscription { order: orderUpdated(id: "1") { id } }`,
+			expected: Operation{Field: "orderUpdated", ResponseKey: "order"},
+		},
+		{
+			name: "selected by operation name",
+			query: `query Current { order { id } }
+				subscription Updates { orderUpdated { ...OrderFields } }
+				fragment OrderFields on Order { id note(format: """block "quoted" text""") }`,
+			operationName: "Updates",
+			expected:      Operation{Name: "Updates", Field: "orderUpdated", ResponseKey: "orderUpdated"},
+		},
+		{
+			name:    "query operation",
+			query:   `query { order { id } }`,
+			wantErr: true,
+		},
+		{
+			name:    "several operations without name",
+			query:   `subscription A { a } subscription B { b }`,
+			wantErr: true,
+		},
+		{
+			name:          "unknown operation name",
+			query:         `subscription A { a }`,
+			operationName: "B",
+			wantErr:       true,
+		},
+		{
+			name:    "unterminated selection set",
+			query:   `subscription { a { b }`,
+			wantErr: true,
+		},
+		{
+			name:    "unterminated string",
+			query:   `subscription { a(x: "b) }`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op, err := ParseSubscription(tt.query, tt.operationName)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %+v", op)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *op != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, *op)
+			}
+		})
+	}
+}
+
+func TestResolveTopic(t *testing.T) {
+	variables := map[string]interface{}{
+		"orderId": "42",
+		"shop":    float64(7),
+		"live":    true,
+		"nested":  map[string]interface{}{},
+	}
+
+	tests := []struct {
+		template string
+		expected string
+		wantErr  bool
+	}{
+		{"news", "news", false},
+		{"orders.{orderId}", "orders.42", false},
+		{"shops.{shop}.orders.{orderId}.{live}", "shops.7.orders.42.true", false},
+		{"orders.{missing}", "", true},
+		{"orders.{nested}", "", true},
+		{"orders.{orderId", "", true},
+	}
+
+	for _, tt := range tests {
+		topic, err := ResolveTopic(tt.template, variables)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got %s", tt.template, topic)
+			}
+			continue
+		}
+		if err != nil || topic != tt.expected {
+			t.Errorf("%s: expected %s, got %s (%v)", tt.template, tt.expected, topic, err)
+		}
+	}
+}
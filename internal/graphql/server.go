@@ -0,0 +1,389 @@
+package graphql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	gorilla "github.com/gorilla/websocket"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/websocket"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Subprotocol is the WebSocket subprotocol of graphql-transport-ws connections
+	Subprotocol = "graphql-transport-ws"
+	// connectTimeout is the time allowed to send connection_init after the connection is opened
+	connectTimeout = 10 * time.Second
+	// writeWait is the time allowed to write a message to the client
+	writeWait = 10 * time.Second
+	// maxMessageSize is the maximum size of a message sent by a client
+	maxMessageSize = 64 * 1024
+)
+
+// graphql-transport-ws close codes
+const (
+	CloseBadRequest      = 4400
+	CloseUnauthorized    = 4401
+	CloseForbidden       = 4403
+	CloseInitTimeout     = 4408
+	CloseSubscriberExist = 4409
+	CloseTooManyInits    = 4429
+)
+
+// Message types
+const (
+	TypeConnectionInit = "connection_init"
+	TypeConnectionAck  = "connection_ack"
+	TypePing           = "ping"
+	TypePong           = "pong"
+	TypeSubscribe      = "subscribe"
+	TypeNext           = "next"
+	TypeError          = "error"
+	TypeComplete       = "complete"
+)
+
+// Message is a graphql-transport-ws message
+type Message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// SubscribePayload is the payload of a subscribe message
+type SubscribePayload struct {
+	OperationName string                 `json:"operationName"`
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Error is a GraphQL error
+type Error struct {
+	Message string `json:"message"`
+}
+
+// Authenticator validates the JWT sent in the connection_init payload
+type Authenticator func(token string) (*jwt.Claims, error)
+
+// Mapping maps subscription root fields to topic templates
+type Mapping map[string]string
+
+// Server runs graphql-transport-ws connections on top of the hub
+type Server struct {
+	logger *slog.Logger
+	hub    *websocket.Hub
+}
+
+// subscription is an operation subscribed to a topic
+type subscription struct {
+	topic       string
+	responseKey string
+}
+
+// conn is a single graphql-transport-ws connection
+type conn struct {
+	server   *Server
+	endpoint string
+	mapping  Mapping
+	ws       *gorilla.Conn
+	client   *websocket.Client
+	out      *websocket.Outbox
+	mu       sync.RWMutex
+	subs     map[string]*subscription
+}
+
+// NewServer creates a new graphql-transport-ws server
+func NewServer(logger *slog.Logger, hub *websocket.Hub) *Server {
+	return &Server{
+		logger: logger,
+		hub:    hub,
+	}
+}
+
+// Serve runs a graphql-transport-ws connection until it is closed
+func (s *Server) Serve(ws *gorilla.Conn, endpoint string, mapping Mapping, authenticate Authenticator) {
+	defer ws.Close()
+	ws.SetReadLimit(maxMessageSize)
+
+	// The first message must be connection_init, subscribing before it is unauthorized
+	ws.SetReadDeadline(time.Now().Add(connectTimeout))
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		if isTimeout(err) {
+			closeWith(ws, CloseInitTimeout, "Connection initialisation timeout")
+		}
+		return
+	}
+	var init Message
+	if err := json.Unmarshal(data, &init); err != nil {
+		closeWith(ws, CloseBadRequest, "Invalid message")
+		return
+	}
+	switch init.Type {
+	case TypeConnectionInit:
+	case TypeSubscribe:
+		closeWith(ws, CloseUnauthorized, "Unauthorized")
+		return
+	default:
+		closeWith(ws, CloseBadRequest, "Expected connection_init")
+		return
+	}
+
+	claims, err := authenticate(initToken(init.Payload))
+	if err != nil {
+		s.logger.Debug("Invalid token", "endpoint", endpoint, "error", err)
+		closeWith(ws, CloseForbidden, "Forbidden")
+		return
+	}
+
+	c := &conn{
+		server:   s,
+		endpoint: endpoint,
+		mapping:  mapping,
+		ws:       ws,
+		client:   s.hub.Attach(endpoint, claims),
+		out:      websocket.NewOutbox(64),
+		subs:     make(map[string]*subscription),
+	}
+	defer c.client.Detach()
+
+	ack, _ := json.Marshal(Message{Type: TypeConnectionAck})
+	ws.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := ws.WriteMessage(gorilla.TextMessage, ack); err != nil {
+		return
+	}
+
+	go c.writePump()
+	if c.readPump() {
+		// Give the write pump time to send the close frame
+		c.out.Wait(writeWait)
+	}
+	c.out.Stop()
+}
+
+// initToken returns the token of a connection_init payload: token, authToken or a Bearer Authorization
+func initToken(payload json.RawMessage) string {
+	var p map[string]interface{}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return ""
+	}
+	for _, key := range []string{"token", "authToken"} {
+		if token, ok := p[key].(string); ok && token != "" {
+			return token
+		}
+	}
+	for _, key := range []string{"Authorization", "authorization"} {
+		if header, ok := p[key].(string); ok && strings.HasPrefix(header, "Bearer ") {
+			return strings.TrimPrefix(header, "Bearer ")
+		}
+	}
+	return ""
+}
+
+// isTimeout reports whether a read failed on its deadline
+func isTimeout(err error) bool {
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+// closeWith closes a connection before the write pump is started
+func closeWith(ws *gorilla.Conn, code int, reason string) {
+	ws.WriteControl(gorilla.CloseMessage, gorilla.FormatCloseMessage(code, reason), time.Now().Add(writeWait))
+}
+
+// queue queues an encoded message without blocking, a connection that can not keep up is closed
+func (c *conn) queue(msg Message) {
+	data, _ := json.Marshal(msg)
+	c.out.Queue(data)
+}
+
+// fail queues a close frame with a protocol close code
+func (c *conn) fail(code int, reason string) {
+	c.server.logger.Debug("GraphQL connection closed", "endpoint", c.endpoint, "code", code, "reason", reason)
+	c.out.QueueClose(gorilla.FormatCloseMessage(code, reason))
+}
+
+// readPump handles messages sent by the client, returns true if the connection is closing with a close code
+func (c *conn) readPump() bool {
+	timeout := 2 * c.server.hub.PingInterval()
+	c.ws.SetReadDeadline(time.Now().Add(timeout))
+	c.ws.SetPongHandler(func(string) error {
+		c.ws.SetReadDeadline(time.Now().Add(timeout))
+		return nil
+	})
+
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return false
+		}
+		c.ws.SetReadDeadline(time.Now().Add(timeout))
+
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Type == "" {
+			c.fail(CloseBadRequest, "Invalid message")
+			return true
+		}
+
+		switch msg.Type {
+		case TypePing:
+			c.queue(Message{Type: TypePong})
+
+		case TypePong:
+
+		case TypeConnectionInit:
+			c.fail(CloseTooManyInits, "Too many initialisation requests")
+			return true
+
+		case TypeSubscribe:
+			if msg.ID == "" {
+				c.fail(CloseBadRequest, "Missing subscription id")
+				return true
+			}
+			if !c.subscribe(msg) {
+				return true
+			}
+
+		case TypeComplete:
+			c.complete(msg.ID)
+
+		default:
+			c.fail(CloseBadRequest, fmt.Sprintf("Unsupported message type %s", msg.Type))
+			return true
+		}
+	}
+}
+
+// subscribe maps a subscription operation onto a topic, returns false if the connection is closing
+func (c *conn) subscribe(msg Message) bool {
+	c.mu.RLock()
+	_, exists := c.subs[msg.ID]
+	c.mu.RUnlock()
+	if exists {
+		c.fail(CloseSubscriberExist, fmt.Sprintf("Subscriber for %s already exists", msg.ID))
+		return false
+	}
+
+	var payload SubscribePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil || payload.Query == "" {
+		c.fail(CloseBadRequest, "Invalid subscribe payload")
+		return false
+	}
+
+	topic, responseKey, err := c.resolve(payload)
+	if err != nil {
+		// Operation errors complete the subscription with an error message
+		errs, _ := json.Marshal([]Error{{Message: err.Error()}})
+		c.queue(Message{ID: msg.ID, Type: TypeError, Payload: errs})
+		return true
+	}
+
+	c.mu.Lock()
+	c.subs[msg.ID] = &subscription{topic: topic, responseKey: responseKey}
+	c.mu.Unlock()
+
+	if err := c.client.Subscribe(websocket.SubscriptionMessage{
+		Type:  websocket.MessageTypeSubscribe,
+		Topic: topic,
+	}); err != nil {
+		c.complete(msg.ID)
+		errs, _ := json.Marshal([]Error{{Message: err.Error()}})
+		c.queue(Message{ID: msg.ID, Type: TypeError, Payload: errs})
+	}
+	return true
+}
+
+// resolve returns the topic and response key of a subscription operation
+func (c *conn) resolve(payload SubscribePayload) (string, string, error) {
+	op, err := ParseSubscription(payload.Query, payload.OperationName)
+	if err != nil {
+		return "", "", err
+	}
+	template, ok := c.mapping[op.Field]
+	if !ok {
+		return "", "", fmt.Errorf("unknown subscription field %q", op.Field)
+	}
+	topic, err := ResolveTopic(template, payload.Variables)
+	if err != nil {
+		return "", "", err
+	}
+	return topic, op.ResponseKey, nil
+}
+
+// complete removes a subscription, the hub subscription is kept while other operations use the topic
+func (c *conn) complete(id string) {
+	c.mu.Lock()
+	sub, ok := c.subs[id]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.subs, id)
+	for _, other := range c.subs {
+		if other.topic == sub.topic {
+			c.mu.Unlock()
+			return
+		}
+	}
+	c.mu.Unlock()
+
+	c.client.Unsubscribe(sub.topic)
+}
+
+// results creates a next message for every operation subscribed to a topic of a hub message
+func (c *conn) results(msg *websocket.BroadcastMessage) [][]byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var messages [][]byte
+	for _, topic := range msg.AllTopics() {
+		for id, sub := range c.subs {
+			if sub.topic != topic {
+				continue
+			}
+			result, _ := json.Marshal(map[string]interface{}{
+				"data": map[string]json.RawMessage{sub.responseKey: msg.Message},
+			})
+			data, _ := json.Marshal(Message{ID: id, Type: TypeNext, Payload: result})
+			messages = append(messages, data)
+		}
+	}
+	return messages
+}
+
+// writePump sends hub deliveries and queued messages to the client
+func (c *conn) writePump() {
+	websocket.Pump{
+		Outbox: c.out,
+		Client: c.client,
+		Conn:   c.ws,
+		Write:  c.write,
+		WriteClose: func(message []byte) {
+			c.ws.WriteControl(gorilla.CloseMessage, message, time.Now().Add(writeWait))
+		},
+		Deliver: func(delivery websocket.Delivery) error {
+			if delivery.Message == nil {
+				return nil
+			}
+			for _, data := range c.results(delivery.Message) {
+				if err := c.write(data); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Closed: func(reason websocket.CloseReason) {
+			c.ws.WriteControl(gorilla.CloseMessage, reason.Message(), time.Now().Add(writeWait))
+		},
+		Ping: websocket.PingTick(c.ws, c.server.hub.PingInterval(), writeWait),
+	}.Run()
+}
+
+// write writes a single message to the client
+func (c *conn) write(data []byte) error {
+	c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.ws.WriteMessage(gorilla.TextMessage, data)
+}
@@ -0,0 +1,196 @@
+// Package introspection resolves opaque client tokens with an OAuth 2.0 token introspection endpoint (RFC 7662).
+package introspection
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/make0x20/driplet/internal/callback"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTimeout is the default time allowed for an introspection request
+	DefaultTimeout = 5 * time.Second
+	// DefaultCacheTTL is the default time active tokens are cached
+	DefaultCacheTTL = time.Minute
+	// DefaultNegativeCacheTTL is the default time inactive tokens are cached
+	DefaultNegativeCacheTTL = 10 * time.Second
+	// maxCacheSize is the maximum number of cached results
+	maxCacheSize = 10000
+	// maxResponseSize is the maximum size of an introspection response
+	maxResponseSize = 1 << 20
+)
+
+// ErrInactive is returned for tokens the introspection endpoint reports as not active
+var ErrInactive = errors.New("token is not active")
+
+// Options configures an introspection client
+type Options struct {
+	// URL is the introspection endpoint
+	URL string
+	// ClientID and ClientSecret authenticate driplet with HTTP Basic authentication, not sent if empty
+	ClientID     string
+	ClientSecret string
+	Timeout      time.Duration
+	// CacheTTL and NegativeCacheTTL are the times active and inactive results are cached
+	CacheTTL         time.Duration
+	NegativeCacheTTL time.Duration
+}
+
+// result is a cached introspection result
+type result struct {
+	claims json.RawMessage
+	err    error
+}
+
+// call is an introspection request in flight, concurrent requests for the same token wait for it
+type call struct {
+	done   chan struct{}
+	claims json.RawMessage
+	err    error
+}
+
+// Client introspects tokens and caches the results
+type Client struct {
+	options Options
+	client  *http.Client
+	timeNow func() time.Time
+
+	cache    *callback.Cache[result]
+	mu       sync.Mutex
+	inflight map[[32]byte]*call
+}
+
+// New creates an introspection client, zero durations use the defaults
+func New(options Options) *Client {
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if options.CacheTTL <= 0 {
+		options.CacheTTL = DefaultCacheTTL
+	}
+	if options.NegativeCacheTTL <= 0 {
+		options.NegativeCacheTTL = DefaultNegativeCacheTTL
+	}
+	c := &Client{
+		options:  options,
+		client:   &http.Client{Timeout: options.Timeout},
+		timeNow:  time.Now,
+		inflight: make(map[[32]byte]*call),
+	}
+	c.cache = callback.NewCache[result](maxCacheSize, func() time.Time { return c.timeNow() })
+	return c
+}
+
+// Introspect returns the introspection response of an active token as a JSON object.
+// Returns ErrInactive for inactive tokens, results are cached and concurrent requests for a token are merged.
+func (c *Client) Introspect(ctx context.Context, token string) (json.RawMessage, error) {
+	if token == "" {
+		return nil, errors.New("missing token")
+	}
+	// Tokens are only kept hashed
+	key := sha256.Sum256([]byte(token))
+
+	c.mu.Lock()
+	if cached, ok := c.cache.Get(key); ok {
+		c.mu.Unlock()
+		return cached.claims, cached.err
+	}
+	if inflight, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-inflight.done:
+			// The merged request was cancelled by its own caller, introspect again
+			if errors.Is(inflight.err, context.Canceled) || errors.Is(inflight.err, context.DeadlineExceeded) {
+				return c.Introspect(ctx, token)
+			}
+			return inflight.claims, inflight.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	current := &call{done: make(chan struct{})}
+	c.inflight[key] = current
+	c.mu.Unlock()
+
+	current.claims, current.err = c.request(ctx, token)
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if expiresAt, ok := c.cacheUntil(current.claims, current.err); ok {
+		c.cache.Set(key, result{claims: current.claims, err: current.err}, expiresAt)
+	}
+	c.mu.Unlock()
+	close(current.done)
+
+	return current.claims, current.err
+}
+
+// cacheUntil returns when a result expires from the cache, errors other than ErrInactive are not cached.
+// Active tokens are not cached beyond their exp.
+func (c *Client) cacheUntil(claims json.RawMessage, err error) (time.Time, bool) {
+	now := c.timeNow()
+	if errors.Is(err, ErrInactive) {
+		return now.Add(c.options.NegativeCacheTTL), true
+	}
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	expiresAt := now.Add(c.options.CacheTTL)
+	var response struct {
+		Exp float64 `json:"exp"`
+	}
+	if json.Unmarshal(claims, &response) == nil && response.Exp > 0 {
+		if exp := time.Unix(int64(response.Exp), 0); exp.Before(expiresAt) {
+			expiresAt = exp
+		}
+	}
+	return expiresAt, true
+}
+
+// request posts the token to the introspection endpoint
+func (c *Client) request(ctx context.Context, token string) (json.RawMessage, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.options.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.options.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(c.options.ClientID), url.QueryEscape(c.options.ClientSecret))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("introspection request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection request failed: unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("introspection request failed: %w", err)
+	}
+	var response struct {
+		Active bool `json:"active"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("invalid introspection response: %w", err)
+	}
+	if !response.Active {
+		return nil, ErrInactive
+	}
+	return body, nil
+}
@@ -0,0 +1,149 @@
+package introspection
+
+import (
+	"context"
+	"errors"
+	"github.com/make0x20/driplet/internal/jwt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer returns an introspection endpoint that knows the token "active", it counts requests
+func newTestServer(t *testing.T, status *atomic.Int32) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		// Let concurrent requests pile up
+		time.Sleep(20 * time.Millisecond)
+
+		if id, secret, ok := r.BasicAuth(); !ok || id != "driplet" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if status != nil && status.Load() != 0 {
+			w.WriteHeader(int(status.Load()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostFormValue("token") == "active" {
+			w.Write([]byte(`{"active":true,"sub":"42","roles":["admin"],"exp":4102444800}`))
+			return
+		}
+		w.Write([]byte(`{"active":false}`))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &requests
+}
+
+// TestIntrospect verifies token introspection:
+// - Active tokens return the response, inactive tokens ErrInactive
+// - Positive and negative results are cached until their TTL
+// - Concurrent requests for a token are merged
+// - Failed requests are not cached
+func TestIntrospect(t *testing.T) {
+	var status atomic.Int32
+	ts, requests := newTestServer(t, &status)
+	now := time.Now()
+	c := New(Options{URL: ts.URL, ClientID: "driplet", ClientSecret: "s3cret", CacheTTL: time.Minute, NegativeCacheTTL: 10 * time.Second})
+	c.timeNow = func() time.Time { return now }
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Introspect(context.Background(), "active"); err != nil {
+				t.Errorf("expected active token: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := c.Introspect(context.Background(), "unknown"); !errors.Is(err, ErrInactive) {
+		t.Errorf("expected inactive token, got %v", err)
+	}
+	c.Introspect(context.Background(), "unknown")
+	if n := requests.Load(); n != 2 {
+		t.Errorf("expected 2 requests with cached and merged results, got %d", n)
+	}
+
+	// The negative result expires first
+	now = now.Add(30 * time.Second)
+	c.Introspect(context.Background(), "active")
+	c.Introspect(context.Background(), "unknown")
+	if n := requests.Load(); n != 3 {
+		t.Errorf("expected the inactive token to be introspected again, got %d requests", n)
+	}
+
+	// Errors are not cached
+	now = now.Add(time.Hour)
+	status.Store(http.StatusInternalServerError)
+	if _, err := c.Introspect(context.Background(), "active"); err == nil || errors.Is(err, ErrInactive) {
+		t.Errorf("expected request error, got %v", err)
+	}
+	status.Store(0)
+	if _, err := c.Introspect(context.Background(), "active"); err != nil {
+		t.Errorf("expected active token after the error: %v", err)
+	}
+}
+
+// TestIntrospectCancel verifies cancelled introspection requests:
+// - The request is aborted with the context of its caller and the error is not cached
+// - Callers merged into a cancelled request introspect again
+func TestIntrospectCancel(t *testing.T) {
+	ts, requests := newTestServer(t, nil)
+	c := New(Options{URL: ts.URL, ClientID: "driplet", ClientSecret: "s3cret", CacheTTL: time.Minute})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	merged := make(chan error, 1)
+	go func() {
+		// Join the request that is about to be cancelled
+		time.Sleep(2 * time.Millisecond)
+		_, err := c.Introspect(context.Background(), "active")
+		merged <- err
+	}()
+	if _, err := c.Introspect(ctx, "active"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the request to be cancelled, got %v", err)
+	}
+	if err := <-merged; err != nil {
+		t.Errorf("expected the merged caller to introspect again: %v", err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("expected the cancelled request not to be cached, got %d requests", n)
+	}
+}
+
+// TestValidator verifies that the introspection response becomes the targeting claims of the endpoint,
+// and that introspection is cancelled with the client request
+func TestValidator(t *testing.T) {
+	ts, _ := newTestServer(t, nil)
+	v := jwt.NewValidator(nil, jwt.WithEndpointIntrospector("web", New(Options{URL: ts.URL, ClientID: "driplet", ClientSecret: "s3cret"})))
+
+	// Introspection is cancelled with the client request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest(http.MethodGet, "/web", nil).WithContext(ctx)
+	if _, err := v.ValidateEndpointRequest(r, "active", "web"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected introspection to be cancelled with the request, got %v", err)
+	}
+
+	claims, err := v.ValidateEndpointToken(context.Background(), "active", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "42" || claims.ExpiresAt == nil {
+		t.Errorf("unexpected registered claims %+v", claims.RegisteredClaims)
+	}
+	if roles, ok := claims.GetCustomClaim("roles"); !ok || roles.([]interface{})[0] != "admin" {
+		t.Errorf("expected roles to be visible to targeting, got %v", roles)
+	}
+
+	if _, err := v.ValidateEndpointToken(context.Background(), "unknown", "web"); !errors.Is(err, ErrInactive) {
+		t.Errorf("expected inactive token to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultJWKSRefresh is the default interval of JWKS refreshes
+	DefaultJWKSRefresh = 5 * time.Minute
+	// jwksUnknownKidRefresh is the minimum time between refreshes caused by tokens with an unknown kid
+	jwksUnknownKidRefresh = 30 * time.Second
+	// jwksTimeout is the time allowed to fetch a JWKS document
+	jwksTimeout = 10 * time.Second
+	// maxJWKSSize is the maximum size of a JWKS document
+	maxJWKSSize = 1 << 20
+)
+
+// JWK is a single JSON Web Key of a key set
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set loaded from a file or URL and refreshed periodically.
+// A failed refresh keeps the last good set.
+type JWKS struct {
+	source   string
+	interval time.Duration
+	logger   *slog.Logger
+	client   *http.Client
+
+	mu   sync.RWMutex
+	keys []Key
+
+	// refreshMu serializes refreshes, lastRefresh limits refreshes caused by unknown kids
+	refreshMu   sync.Mutex
+	lastRefresh time.Time
+}
+
+// NewJWKS creates a key set for a file path or an http(s) URL, the keys are loaded by Refresh
+func NewJWKS(source string, interval time.Duration, logger *slog.Logger) *JWKS {
+	if interval <= 0 {
+		interval = DefaultJWKSRefresh
+	}
+	return &JWKS{
+		source:   source,
+		interval: interval,
+		logger:   logger,
+		client:   &http.Client{Timeout: jwksTimeout},
+	}
+}
+
+// Keys returns the keys of the last good set
+func (j *JWKS) Keys() []Key {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.keys
+}
+
+// Refresh loads the key set, the current keys are kept if it fails
+func (j *JWKS) Refresh(ctx context.Context) error {
+	j.refreshMu.Lock()
+	defer j.refreshMu.Unlock()
+	return j.refresh(ctx)
+}
+
+// refresh loads the key set, callers must hold refreshMu
+func (j *JWKS) refresh(ctx context.Context) error {
+	j.lastRefresh = time.Now()
+
+	data, err := j.fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("could not load JWKS from %s: %w", j.source, err)
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("invalid JWKS from %s: %w", j.source, err)
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.mu.Unlock()
+	return nil
+}
+
+// refreshUnknownKid refreshes the set for a kid that is not in it, at most once per jwksUnknownKidRefresh.
+// Returns true if the set was refreshed.
+func (j *JWKS) refreshUnknownKid(kid string) bool {
+	j.refreshMu.Lock()
+	defer j.refreshMu.Unlock()
+
+	// Another request may have refreshed the set while this one waited
+	for _, key := range j.Keys() {
+		if key.ID == kid {
+			return true
+		}
+	}
+	if time.Since(j.lastRefresh) < jwksUnknownKidRefresh {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jwksTimeout)
+	defer cancel()
+	if err := j.refresh(ctx); err != nil {
+		j.logger.Error("Could not refresh JWKS for unknown kid", "kid", kid, "error", err)
+		return false
+	}
+	return true
+}
+
+// Run refreshes the key set on the interval until the context is done
+func (j *JWKS) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := j.Refresh(ctx); err != nil {
+				j.logger.Error("Could not refresh JWKS, keeping the last good set", "error", err)
+			}
+		}
+	}
+}
+
+// fetch reads the key set document from the file or URL
+func (j *JWKS) fetch(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(j.source, "http://") && !strings.HasPrefix(j.source, "https://") {
+		return os.ReadFile(j.source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
+
+// ParseJWKS parses the signature keys of a JWKS document, keys of unsupported types or for encryption are skipped
+func ParseJWKS(data []byte) ([]Key, error) {
+	var set struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	var keys []Key
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, Key{ID: jwk.Kid, Algorithm: jwk.Alg, Key: key})
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no supported signature keys")
+	}
+	return keys, nil
+}
+
+// PublicKey returns the RSA, ECDSA or Ed25519 public key of the JWK
+func (k JWK) PublicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// decodeBigInt decodes a base64url encoded unsigned big-endian integer
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
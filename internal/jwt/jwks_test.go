@@ -0,0 +1,177 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) JWK {
+	return JWK{Kty: "RSA", Kid: kid, Use: "sig", Alg: "RS256", N: b64(key.N.Bytes()), E: b64(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) JWK {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return JWK{Kty: "EC", Kid: kid, Crv: key.Curve.Params().Name, X: b64(key.X.FillBytes(make([]byte, size))), Y: b64(key.Y.FillBytes(make([]byte, size)))}
+}
+
+func jwksDocument(t *testing.T, keys ...JWK) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestParseJWKS verifies parsing of RSA, EC and OKP keys, unsupported and encryption keys are skipped
+func TestParseJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	edPublic, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	encryption := rsaJWK("enc", &rsaKey.PublicKey)
+	encryption.Use = "enc"
+	offCurve := ecJWK("off", &ecKey.PublicKey)
+	offCurve.Y = b64([]byte{1})
+
+	keys, err := ParseJWKS(jwksDocument(t,
+		rsaJWK("rsa", &rsaKey.PublicKey),
+		ecJWK("ec", &ecKey.PublicKey),
+		JWK{Kty: "OKP", Kid: "ed", Crv: "Ed25519", X: b64(edPublic)},
+		JWK{Kty: "oct", Kid: "secret"},
+		encryption,
+		offCurve,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 keys, got %d", len(keys))
+	}
+	if keys[0].ID != "rsa" || keys[0].Algorithm != "RS256" || !rsaKey.PublicKey.Equal(keys[0].Key) {
+		t.Errorf("unexpected RSA key %+v", keys[0])
+	}
+	if keys[1].ID != "ec" || !ecKey.PublicKey.Equal(keys[1].Key) {
+		t.Errorf("unexpected EC key %+v", keys[1])
+	}
+	if keys[2].ID != "ed" || !edPublic.Equal(keys[2].Key) {
+		t.Errorf("unexpected Ed25519 key %+v", keys[2])
+	}
+
+	if _, err := ParseJWKS(jwksDocument(t, JWK{Kty: "oct"})); err == nil {
+		t.Error("expected set without signature keys to be rejected")
+	}
+}
+
+// TestJWKSRotation verifies key sets served over HTTP:
+// - Tokens are verified with the key selected by kid
+// - An unknown kid refreshes the set, at most once per interval
+// - A failed refresh keeps the last good set
+// - The alg of a JWK restricts the key
+func TestJWKSRotation(t *testing.T) {
+	first, _ := rsa.GenerateKey(rand.Reader, 2048)
+	second, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var mu sync.Mutex
+	document := jwksDocument(t, rsaJWK("first", &first.PublicKey))
+	status := http.StatusOK
+	fetches := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		w.WriteHeader(status)
+		w.Write(document)
+	}))
+	defer ts.Close()
+
+	jwks := NewJWKS(ts.URL, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := jwks.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewKeys(nil, jwks, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewValidator(nil, WithEndpointKeys("web", keys))
+
+	if _, err := v.ValidateEndpointToken(context.Background(), sign(t, jwt.SigningMethodRS256, first, "first"), "web"); err != nil {
+		t.Errorf("expected token of the first key to be valid: %v", err)
+	}
+	if _, err := v.ValidateEndpointToken(context.Background(), sign(t, jwt.SigningMethodPS256, first, "first"), "web"); err == nil {
+		t.Error("expected PS256 token to be rejected by an RS256 key")
+	}
+
+	// The identity provider rotates to the second key
+	mu.Lock()
+	document = jwksDocument(t, ecJWK("second", &second.PublicKey))
+	mu.Unlock()
+
+	// Unknown kids do not refresh the set right after a refresh
+	if _, err := v.ValidateEndpointToken(context.Background(), sign(t, jwt.SigningMethodES256, second, "second"), "web"); err == nil {
+		t.Error("expected unknown kid to be rejected within the refresh limit")
+	}
+
+	jwks.lastRefresh = time.Time{}
+	if _, err := v.ValidateEndpointToken(context.Background(), sign(t, jwt.SigningMethodES256, second, "second"), "web"); err != nil {
+		t.Errorf("expected unknown kid to refresh the set: %v", err)
+	}
+	if _, err := v.ValidateEndpointToken(context.Background(), sign(t, jwt.SigningMethodRS256, first, "first"), "web"); err == nil {
+		t.Error("expected token of the removed key to be rejected")
+	}
+
+	// A failed refresh keeps the last good set
+	mu.Lock()
+	status = http.StatusInternalServerError
+	mu.Unlock()
+	if err := jwks.Refresh(context.Background()); err == nil {
+		t.Error("expected refresh to fail")
+	}
+	if _, err := v.ValidateEndpointToken(context.Background(), sign(t, jwt.SigningMethodES256, second, "second"), "web"); err != nil {
+		t.Errorf("expected last good set to be kept: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fetches != 3 {
+		t.Errorf("expected 3 fetches, got %d", fetches)
+	}
+}
+
+// TestJWKSFile verifies key sets loaded from a file
+func TestJWKSFile(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwksDocument(t, ecJWK("file", &key.PublicKey)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := NewJWKS(path, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := jwks.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if keys := jwks.Keys(); len(keys) != 1 || keys[0].ID != "file" {
+		t.Errorf("unexpected keys %+v", keys)
+	}
+}
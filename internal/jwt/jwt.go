@@ -1,13 +1,17 @@
 package jwt
 
 import (
+	"context"
 	"crypto/hmac"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/make0x20/driplet/internal/metrics"
 	"github.com/make0x20/driplet/internal/nonce"
-	"strings"
+	"net/http"
+	"slices"
 	"time"
 )
 
@@ -15,6 +19,19 @@ import (
 type Claims struct {
 	jwt.RegisteredClaims
 	Custom map[string]interface{} `json:"custom,omitempty"`
+	// Raw holds the whole token payload
+	Raw map[string]interface{} `json:"-"`
+	// view holds the targeting attributes built by the claim source of the endpoint, Custom is used if nil
+	view map[string]interface{}
+}
+
+// UnmarshalJSON decodes the claims and keeps the whole payload in Raw
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	type plain Claims
+	if err := json.Unmarshal(data, (*plain)(c)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &c.Raw)
 }
 
 // MessageMetadata holds nonce and timestamp for validating API messages
@@ -23,97 +40,414 @@ type MessageMetadata struct {
 	Timestamp int64  `json:"timestamp"`
 }
 
-// GetCustomClaim retrieves a custom claim from the claims
-func (c *Claims) GetCustomClaim(path string) (interface{}, bool) {
-	parts := strings.Split(path, ".")
-	current := interface{}(c.Custom)
-	for _, part := range parts {
-		m, ok := current.(map[string]interface{})
-		if !ok {
-			return nil, false
-		}
-		current, ok = m[part]
-		if !ok {
-			return nil, false
-		}
+// Attributes returns the targeting attributes of the claims
+func (c *Claims) Attributes() map[string]interface{} {
+	if c.view != nil {
+		return c.view
 	}
-	return current, true
+	return c.Custom
 }
 
+// GetCustomClaim retrieves a targeting attribute by its dot-separated path
+func (c *Claims) GetCustomClaim(path string) (interface{}, bool) {
+	return Lookup(c.Attributes(), path)
+}
+
+// GetClaim retrieves a claim of the token payload by its dot-separated path
+func (c *Claims) GetClaim(path string) (interface{}, bool) {
+	return Lookup(c.Raw, path)
+}
+
+// ErrTokenRevoked is returned for client tokens on the revocation list
+var ErrTokenRevoked = errors.New("invalid token: token has been revoked")
+
 // Validator validates JWT and API tokens
 type Validator struct {
 	nonceStore nonce.Store
 	timeNow    func() time.Time
+	// endpoints holds the keys client tokens of each endpoint are verified with
+	endpoints map[string]*Keys
+	// rules holds the claim checks of each endpoint
+	rules map[string]Rules
+	// sources holds the claim source of each endpoint
+	sources map[string]ClaimSource
+	// introspectors holds the introspection client of endpoints with opaque client tokens
+	introspectors map[string]Introspector
+	// authenticators holds the request authenticator of endpoints authenticating clients by their HTTP request
+	authenticators map[string]RequestAuthenticator
+	// enrichers holds the enrichment hook of endpoints adding targeting attributes to verified claims
+	enrichers map[string]Enricher
+	revoker   Revoker
+	// apiSecrets holds the secrets API requests of each endpoint are signed with
+	apiSecrets map[string][]Key
+	registry   *metrics.Registry
+	// secretsUsed counts the verified requests by the key that verified them
+	secretsUsed *metrics.CounterVec
+}
+
+// Revoker reports whether a verified client token of an endpoint has been revoked
+type Revoker interface {
+	Revoked(endpoint string, payload map[string]interface{}) bool
+}
+
+// Introspector resolves an opaque client token to the JSON object of its claims
+type Introspector interface {
+	Introspect(ctx context.Context, token string) (json.RawMessage, error)
+}
+
+// RequestAuthenticator authenticates the HTTP request of a client, e.g. by its session cookie, and returns the JSON object of its claims
+type RequestAuthenticator interface {
+	Authenticate(ctx context.Context, r *http.Request) (json.RawMessage, error)
+}
+
+// Enricher returns targeting attributes for the verified claims of a client, e.g. from a database
+type Enricher interface {
+	Enrich(ctx context.Context, endpoint string, claims map[string]interface{}) (map[string]interface{}, error)
+}
+
+// Option configures a Validator
+type Option func(*Validator)
+
+// WithEndpointKeys sets the keys client tokens of an endpoint are verified with
+func WithEndpointKeys(endpoint string, keys *Keys) Option {
+	return func(v *Validator) {
+		v.endpoints[endpoint] = keys
+	}
+}
+
+// WithEndpointRules sets the claim checks client tokens of an endpoint must pass
+func WithEndpointRules(endpoint string, rules Rules) Option {
+	return func(v *Validator) {
+		v.rules[endpoint] = rules
+	}
+}
+
+// WithEndpointClaimSource sets the claim source targeting attributes of an endpoint are built with
+func WithEndpointClaimSource(endpoint string, source ClaimSource) Option {
+	return func(v *Validator) {
+		v.sources[endpoint] = source
+	}
+}
+
+// WithEndpointIntrospector validates the client tokens of an endpoint as opaque tokens with an introspection client
+func WithEndpointIntrospector(endpoint string, introspector Introspector) Option {
+	return func(v *Validator) {
+		v.introspectors[endpoint] = introspector
+	}
+}
+
+// WithEndpointRequestAuthenticator authenticates the clients of an endpoint by their HTTP request instead of a token
+func WithEndpointRequestAuthenticator(endpoint string, authenticator RequestAuthenticator) Option {
+	return func(v *Validator) {
+		v.authenticators[endpoint] = authenticator
+	}
+}
+
+// WithEndpointEnricher sets the enrichment hook of an endpoint
+func WithEndpointEnricher(endpoint string, enricher Enricher) Option {
+	return func(v *Validator) {
+		v.enrichers[endpoint] = enricher
+	}
+}
+
+// WithRevoker sets the revocation list client tokens are checked against
+func WithRevoker(revoker Revoker) Option {
+	return func(v *Validator) {
+		v.revoker = revoker
+	}
+}
+
+// WithEndpointAPISecrets sets the secrets API requests of an endpoint are signed with, tried in order
+func WithEndpointAPISecrets(endpoint string, secrets []Key) Option {
+	return func(v *Validator) {
+		v.apiSecrets[endpoint] = secrets
+	}
+}
+
+// WithMetrics sets the metrics registry used by the validator
+func WithMetrics(registry *metrics.Registry) Option {
+	return func(v *Validator) {
+		v.registry = registry
+	}
 }
 
 // NewValidator creates a new Validator
-func NewValidator(store nonce.Store) *Validator {
+func NewValidator(store nonce.Store, options ...Option) *Validator {
 	if store == nil {
 		store = nonce.NewMemoryStore()
 	}
 
-	return &Validator{
-		nonceStore: store,
-		timeNow:    time.Now,
+	v := &Validator{
+		nonceStore:     store,
+		timeNow:        time.Now,
+		endpoints:      make(map[string]*Keys),
+		rules:          make(map[string]Rules),
+		sources:        make(map[string]ClaimSource),
+		introspectors:  make(map[string]Introspector),
+		authenticators: make(map[string]RequestAuthenticator),
+		enrichers:      make(map[string]Enricher),
+		apiSecrets:     make(map[string][]Key),
+	}
+	for _, opt := range options {
+		opt(v)
 	}
+	if v.registry == nil {
+		v.registry = metrics.NewRegistry()
+	}
+	v.secretsUsed = v.registry.NewCounterVec("driplet_auth_secret_used_total",
+		"Number of verified client tokens and API requests by the secret or key that verified them.", "endpoint", "kind", "secret")
+	return v
 }
 
-// ValidateClientToken validates a client JWT token
+// ValidateClientToken validates a client JWT token signed with an HMAC secret
 func (v *Validator) ValidateClientToken(tokenString string, jwtSecret string) (*Claims, error) {
-	// Parse and validate token
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	claims, _, err := v.validate(tokenString, &Keys{
+		Keys:       []Key{{Key: []byte(jwtSecret)}},
+		Algorithms: hmacAlgorithms,
+	}, Rules{})
+	return claims, err
+}
+
+// ValidateEndpointToken validates a client JWT token with the keys of an endpoint, or an opaque token with its introspection client.
+// Introspection and enrichment callbacks are cancelled with ctx
+func (v *Validator) ValidateEndpointToken(ctx context.Context, tokenString string, endpoint string) (*Claims, error) {
+	claims, err := v.verifyEndpointToken(ctx, tokenString, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return v.enrich(ctx, claims, endpoint)
+}
+
+// verifyEndpointToken verifies a client token of an endpoint and builds its targeting attributes,
+// an introspection request is cancelled with ctx
+func (v *Validator) verifyEndpointToken(ctx context.Context, tokenString string, endpoint string) (*Claims, error) {
+	if introspector, ok := v.introspectors[endpoint]; ok {
+		return v.introspect(ctx, tokenString, endpoint, introspector)
+	}
+
+	keys, ok := v.endpoints[endpoint]
+	if !ok {
+		return nil, fmt.Errorf("no keys configured for endpoint %q", endpoint)
+	}
+	claims, key, err := v.validate(tokenString, keys, v.rules[endpoint])
+	if err != nil {
+		return nil, err
+	}
+	if v.revoker != nil && v.revoker.Revoked(endpoint, claims.Raw) {
+		return nil, ErrTokenRevoked
+	}
+	v.secretsUsed.With(endpoint, "jwt", key.name()).Inc()
+	v.sources[endpoint].apply(claims)
+	return claims, nil
+}
+
+// ValidateEndpointRequest authenticates the HTTP request of a client with the request authenticator of an endpoint,
+// or validates its token if the endpoint has none
+func (v *Validator) ValidateEndpointRequest(r *http.Request, tokenString string, endpoint string) (*Claims, error) {
+	authenticator, ok := v.authenticators[endpoint]
+	if !ok {
+		claims, err := v.verifyEndpointToken(r.Context(), tokenString, endpoint)
+		if err != nil {
+			return nil, err
 		}
-		return []byte(jwtSecret), nil
-	})
+		return v.enrich(r.Context(), claims, endpoint)
+	}
+
+	data, err := authenticator.Authenticate(r.Context(), r)
 	if err != nil {
-		return nil, fmt.Errorf("invalid token: %w", err)
+		return nil, fmt.Errorf("invalid session: %w", err)
 	}
+	claims, err := v.claimsFrom(data, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return v.enrich(r.Context(), claims, endpoint)
+}
 
-	// Validate claims
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+// enrich merges the attributes returned by the enrichment hook of an endpoint into the targeting attributes,
+// the callback is cancelled with ctx
+func (v *Validator) enrich(ctx context.Context, claims *Claims, endpoint string) (*Claims, error) {
+	enricher, ok := v.enrichers[endpoint]
+	if !ok {
+		return claims, nil
+	}
+
+	attributes, err := enricher.Enrich(ctx, endpoint, claims.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("enrichment failed: %w", err)
+	}
+	if len(attributes) == 0 {
 		return claims, nil
 	}
 
-	return nil, fmt.Errorf("invalid token claims")
+	// Copy the attributes, the view may be the token payload
+	view := make(map[string]interface{}, len(claims.Attributes())+len(attributes))
+	for name, value := range claims.Attributes() {
+		view[name] = value
+	}
+	for name, value := range attributes {
+		view[name] = value
+	}
+	claims.view = view
+	return claims, nil
+}
+
+// introspect validates an opaque token, the introspection request is cancelled with ctx
+func (v *Validator) introspect(ctx context.Context, token string, endpoint string, introspector Introspector) (*Claims, error) {
+	data, err := introspector.Introspect(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	return v.claimsFrom(data, endpoint)
+}
+
+// claimsFrom decodes the claims returned by a backend, the whole object is used for targeting
+func (v *Validator) claimsFrom(data json.RawMessage, endpoint string) (*Claims, error) {
+	claims := &Claims{}
+	if err := json.Unmarshal(data, claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %w", err)
+	}
+	if v.revoker != nil && v.revoker.Revoked(endpoint, claims.Raw) {
+		return nil, ErrTokenRevoked
+	}
+	claims.view = claims.Raw
+	return claims, nil
+}
+
+// ValidatePublisherToken validates a publisher JWT token signed with one of the API secrets of an endpoint
+func (v *Validator) ValidatePublisherToken(tokenString string, endpoint string) (*Claims, error) {
+	claims, key, err := v.validate(tokenString, &Keys{
+		Keys:       v.apiSecrets[endpoint],
+		Algorithms: hmacAlgorithms,
+	}, Rules{})
+	if err != nil {
+		return nil, err
+	}
+	v.secretsUsed.With(endpoint, "publisher", key.name()).Inc()
+	return claims, nil
+}
+
+// APISecrets returns the unexpired API secrets of an endpoint in order
+func (v *Validator) APISecrets(endpoint string) []string {
+	var secrets []string
+	for _, secret := range v.apiSecrets[endpoint] {
+		if !secret.expired(v.timeNow()) {
+			secrets = append(secrets, string(secret.Key.([]byte)))
+		}
+	}
+	return secrets
+}
+
+// hmacAlgorithms are the algorithms of tokens signed with a secret
+var hmacAlgorithms = []string{"HS256", "HS384", "HS512"}
+
+// validate verifies a token with the first matching key, checks its claims against the rules and returns its claims and the key
+func (v *Validator) validate(tokenString string, keys *Keys, rules Rules) (*Claims, Key, error) {
+	options := []jwt.ParserOption{jwt.WithValidMethods(keys.Algorithms), jwt.WithTimeFunc(v.timeNow)}
+	parser := jwt.NewParser(append(options, rules.parserOptions()...)...)
+
+	// Read the header first to find the keys the token may be signed with
+	unverified, _, err := parser.ParseUnverified(tokenString, &Claims{})
+	if err != nil {
+		return nil, Key{}, fmt.Errorf("invalid token: %w", err)
+	}
+	if !slices.Contains(keys.Algorithms, unverified.Method.Alg()) {
+		return nil, Key{}, fmt.Errorf("invalid token: signing method %s is not allowed", unverified.Method.Alg())
+	}
+	candidates := keys.candidates(unverified, v.timeNow())
+	if kid, _ := unverified.Header["kid"].(string); len(candidates) == 0 && kid != "" && keys.JWKS != nil {
+		// The identity provider may have rotated its keys
+		if keys.JWKS.refreshUnknownKid(kid) {
+			candidates = keys.candidates(unverified, v.timeNow())
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, Key{}, fmt.Errorf("invalid token: no key for signing method %s and kid %v", unverified.Method.Alg(), unverified.Header["kid"])
+	}
+
+	for _, key := range candidates {
+		claims := &Claims{}
+		token, err := parser.ParseWithClaims(tokenString, claims, func(*jwt.Token) (interface{}, error) {
+			return key.Key, nil
+		})
+		if err == nil {
+			// Validate claims
+			if !token.Valid {
+				return nil, Key{}, fmt.Errorf("invalid token claims")
+			}
+			if err := rules.checkRequired(claims); err != nil {
+				return nil, Key{}, err
+			}
+			return claims, key, nil
+		}
+
+		// Only a signature mismatch is worth trying the next key
+		if !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+			return nil, Key{}, rules.describe(err, claims)
+		}
+	}
+	return nil, Key{}, fmt.Errorf("invalid token: %w", jwt.ErrTokenSignatureInvalid)
 }
 
 // ValidateAPIToken validates an API token
 func (v *Validator) ValidateAPIToken(signature string, payload []byte, endpoint string, apiSecret string) error {
-	// Validate HMAC signature
-	mac := hmac.New(jwt.SigningMethodHS256.Hash.New, []byte(apiSecret))
-	mac.Write(payload)
-	expectedMAC := mac.Sum(nil)
+	_, err := v.validateAPI(signature, payload, endpoint, []Key{{Key: []byte(apiSecret)}})
+	return err
+}
+
+// ValidateEndpointAPIToken validates an API token signed with one of the API secrets of an endpoint
+func (v *Validator) ValidateEndpointAPIToken(signature string, payload []byte, endpoint string) error {
+	key, err := v.validateAPI(signature, payload, endpoint, v.apiSecrets[endpoint])
+	if err != nil {
+		return err
+	}
+	v.secretsUsed.With(endpoint, "api", key.name()).Inc()
+	return nil
+}
 
+// validateAPI verifies the signature with the first matching unexpired secret and checks the metadata
+func (v *Validator) validateAPI(signature string, payload []byte, endpoint string, secrets []Key) (Key, error) {
 	// Decode signature
 	providedMAC, err := hex.DecodeString(signature)
 	if err != nil {
-		return fmt.Errorf("invalid signature format")
+		return Key{}, fmt.Errorf("invalid signature format")
 	}
 
-	// Compare signatures
-	if !hmac.Equal(providedMAC, expectedMAC) {
-		return fmt.Errorf("invalid signature")
+	// Validate HMAC signature with each secret
+	var key Key
+	matched := false
+	for _, secret := range secrets {
+		if secret.expired(v.timeNow()) {
+			continue
+		}
+		mac := hmac.New(jwt.SigningMethodHS256.Hash.New, secret.Key.([]byte))
+		mac.Write(payload)
+		if hmac.Equal(providedMAC, mac.Sum(nil)) {
+			key, matched = secret, true
+			break
+		}
+	}
+	if !matched {
+		return Key{}, fmt.Errorf("invalid signature")
 	}
 
 	// Parse and validate metadata
 	var metadata MessageMetadata
 	if err := json.Unmarshal(payload, &metadata); err != nil {
-		return fmt.Errorf("invalid message format: %w", err)
+		return Key{}, fmt.Errorf("invalid message format: %w", err)
 	}
 
 	// Validate timestamp
 	now := v.timeNow().Unix()
 	if metadata.Timestamp < now-60 || metadata.Timestamp > now+60 {
-		return fmt.Errorf("message timestamp outside acceptable range")
+		return Key{}, fmt.Errorf("message timestamp outside acceptable range")
 	}
 
 	// Check and store nonce
 	if v.nonceStore.Check(metadata.Nonce, endpoint) {
-		return fmt.Errorf("nonce has been used before")
+		return Key{}, fmt.Errorf("nonce has been used before")
 	}
 	v.nonceStore.Store(metadata.Nonce, endpoint, v.timeNow().Add(time.Minute))
 
-	return nil
+	return key, nil
 }
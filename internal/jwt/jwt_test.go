@@ -1,10 +1,13 @@
 package jwt
 
 import (
+	"context"
 	"crypto/hmac"
 	"encoding/hex"
 	"encoding/json"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/make0x20/driplet/internal/metrics"
+	"strings"
 	"testing"
 	"time"
 )
@@ -155,6 +158,9 @@ func TestValidateClientToken(t *testing.T) {
 	if gotClaims.Custom["user"] != "test" {
 		t.Errorf("got claims = %v, want user=test", gotClaims.Custom)
 	}
+	if _, ok := gotClaims.Raw["exp"]; !ok {
+		t.Errorf("expected raw payload to contain exp, got %v", gotClaims.Raw)
+	}
 
 	// Test 2: Invalid signature should fail
 	_, err = v.ValidateClientToken(tokenString, "wrong-secret")
@@ -168,3 +174,75 @@ func TestValidateClientToken(t *testing.T) {
 		t.Error("expected invalid token format to fail")
 	}
 }
+
+// TestSecretRotation verifies endpoints with several secrets:
+// - Client tokens and API requests are verified with any unexpired secret
+// - The kid header selects the secret
+// - Expired secrets are rejected
+// - The metric counts the secret each request was verified with
+func TestSecretRotation(t *testing.T) {
+	fixedTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	secrets := []Key{
+		{Label: "current", Key: []byte("current-secret")},
+		{ID: "next", Key: []byte("next-secret")},
+		{Label: "old", Key: []byte("old-secret"), NotAfter: fixedTime.Add(-time.Hour)},
+	}
+	keys, err := NewKeys(secrets, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := metrics.NewRegistry()
+	v := NewValidator(newMockStore(), WithMetrics(registry), WithEndpointKeys("web", keys), WithEndpointAPISecrets("web", secrets))
+	v.timeNow = func() time.Time { return fixedTime }
+
+	tokens := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"current secret", sign(t, jwt.SigningMethodHS256, []byte("current-secret"), ""), true},
+		{"next secret", sign(t, jwt.SigningMethodHS256, []byte("next-secret"), ""), true},
+		{"next secret with kid", sign(t, jwt.SigningMethodHS256, []byte("next-secret"), "next"), true},
+		{"current secret with kid of next", sign(t, jwt.SigningMethodHS256, []byte("current-secret"), "next"), true},
+		{"expired secret", sign(t, jwt.SigningMethodHS256, []byte("old-secret"), ""), false},
+	}
+	for _, tt := range tokens {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.ValidateEndpointToken(context.Background(), tt.token, "web")
+			if tt.valid && err != nil {
+				t.Errorf("expected valid token: %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("expected token to be rejected")
+			}
+		})
+	}
+
+	apiRequest := func(secret, nonce string) error {
+		payload, _ := json.Marshal(MessageMetadata{Nonce: nonce, Timestamp: fixedTime.Unix()})
+		mac := hmac.New(jwt.SigningMethodHS256.Hash.New, []byte(secret))
+		mac.Write(payload)
+		return v.ValidateEndpointAPIToken(hex.EncodeToString(mac.Sum(nil)), payload, "web")
+	}
+	if err := apiRequest("next-secret", "a"); err != nil {
+		t.Errorf("expected request signed with the next secret to be valid: %v", err)
+	}
+	if err := apiRequest("old-secret", "b"); err == nil {
+		t.Error("expected request signed with the expired secret to be rejected")
+	}
+	if got := v.APISecrets("web"); len(got) != 2 || got[0] != "current-secret" || got[1] != "next-secret" {
+		t.Errorf("unexpected active API secrets %v", got)
+	}
+
+	var out strings.Builder
+	registry.Write(&out)
+	for _, line := range []string{
+		`driplet_auth_secret_used_total{endpoint="web",kind="jwt",secret="current"} 2`,
+		`driplet_auth_secret_used_total{endpoint="web",kind="jwt",secret="next"} 2`,
+		`driplet_auth_secret_used_total{endpoint="web",kind="api",secret="next"} 1`,
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("expected metric %s in\n%s", line, out.String())
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"slices"
+	"time"
+)
+
+// Key is a key client tokens can be verified with
+type Key struct {
+	// ID is matched against the kid header of tokens, keys without an id are tried for any token
+	ID string
+	// Label names the key in metrics, the ID is used if empty
+	Label string
+	// NotAfter is the time after which the key is no longer used, zero if it does not expire
+	NotAfter time.Time
+	// Algorithm restricts the key to tokens signed with the algorithm, e.g. the alg of a JWK
+	Algorithm string
+	// Key is an HMAC secret as []byte or an RSA, ECDSA or Ed25519 public key
+	Key interface{}
+}
+
+// Keys verifies the signatures of client tokens
+type Keys struct {
+	Keys []Key
+	// JWKS is an optional key set of an identity provider
+	JWKS *JWKS
+	// Algorithms are the allowed signing algorithms
+	Algorithms []string
+}
+
+// NewKeys creates a key set from HMAC secrets, public keys and an optional JWKS, keys are tried in order.
+// Without algorithms every algorithm matching one of the key types is allowed, without keys every token is rejected.
+func NewKeys(keyList []Key, jwks *JWKS, algorithms []string) (*Keys, error) {
+	keys := &Keys{JWKS: jwks}
+	for _, key := range keyList {
+		switch k := key.Key.(type) {
+		case []byte:
+			if len(k) == 0 {
+				return nil, errors.New("empty HMAC secret")
+			}
+		case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		default:
+			return nil, fmt.Errorf("unsupported key type %T", key.Key)
+		}
+		keys.Keys = append(keys.Keys, key)
+	}
+	if len(algorithms) == 0 {
+		algorithms = DefaultAlgorithms(keys.Keys)
+		if jwks != nil {
+			// The types of JWKS keys are only known once the set is loaded, each key is still only used with its own type
+			for _, alg := range asymmetricAlgorithms {
+				if !slices.Contains(algorithms, alg) {
+					algorithms = append(algorithms, alg)
+				}
+			}
+		}
+	}
+	for _, alg := range algorithms {
+		if alg == "none" || jwt.GetSigningMethod(alg) == nil {
+			return nil, fmt.Errorf("unsupported algorithm %q", alg)
+		}
+	}
+	keys.Algorithms = algorithms
+	return keys, nil
+}
+
+// asymmetricAlgorithms are the supported public key algorithms
+var asymmetricAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// DefaultAlgorithms returns the algorithms matching the types of the keys
+func DefaultAlgorithms(keys []Key) []string {
+	var algorithms []string
+	add := func(algs ...string) {
+		for _, alg := range algs {
+			if !slices.Contains(algorithms, alg) {
+				algorithms = append(algorithms, alg)
+			}
+		}
+	}
+
+	for _, key := range keys {
+		switch k := key.Key.(type) {
+		case []byte:
+			add("HS256", "HS384", "HS512")
+		case *rsa.PublicKey:
+			add("RS256", "RS384", "RS512", "PS256", "PS384", "PS512")
+		case *ecdsa.PublicKey:
+			// Each curve has a single algorithm
+			switch k.Curve.Params().BitSize {
+			case 256:
+				add("ES256")
+			case 384:
+				add("ES384")
+			case 521:
+				add("ES512")
+			}
+		case ed25519.PublicKey:
+			add("EdDSA")
+		}
+	}
+	return algorithms
+}
+
+// name returns the label of the key used in metrics
+func (k Key) name() string {
+	switch {
+	case k.Label != "":
+		return k.Label
+	case k.ID != "":
+		return k.ID
+	}
+	return "unnamed"
+}
+
+// expired reports whether the key is no longer used at now
+func (k Key) expired(now time.Time) bool {
+	return !k.NotAfter.IsZero() && now.After(k.NotAfter)
+}
+
+// candidates returns the unexpired keys that may have signed a token, matching its algorithm and kid header
+func (k *Keys) candidates(token *jwt.Token, now time.Time) []Key {
+	kid, _ := token.Header["kid"].(string)
+
+	all := k.Keys
+	if k.JWKS != nil {
+		all = append(slices.Clip(all), k.JWKS.Keys()...)
+	}
+
+	var keys []Key
+	for _, key := range all {
+		if key.expired(now) {
+			continue
+		}
+		if kid != "" && key.ID != "" && key.ID != kid {
+			continue
+		}
+		if key.Algorithm != "" && key.Algorithm != token.Method.Alg() {
+			continue
+		}
+		if compatible(token.Method, key.Key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// compatible reports whether a key can verify signatures of a signing method
+func compatible(method jwt.SigningMethod, key interface{}) bool {
+	switch method.(type) {
+	case *jwt.SigningMethodHMAC:
+		_, ok := key.([]byte)
+		return ok
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		_, ok := key.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		_, ok := key.(*ecdsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodEd25519:
+		_, ok := key.(ed25519.PublicKey)
+		return ok
+	}
+	return false
+}
+
+// ParsePublicKeys parses the PEM encoded public keys and certificates of data
+func ParsePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var key crypto.PublicKey
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			// Private keys must not be configured, driplet only verifies
+			return nil, fmt.Errorf("unsupported PEM block %q, expected a public key or certificate", block.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", block.Type, err)
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no PEM encoded public key found")
+	}
+	return keys, nil
+}
@@ -0,0 +1,167 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, &Claims{
+		Custom: map[string]interface{}{"user": "test"},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func encodePublicKey(t *testing.T, key crypto.PublicKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// TestAsymmetricTokens verifies client tokens signed with private keys:
+// - RS256, PS256, ES256 and EdDSA tokens are verified with the public keys
+// - Tokens signed with another key are rejected
+// - The kid header selects the key
+// - Only the allowed algorithms are accepted, HMAC tokens are rejected without a secret
+func TestAsymmetricTokens(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherECKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ec384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	keys, err := NewKeys([]Key{
+		{ID: "rsa", Key: &rsaKey.PublicKey},
+		{ID: "ec", Key: &ecKey.PublicKey},
+		{Key: edPublic},
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewValidator(nil, WithEndpointKeys("web", keys))
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"RS256", sign(t, jwt.SigningMethodRS256, rsaKey, ""), true},
+		{"PS256 with kid", sign(t, jwt.SigningMethodPS256, rsaKey, "rsa"), true},
+		{"ES256", sign(t, jwt.SigningMethodES256, ecKey, "ec"), true},
+		{"EdDSA", sign(t, jwt.SigningMethodEdDSA, edKey, ""), true},
+		{"ES256 with another key", sign(t, jwt.SigningMethodES256, otherECKey, ""), false},
+		{"ES256 with the kid of another key", sign(t, jwt.SigningMethodES256, ecKey, "rsa"), false},
+		{"HS256 without a secret", sign(t, jwt.SigningMethodHS256, []byte("secret"), ""), false},
+		{"ES384 not allowed", sign(t, jwt.SigningMethodES384, ec384Key, ""), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := v.ValidateEndpointToken(context.Background(), tt.token, "web")
+			if tt.valid && (err != nil || claims.Custom["user"] != "test") {
+				t.Errorf("expected valid token, got %v, %v", claims, err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("expected token to be rejected")
+			}
+		})
+	}
+
+	if _, err := v.ValidateEndpointToken(context.Background(), sign(t, jwt.SigningMethodRS256, rsaKey, ""), "other"); err == nil {
+		t.Error("expected token of an unknown endpoint to be rejected")
+	}
+
+	// Only the configured algorithms are allowed
+	restricted, err := NewKeys([]Key{{Key: []byte("secret")}, {Key: &rsaKey.PublicKey}}, nil, []string{"RS256"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v = NewValidator(nil, WithEndpointKeys("web", restricted))
+	if _, err := v.ValidateEndpointToken(context.Background(), sign(t, jwt.SigningMethodRS256, rsaKey, ""), "web"); err != nil {
+		t.Errorf("expected RS256 token to be valid: %v", err)
+	}
+	if _, err := v.ValidateEndpointToken(context.Background(), sign(t, jwt.SigningMethodHS256, []byte("secret"), ""), "web"); err == nil {
+		t.Error("expected HS256 token to be rejected")
+	}
+}
+
+// TestNewKeys verifies the default algorithms and rejected algorithms
+func TestNewKeys(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	keys, err := NewKeys([]Key{{Key: []byte("secret")}, {Key: &ecKey.PublicKey}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"HS256", "HS384", "HS512", "ES384"}
+	if !reflect.DeepEqual(keys.Algorithms, want) {
+		t.Errorf("got algorithms %v, want %v", keys.Algorithms, want)
+	}
+
+	for _, alg := range []string{"none", "XS256"} {
+		if _, err := NewKeys([]Key{{Key: []byte("secret")}}, nil, []string{alg}); err == nil {
+			t.Errorf("expected algorithm %s to be rejected", alg)
+		}
+	}
+}
+
+// TestParsePublicKeys verifies PEM parsing of public keys, PKCS #1 keys and certificates
+func TestParsePublicKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	template := &x509.Certificate{SerialNumber: big.NewInt(1)}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, edPublic, edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := encodePublicKey(t, &rsaKey.PublicKey)
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)})...)
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})...)
+
+	keys, err := ParsePublicKeys(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 keys, got %d", len(keys))
+	}
+	if !rsaKey.PublicKey.Equal(keys[0]) || !rsaKey.PublicKey.Equal(keys[1]) || !edPublic.Equal(keys[2]) {
+		t.Errorf("parsed keys do not match: %v", keys)
+	}
+
+	private := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	if _, err := ParsePublicKeys(private); err == nil {
+		t.Error("expected private key to be rejected")
+	}
+	if _, err := ParsePublicKeys([]byte("not a key")); err == nil {
+		t.Error("expected missing PEM block to be rejected")
+	}
+}
@@ -0,0 +1,99 @@
+package jwt
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Rules are the claim checks client tokens of an endpoint must pass in addition to exp and nbf
+type Rules struct {
+	// Issuer is the required iss claim, not checked if empty
+	Issuer string
+	// Audience must be one of the aud claim values, not checked if empty
+	Audience string
+	// Required are the top-level claims tokens must contain, e.g. exp, sub or jti
+	Required []string
+	// Leeway is the clock skew allowed for exp, nbf and iat
+	Leeway time.Duration
+}
+
+// parserOptions returns the jwt parser options enforcing the rules
+func (r Rules) parserOptions() []jwt.ParserOption {
+	var options []jwt.ParserOption
+	if r.Issuer != "" {
+		options = append(options, jwt.WithIssuer(r.Issuer))
+	}
+	if r.Audience != "" {
+		options = append(options, jwt.WithAudience(r.Audience))
+	}
+	if r.Leeway > 0 {
+		options = append(options, jwt.WithLeeway(r.Leeway))
+	}
+	for _, claim := range r.Required {
+		switch claim {
+		case "exp":
+			options = append(options, jwt.WithExpirationRequired())
+		case "iat":
+			options = append(options, jwt.WithIssuedAt())
+		}
+	}
+	return options
+}
+
+// checkRequired returns an error naming every required claim missing in the claims
+func (r Rules) checkRequired(claims *Claims) error {
+	var missing []string
+	for _, claim := range r.Required {
+		if _, ok := claims.Raw[claim]; !ok {
+			missing = append(missing, claim)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("invalid token: %w: %s", jwt.ErrTokenRequiredClaimMissing, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// describe explains a failed claim check with the values of the token and the rules
+func (r Rules) describe(err error, claims *Claims) error {
+	if claims == nil {
+		return fmt.Errorf("invalid token: %w", err)
+	}
+
+	switch {
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return fmt.Errorf("invalid token: %w: got %q, expected %q", jwt.ErrTokenInvalidIssuer, claims.Issuer, r.Issuer)
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return fmt.Errorf("invalid token: %w: got %q, expected %q", jwt.ErrTokenInvalidAudience, []string(claims.Audience), r.Audience)
+	case errors.Is(err, jwt.ErrTokenExpired) && claims.ExpiresAt != nil:
+		return fmt.Errorf("invalid token: %w: expired at %s with a leeway of %s", jwt.ErrTokenExpired, claims.ExpiresAt.UTC().Format(time.RFC3339), r.Leeway)
+	case errors.Is(err, jwt.ErrTokenNotValidYet) && claims.NotBefore != nil:
+		return fmt.Errorf("invalid token: %w: not valid before %s with a leeway of %s", jwt.ErrTokenNotValidYet, claims.NotBefore.UTC().Format(time.RFC3339), r.Leeway)
+	case errors.Is(err, jwt.ErrTokenUsedBeforeIssued) && claims.IssuedAt != nil:
+		return fmt.Errorf("invalid token: %w: issued at %s with a leeway of %s", jwt.ErrTokenUsedBeforeIssued, claims.IssuedAt.UTC().Format(time.RFC3339), r.Leeway)
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		if missing := r.missing(claims); len(missing) > 0 {
+			return fmt.Errorf("invalid token: %w: %s", jwt.ErrTokenRequiredClaimMissing, strings.Join(missing, ", "))
+		}
+	}
+	return fmt.Errorf("invalid token: %w", err)
+}
+
+// missing returns the claims checked by the parser that the rules require and the claims lack
+func (r Rules) missing(claims *Claims) []string {
+	var missing []string
+	if claims.ExpiresAt == nil && slices.Contains(r.Required, "exp") {
+		missing = append(missing, "exp")
+	}
+	if len(claims.Audience) == 0 && r.Audience != "" {
+		missing = append(missing, "aud")
+	}
+	if claims.Issuer == "" && r.Issuer != "" {
+		missing = append(missing, "iss")
+	}
+	return missing
+}
@@ -0,0 +1,93 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRules verifies the claim checks of an endpoint:
+// - Issuer and audience must match
+// - Required claims must be present, errors name every missing claim
+// - exp and nbf are checked with the leeway
+// - Errors name the failed check and the values of the token
+func TestRules(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	secret := []byte("secret")
+	keys, err := NewKeys([]Key{{Key: secret}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewValidator(nil, WithEndpointKeys("web", keys), WithEndpointRules("web", Rules{
+		Issuer:   "https://sso.example.com",
+		Audience: "driplet",
+		Required: []string{"exp", "sub", "jti"},
+		Leeway:   30 * time.Second,
+	}))
+	v.timeNow = func() time.Time { return now }
+
+	token := func(changes jwt.MapClaims) string {
+		claims := jwt.MapClaims{
+			"iss": "https://sso.example.com",
+			"aud": []string{"shop", "driplet"},
+			"sub": "42",
+			"jti": "token-1",
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		for name, value := range changes {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+		text  string
+	}{
+		{"valid", token(nil), nil, ""},
+		{"expired within leeway", token(jwt.MapClaims{"exp": now.Add(-20 * time.Second).Unix()}), nil, ""},
+		{"not before within leeway", token(jwt.MapClaims{"nbf": now.Add(20 * time.Second).Unix()}), nil, ""},
+		{"other issuer", token(jwt.MapClaims{"iss": "https://other.example.com"}), jwt.ErrTokenInvalidIssuer, `got "https://other.example.com"`},
+		{"other audience", token(jwt.MapClaims{"aud": "shop"}), jwt.ErrTokenInvalidAudience, `expected "driplet"`},
+		{"expired", token(jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()}), jwt.ErrTokenExpired, "expired at 2025-01-01T11:59:00Z with a leeway of 30s"},
+		{"not valid yet", token(jwt.MapClaims{"nbf": now.Add(time.Minute).Unix()}), jwt.ErrTokenNotValidYet, "not valid before 2025-01-01T12:01:00Z"},
+		{"missing exp", token(jwt.MapClaims{"exp": nil}), jwt.ErrTokenRequiredClaimMissing, "exp"},
+		{"missing sub", token(jwt.MapClaims{"sub": nil}), jwt.ErrTokenRequiredClaimMissing, "sub"},
+		{"missing sub and jti", token(jwt.MapClaims{"sub": nil, "jti": nil}), jwt.ErrTokenRequiredClaimMissing, "required claim: sub, jti"},
+		{"missing iss", token(jwt.MapClaims{"iss": nil}), jwt.ErrTokenRequiredClaimMissing, "required claim: iss"},
+		{"missing aud", token(jwt.MapClaims{"aud": nil}), jwt.ErrTokenRequiredClaimMissing, "required claim: aud"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.ValidateEndpointToken(context.Background(), tt.token, "web")
+			if tt.err == nil {
+				if err != nil {
+					t.Errorf("expected valid token: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) || !strings.Contains(err.Error(), tt.text) {
+				t.Errorf("expected %v error containing %q, got %v", tt.err, tt.text, err)
+			}
+		})
+	}
+
+	// Endpoints without rules only check exp and nbf
+	if _, err := v.ValidateClientToken(token(jwt.MapClaims{"iss": nil, "aud": nil, "sub": nil}), "secret"); err != nil {
+		t.Errorf("expected token without rules to be valid: %v", err)
+	}
+}
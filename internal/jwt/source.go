@@ -0,0 +1,113 @@
+package jwt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Claim sources select the part of the token payload targeting sees
+const (
+	// ClaimSourceCustom uses the custom claim
+	ClaimSourceCustom = "custom"
+	// ClaimSourcePayload uses the whole payload
+	ClaimSourcePayload = "payload"
+	// ClaimSourceNamespace uses a single top-level claim, e.g. a namespaced claim of an identity provider
+	ClaimSourceNamespace = "namespace"
+	// ClaimSourceMapping copies claims at JWT paths to attributes
+	ClaimSourceMapping = "mapping"
+)
+
+// ClaimMapping maps the claim at a dot-separated JWT path to a targeting attribute
+type ClaimMapping struct {
+	Path      string
+	Attribute string
+}
+
+// ClaimSource builds the targeting attributes of an endpoint from the token payload
+type ClaimSource struct {
+	Kind      string
+	Namespace string
+	Mapping   []ClaimMapping
+}
+
+// NewClaimSource creates a claim source, an empty kind uses the custom claim
+func NewClaimSource(kind, namespace string, mapping []ClaimMapping) (ClaimSource, error) {
+	if kind == "" {
+		kind = ClaimSourceCustom
+	}
+
+	switch kind {
+	case ClaimSourceCustom, ClaimSourcePayload:
+	case ClaimSourceNamespace:
+		if namespace == "" {
+			return ClaimSource{}, errors.New("claim source namespace requires a namespace")
+		}
+	case ClaimSourceMapping:
+		if len(mapping) == 0 {
+			return ClaimSource{}, errors.New("claim source mapping requires at least one mapping")
+		}
+		for _, m := range mapping {
+			if m.Path == "" || m.Attribute == "" {
+				return ClaimSource{}, fmt.Errorf("claim mapping %q to %q requires a path and an attribute", m.Path, m.Attribute)
+			}
+		}
+	default:
+		return ClaimSource{}, fmt.Errorf("unknown claim source %q", kind)
+	}
+	return ClaimSource{Kind: kind, Namespace: namespace, Mapping: mapping}, nil
+}
+
+// apply sets the targeting attributes of the claims
+func (s ClaimSource) apply(c *Claims) {
+	switch s.Kind {
+	case ClaimSourcePayload:
+		c.view = c.Raw
+	case ClaimSourceNamespace:
+		// A token without the namespace has no attributes, it must not fall back to the custom claim
+		view, ok := c.Raw[s.Namespace].(map[string]interface{})
+		if !ok {
+			view = map[string]interface{}{}
+		}
+		c.view = view
+	case ClaimSourceMapping:
+		c.view = make(map[string]interface{})
+		for _, m := range s.Mapping {
+			if value, ok := Lookup(c.Raw, m.Path); ok {
+				setPath(c.view, m.Attribute, value)
+			}
+		}
+	default:
+		c.view = nil
+	}
+}
+
+// Lookup returns the value at a dot-separated path of nested maps
+func Lookup(m map[string]interface{}, path string) (interface{}, bool) {
+	current := interface{}(m)
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// setPath sets the value at a dot-separated path, creating nested maps
+func setPath(m map[string]interface{}, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			m[part] = next
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = value
+}
@@ -0,0 +1,97 @@
+package jwt
+
+import (
+	"context"
+	"github.com/golang-jwt/jwt/v5"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestClaimSource verifies the targeting attributes built by each claim source:
+// - custom uses the custom claim
+// - payload uses the whole payload
+// - namespace uses a single top-level claim, tokens without it have no attributes
+// - mapping copies claims at JWT paths to attributes, nested by dotted attribute names
+func TestClaimSource(t *testing.T) {
+	secret := []byte("secret")
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"sub":    "42",
+		"tenant": "acme",
+		"custom": map[string]interface{}{"role": "custom-role"},
+		"https://example.com/claims": map[string]interface{}{
+			"role": "namespaced-role",
+		},
+		"realm_access": map[string]interface{}{
+			"roles": []string{"admin"},
+		},
+	}).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewKeys([]Key{{Key: secret}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		kind      string
+		namespace string
+		mapping   []ClaimMapping
+		path      string
+		want      interface{}
+	}{
+		{"default", "", "", nil, "role", "custom-role"},
+		{"payload", ClaimSourcePayload, "", nil, "tenant", "acme"},
+		{"payload nested", ClaimSourcePayload, "", nil, "custom.role", "custom-role"},
+		{"namespace", ClaimSourceNamespace, "https://example.com/claims", nil, "role", "namespaced-role"},
+		{"namespace missing", ClaimSourceNamespace, "https://other.example.com/claims", nil, "role", nil},
+		{"namespace not an object", ClaimSourceNamespace, "tenant", nil, "role", nil},
+		{"mapping", ClaimSourceMapping, "", []ClaimMapping{{Path: "realm_access.roles", Attribute: "roles"}}, "roles", []interface{}{"admin"}},
+		{"mapping nested attribute", ClaimSourceMapping, "", []ClaimMapping{{Path: "sub", Attribute: "user.id"}}, "user.id", "42"},
+		{"mapping only mapped", ClaimSourceMapping, "", []ClaimMapping{{Path: "sub", Attribute: "user"}}, "tenant", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := NewClaimSource(tt.kind, tt.namespace, tt.mapping)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := NewValidator(nil, WithEndpointKeys("web", keys), WithEndpointClaimSource("web", source))
+			claims, err := v.ValidateEndpointToken(context.Background(), signed, "web")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, exists := claims.GetCustomClaim(tt.path)
+			if tt.want == nil {
+				if exists {
+					t.Errorf("expected %s to be hidden, got %v", tt.path, got)
+				}
+				return
+			}
+			if !exists || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v (%v), want %v", got, exists, tt.want)
+			}
+		})
+	}
+
+	invalid := []struct {
+		kind      string
+		namespace string
+		mapping   []ClaimMapping
+	}{
+		{"unknown", "", nil},
+		{ClaimSourceNamespace, "", nil},
+		{ClaimSourceMapping, "", nil},
+		{ClaimSourceMapping, "", []ClaimMapping{{Path: "sub"}}},
+	}
+	for _, tt := range invalid {
+		if _, err := NewClaimSource(tt.kind, tt.namespace, tt.mapping); err == nil {
+			t.Errorf("expected claim source %q with %q and %v to be rejected", tt.kind, tt.namespace, tt.mapping)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+// Package longpoll implements HTTP long-polling sessions on top of the websocket hub.
+package longpoll
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/websocket"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrPollInProgress is returned when a session is already being polled
+var ErrPollInProgress = fmt.Errorf("poll already in progress")
+
+// maxBatch is the maximum number of messages returned by a single poll
+const maxBatch = 100
+
+// AckAll acknowledges every message returned by earlier polls
+const AckAll = ^uint64(0)
+
+// Session is a long-polling session registered in the hub like a websocket client
+type Session struct {
+	ID       string
+	endpoint string
+	client   *websocket.Client
+	poll     sync.Mutex
+	// pending are the returned messages not acknowledged yet, cursor is the cursor of the last one returned
+	pending []pending
+	cursor  uint64
+
+	mu       sync.Mutex
+	polling  bool
+	lastPoll time.Time
+}
+
+// pending is a returned message kept until a poll acknowledges its cursor
+type pending struct {
+	cursor uint64
+	data   json.RawMessage
+}
+
+// Result is the result of a single poll, the next poll acknowledges its messages with Cursor
+type Result struct {
+	Messages []json.RawMessage    `json:"messages"`
+	Cursor   uint64               `json:"cursor"`
+	Closed   *websocket.CloseHint `json:"closed,omitempty"`
+}
+
+// Manager holds long-polling sessions and expires idle ones
+type Manager struct {
+	logger      *slog.Logger
+	hub         *websocket.Hub
+	idleTimeout time.Duration
+	mu          sync.RWMutex
+	sessions    map[string]*Session
+}
+
+// NewManager creates a new Manager and expires sessions idle for longer than idleTimeout until ctx is done
+func NewManager(ctx context.Context, logger *slog.Logger, hub *websocket.Hub, idleTimeout time.Duration) *Manager {
+	if idleTimeout <= 0 {
+		idleTimeout = time.Minute
+	}
+
+	m := &Manager{
+		logger:      logger,
+		hub:         hub,
+		idleTimeout: idleTimeout,
+		sessions:    make(map[string]*Session),
+	}
+	go m.expire(ctx)
+	return m
+}
+
+// Create creates a new session for an authenticated client
+func (m *Manager) Create(endpoint string, claims *jwt.Claims) (*Session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		ID:       id,
+		endpoint: endpoint,
+		client:   m.hub.Attach(endpoint, claims),
+		lastPoll: time.Now(),
+	}
+
+	m.mu.Lock()
+	m.sessions[id] = s
+	m.mu.Unlock()
+
+	m.logger.Debug("Created long-polling session", "endpoint", endpoint)
+	return s, nil
+}
+
+// Get returns the session with the given id on the endpoint
+func (m *Manager) Get(endpoint, id string) (*Session, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.sessions[id]
+	if !ok || s.endpoint != endpoint {
+		return nil, false
+	}
+	return s, true
+}
+
+// Close closes a session and detaches it from the hub
+func (m *Manager) Close(s *Session) {
+	m.mu.Lock()
+	_, ok := m.sessions[s.ID]
+	delete(m.sessions, s.ID)
+	m.mu.Unlock()
+
+	if ok {
+		s.client.Detach()
+	}
+}
+
+// Poll drops the messages acknowledged by the ack cursor, waits until messages are available or the timeout passes and returns them.
+// Messages are returned again until a poll acknowledges them.
+// When the hub disconnected the session the result holds the close hint and the session is closed.
+func (m *Manager) Poll(ctx context.Context, s *Session, ack uint64, timeout time.Duration) (*Result, error) {
+	if !s.poll.TryLock() {
+		return nil, ErrPollInProgress
+	}
+	defer s.poll.Unlock()
+
+	s.touch(true)
+	defer s.touch(false)
+
+	// Unacknowledged messages are returned again without waiting
+	s.acknowledge(ack)
+	if len(s.pending) == 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		// Wait for the first message
+		select {
+		case <-ctx.Done():
+			return s.result(), nil
+		case <-timer.C:
+			return s.result(), nil
+		case delivery, ok := <-s.client.Deliveries():
+			if !ok {
+				return m.closed(s), nil
+			}
+			s.add(delivery.Data)
+		}
+	}
+
+	// Drain whatever else is already queued
+	for len(s.pending) < maxBatch {
+		select {
+		case delivery, ok := <-s.client.Deliveries():
+			if !ok {
+				return m.closed(s), nil
+			}
+			s.add(delivery.Data)
+		default:
+			return s.result(), nil
+		}
+	}
+	return s.result(), nil
+}
+
+// Subscribe subscribes the session to a topic
+func (s *Session) Subscribe(msg websocket.SubscriptionMessage) error {
+	s.touch(s.isPolling())
+	return s.client.Subscribe(msg)
+}
+
+// Unsubscribe unsubscribes the session from a topic
+func (s *Session) Unsubscribe(topic string) {
+	s.touch(s.isPolling())
+	s.client.Unsubscribe(topic)
+}
+
+// closed closes a session disconnected by the hub and returns the pending messages with the close hint
+func (m *Manager) closed(s *Session) *Result {
+	result := s.result()
+	hint := s.client.CloseReason().Hint()
+	result.Closed = &hint
+	m.Close(s)
+	return result
+}
+
+// expire periodically closes sessions that have not been polled within the idle timeout until ctx is done
+func (m *Manager) expire(ctx context.Context) {
+	ticker := time.NewTicker(m.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var expired []*Session
+		m.mu.RLock()
+		for _, s := range m.sessions {
+			if s.idleSince(time.Now()) > m.idleTimeout {
+				expired = append(expired, s)
+			}
+		}
+		m.mu.RUnlock()
+
+		for _, s := range expired {
+			m.logger.Debug("Expiring idle long-polling session", "endpoint", s.endpoint)
+			m.Close(s)
+		}
+	}
+}
+
+// add keeps a returned message pending under the next cursor, callers must hold poll
+func (s *Session) add(data json.RawMessage) {
+	s.cursor++
+	s.pending = append(s.pending, pending{cursor: s.cursor, data: data})
+}
+
+// acknowledge drops the pending messages up to the cursor, callers must hold poll
+func (s *Session) acknowledge(cursor uint64) {
+	n := 0
+	for n < len(s.pending) && s.pending[n].cursor <= cursor {
+		n++
+	}
+	s.pending = s.pending[n:]
+}
+
+// result returns the pending messages, callers must hold poll
+func (s *Session) result() *Result {
+	result := &Result{Messages: make([]json.RawMessage, 0, len(s.pending)), Cursor: s.cursor}
+	for _, p := range s.pending {
+		result.Messages = append(result.Messages, p.data)
+	}
+	return result
+}
+
+// touch records activity on the session
+func (s *Session) touch(polling bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.polling = polling
+	s.lastPoll = time.Now()
+}
+
+// isPolling reports whether a poll is in progress
+func (s *Session) isPolling() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.polling
+}
+
+// idleSince returns how long the session has been idle, zero while a poll is in progress
+func (s *Session) idleSince(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.polling {
+		return 0
+	}
+	return now.Sub(s.lastPoll)
+}
+
+// newSessionID returns a random session id
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate session id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
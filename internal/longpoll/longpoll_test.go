@@ -0,0 +1,126 @@
+package longpoll
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/websocket"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func newTestManager(t *testing.T, idleTimeout time.Duration) *Manager {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	hub := websocket.NewHub(logger)
+	go hub.Run()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return NewManager(ctx, logger, hub, idleTimeout)
+}
+
+// TestPoll verifies the long-polling flow:
+// - Polls time out with no messages
+// - Published messages on subscribed topics are returned in a batch
+// - Concurrent polls on a session are rejected
+func TestPoll(t *testing.T) {
+	m := newTestManager(t, time.Minute)
+
+	s, err := m.Create("web", &jwt.Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Subscribe(websocket.SubscriptionMessage{Topic: "news"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test 1: Timeout without messages
+	result, err := m.Poll(context.Background(), s, AckAll, 10*time.Millisecond)
+	if err != nil || len(result.Messages) != 0 {
+		t.Errorf("expected empty poll, got %v, %v", result, err)
+	}
+
+	// Test 2: Messages are returned in one batch
+	for _, body := range []string{`{"n":1}`, `{"n":2}`} {
+		err := m.hub.Broadcast(websocket.BroadcastMessage{Endpoint: "web", Topic: "news", Message: json.RawMessage(body)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err = m.Poll(context.Background(), s, AckAll, time.Second)
+	if err != nil || len(result.Messages) != 2 {
+		t.Fatalf("expected 2 messages, got %v, %v", result, err)
+	}
+
+	// Test 3: Concurrent polls are rejected
+	done := make(chan struct{})
+	go func() {
+		m.Poll(context.Background(), s, AckAll, 200*time.Millisecond)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := m.Poll(context.Background(), s, AckAll, time.Millisecond); err != ErrPollInProgress {
+		t.Errorf("expected ErrPollInProgress, got %v", err)
+	}
+	<-done
+}
+
+// TestPollAcknowledge verifies that returned messages are kept until a poll acknowledges their cursor:
+// - Messages are returned again while not acknowledged, with new messages appended
+// - Acknowledged messages are not returned again
+func TestPollAcknowledge(t *testing.T) {
+	m := newTestManager(t, time.Minute)
+	s, err := m.Create("web", &jwt.Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Subscribe(websocket.SubscriptionMessage{Topic: "news"}); err != nil {
+		t.Fatal(err)
+	}
+	publish := func(body string) {
+		if err := m.hub.Broadcast(websocket.BroadcastMessage{Endpoint: "web", Topic: "news", Message: json.RawMessage(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	publish(`{"n":1}`)
+	first, err := m.Poll(context.Background(), s, 0, time.Second)
+	if err != nil || len(first.Messages) != 1 || first.Cursor != 1 {
+		t.Fatalf("expected 1 message with cursor 1, got %+v, %v", first, err)
+	}
+
+	// The response was lost, the message is returned again with the new one
+	publish(`{"n":2}`)
+	time.Sleep(20 * time.Millisecond)
+	second, err := m.Poll(context.Background(), s, 0, time.Second)
+	if err != nil || len(second.Messages) != 2 || second.Cursor != 2 {
+		t.Fatalf("expected 2 messages with cursor 2, got %+v, %v", second, err)
+	}
+
+	third, err := m.Poll(context.Background(), s, second.Cursor, 10*time.Millisecond)
+	if err != nil || len(third.Messages) != 0 || third.Cursor != 2 {
+		t.Errorf("expected acknowledged messages to be dropped, got %+v, %v", third, err)
+	}
+}
+
+// TestSessionExpiry verifies that idle sessions are closed
+func TestSessionExpiry(t *testing.T) {
+	m := newTestManager(t, 50*time.Millisecond)
+
+	s, err := m.Create("web", &jwt.Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Get("web", s.ID); !ok {
+		t.Fatal("expected session to exist")
+	}
+	if _, ok := m.Get("other", s.ID); ok {
+		t.Error("expected session lookup on another endpoint to fail")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if _, ok := m.Get("web", s.ID); ok {
+		t.Error("expected idle session to expire")
+	}
+}
@@ -0,0 +1,161 @@
+// Package mercure implements the parts of the Mercure protocol needed to map it onto the hub:
+// topic selectors, the mercure JWT claim and update encoding.
+package mercure
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Claim is the mercure claim of subscriber and publisher JWTs
+type Claim struct {
+	Subscribe []string    `json:"subscribe,omitempty"`
+	Publish   []string    `json:"publish,omitempty"`
+	Payload   interface{} `json:"payload,omitempty"`
+}
+
+// ClaimFrom extracts the mercure claim from a JWT payload
+func ClaimFrom(payload map[string]interface{}) *Claim {
+	claim := &Claim{}
+	raw, ok := payload["mercure"]
+	if !ok {
+		return claim
+	}
+
+	// Round trip through JSON to decode the generic map into the claim
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return claim
+	}
+	json.Unmarshal(data, claim)
+	return claim
+}
+
+// Selector matches topics against a topic selector: an exact topic, a URI template or *
+type Selector struct {
+	source string
+	re     *regexp.Regexp
+}
+
+// NewSelector compiles a topic selector
+func NewSelector(source string) (*Selector, error) {
+	s := &Selector{source: source}
+	if !IsPattern(source) {
+		return s, nil
+	}
+	if source == "*" {
+		s.re = regexp.MustCompile(`^.*$`)
+		return s, nil
+	}
+
+	re, err := compileTemplate(source)
+	if err != nil {
+		return nil, err
+	}
+	s.re = re
+	return s, nil
+}
+
+// IsPattern reports whether a selector matches more than one exact topic
+func IsPattern(source string) bool {
+	return source == "*" || strings.Contains(source, "{")
+}
+
+// String returns the source of the selector
+func (s *Selector) String() string {
+	return s.source
+}
+
+// Match reports whether a topic matches the selector
+func (s *Selector) Match(topic string) bool {
+	if s.re == nil {
+		return topic == s.source
+	}
+	return s.re.MatchString(topic)
+}
+
+// Selectors is a list of selectors matching a topic if any of them does
+type Selectors []*Selector
+
+// NewSelectors compiles a list of selectors, invalid selectors are skipped
+func NewSelectors(sources []string) Selectors {
+	selectors := make(Selectors, 0, len(sources))
+	for _, source := range sources {
+		if s, err := NewSelector(source); err == nil {
+			selectors = append(selectors, s)
+		}
+	}
+	return selectors
+}
+
+// Match reports whether a topic matches any of the selectors
+func (s Selectors) Match(topic string) bool {
+	for _, selector := range s {
+		if selector.Match(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileTemplate converts an RFC 6570 URI template into a regular expression matching its expansions
+func compileTemplate(template string) (*regexp.Regexp, error) {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+
+	rest := template
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			pattern.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated expression in URI template %q", template)
+		}
+		end += start
+
+		pattern.WriteString(regexp.QuoteMeta(rest[:start]))
+		expression := rest[start+1 : end]
+		if expression == "" {
+			return nil, fmt.Errorf("empty expression in URI template %q", template)
+		}
+
+		// The operator determines which characters an expansion may contain
+		switch expression[0] {
+		case '+':
+			pattern.WriteString(`.*`)
+		case '#':
+			pattern.WriteString(`(?:#.*)?`)
+		case '.':
+			pattern.WriteString(`(?:\.[^/?#]*)*`)
+		case '/':
+			pattern.WriteString(`(?:/[^/?#]*)*`)
+		case ';':
+			pattern.WriteString(`(?:;[^/?#]*)*`)
+		case '?':
+			pattern.WriteString(`(?:\?[^#]*)?`)
+		case '&':
+			pattern.WriteString(`(?:&[^#]*)?`)
+		default:
+			pattern.WriteString(`[^/?#]*`)
+		}
+		rest = rest[end+1:]
+	}
+
+	pattern.WriteString("$")
+	return regexp.Compile(pattern.String())
+}
+
+// NewUpdateID returns a random urn:uuid update id
+func NewUpdateID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
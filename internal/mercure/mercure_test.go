@@ -0,0 +1,67 @@
+package mercure
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+)
+
+// TestSelector verifies topic selector matching:
+// - Exact topics and the * wildcard
+// - Simple and reserved URI template expansions
+func TestSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		topic    string
+		want     bool
+	}{
+		{"https://example.com/books/1", "https://example.com/books/1", true},
+		{"https://example.com/books/1", "https://example.com/books/2", false},
+		{"*", "anything", true},
+		{"https://example.com/books/{id}", "https://example.com/books/42", true},
+		{"https://example.com/books/{id}", "https://example.com/books/42/reviews", false},
+		{"https://example.com/{+path}", "https://example.com/books/42/reviews", true},
+		{"https://example.com/books{?page}", "https://example.com/books?page=2", true},
+		{"https://example.com/books{?page}", "https://example.com/books", true},
+		{"books.{id}", "booksX1", false},
+	}
+
+	for _, tt := range tests {
+		s, err := NewSelector(tt.selector)
+		if err != nil {
+			t.Fatalf("selector %q: %v", tt.selector, err)
+		}
+		if got := s.Match(tt.topic); got != tt.want {
+			t.Errorf("selector %q topic %q: got %v, want %v", tt.selector, tt.topic, got, tt.want)
+		}
+	}
+
+	if _, err := NewSelector("https://example.com/{id"); err == nil {
+		t.Error("expected unterminated template to fail")
+	}
+}
+
+// TestClaimFrom verifies extraction of the mercure claim from a JWT payload
+func TestClaimFrom(t *testing.T) {
+	var payload map[string]interface{}
+	json.Unmarshal([]byte(`{"sub":"1","mercure":{"subscribe":["https://example.com/users/1/{+any}"],"publish":["*"]}}`), &payload)
+
+	claim := ClaimFrom(payload)
+	if len(claim.Subscribe) != 1 || len(claim.Publish) != 1 || claim.Publish[0] != "*" {
+		t.Errorf("unexpected claim %+v", claim)
+	}
+	if !NewSelectors(claim.Subscribe).Match("https://example.com/users/1/orders/5") {
+		t.Error("expected subscribe selector to match")
+	}
+
+	if claim := ClaimFrom(map[string]interface{}{}); len(claim.Subscribe) != 0 {
+		t.Errorf("expected empty claim, got %+v", claim)
+	}
+}
+
+// TestUpdateID verifies that update ids are random urn:uuid ids
+func TestUpdateID(t *testing.T) {
+	if id := NewUpdateID(); !regexp.MustCompile(`^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(id) {
+		t.Errorf("invalid update id %q", id)
+	}
+}
@@ -0,0 +1,387 @@
+// Package metrics provides a minimal metrics registry exposed in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are the default histogram buckets (in seconds)
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metric is implemented by all metric families
+type metric interface {
+	describe() *desc
+	write(w io.Writer)
+}
+
+// desc describes a metric family
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+// Registry holds registered metric families
+type Registry struct {
+	mu      sync.RWMutex
+	metrics map[string]metric
+}
+
+// NewRegistry creates a new Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make(map[string]metric),
+	}
+}
+
+// register adds a metric family or returns an already registered one of the same name
+func (r *Registry) register(m metric) metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d := m.describe()
+	if existing, ok := r.metrics[d.name]; ok {
+		if existing.describe().kind != d.kind {
+			panic(fmt.Sprintf("metric %s already registered as %s", d.name, existing.describe().kind))
+		}
+		return existing
+	}
+	r.metrics[d.name] = m
+	return m
+}
+
+// NewCounterVec registers a counter family with the given label names
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{name: name, help: help, kind: "counter", labels: labels},
+		values: make(map[string]*Counter),
+	}
+	return r.register(c).(*CounterVec)
+}
+
+// NewGaugeVec registers a gauge family with the given label names
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{
+		desc:   desc{name: name, help: help, kind: "gauge", labels: labels},
+		values: make(map[string]*Gauge),
+	}
+	return r.register(g).(*GaugeVec)
+}
+
+// NewHistogramVec registers a histogram family with the given buckets and label names
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: sorted,
+		values:  make(map[string]*Histogram),
+	}
+	return r.register(h).(*HistogramVec)
+}
+
+// Write writes all metrics in the Prometheus text format
+func (r *Registry) Write(w io.Writer) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.mu.RUnlock()
+
+	for _, m := range metrics {
+		d := m.describe()
+		fmt.Fprintf(w, "# HELP %s %s\n", d.name, d.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+		m.write(w)
+	}
+}
+
+// Handler returns an http.Handler serving the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// CounterVec is a family of counters partitioned by labels
+type CounterVec struct {
+	desc   desc
+	mu     sync.RWMutex
+	values map[string]*Counter
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	labels []string
+	bits   atomic.Uint64
+}
+
+// With returns the counter for the given label values, creating it if needed
+func (c *CounterVec) With(values ...string) *Counter {
+	key := labelKey(c.desc.labels, values)
+
+	c.mu.RLock()
+	counter, ok := c.values[key]
+	c.mu.RUnlock()
+	if ok {
+		return counter
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if counter, ok = c.values[key]; !ok {
+		counter = &Counter{labels: values}
+		c.values[key] = counter
+	}
+	return counter
+}
+
+func (c *CounterVec) describe() *desc { return &c.desc }
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, key := range sortedKeys(c.values) {
+		counter := c.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.desc.name, formatLabels(c.desc.labels, counter.labels), formatValue(counter.Value()))
+	}
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds the given non-negative value to the counter
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	addFloat(&c.bits, v)
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// GaugeVec is a family of gauges partitioned by labels
+type GaugeVec struct {
+	desc   desc
+	mu     sync.RWMutex
+	values map[string]*Gauge
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	labels []string
+	bits   atomic.Uint64
+}
+
+// With returns the gauge for the given label values, creating it if needed
+func (g *GaugeVec) With(values ...string) *Gauge {
+	key := labelKey(g.desc.labels, values)
+
+	g.mu.RLock()
+	gauge, ok := g.values[key]
+	g.mu.RUnlock()
+	if ok {
+		return gauge
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if gauge, ok = g.values[key]; !ok {
+		gauge = &Gauge{labels: values}
+		g.values[key] = gauge
+	}
+	return gauge
+}
+
+func (g *GaugeVec) describe() *desc { return &g.desc }
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, key := range sortedKeys(g.values) {
+		gauge := g.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", g.desc.name, formatLabels(g.desc.labels, gauge.labels), formatValue(gauge.Value()))
+	}
+}
+
+// Set sets the gauge to the given value
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+// Add adds the given value to the gauge
+func (g *Gauge) Add(v float64) {
+	addFloat(&g.bits, v)
+}
+
+// Inc increments the gauge by one
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+// Dec decrements the gauge by one
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// Value returns the current value of the gauge
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+// HistogramVec is a family of histograms partitioned by labels
+type HistogramVec struct {
+	desc    desc
+	buckets []float64
+	mu      sync.RWMutex
+	values  map[string]*Histogram
+}
+
+// Histogram counts observations in configurable buckets
+type Histogram struct {
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+// With returns the histogram for the given label values, creating it if needed
+func (h *HistogramVec) With(values ...string) *Histogram {
+	key := labelKey(h.desc.labels, values)
+
+	h.mu.RLock()
+	hist, ok := h.values[key]
+	h.mu.RUnlock()
+	if ok {
+		return hist
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hist, ok = h.values[key]; !ok {
+		hist = &Histogram{
+			labels:  values,
+			buckets: h.buckets,
+			counts:  make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = hist
+	}
+	return hist
+}
+
+func (h *HistogramVec) describe() *desc { return &h.desc }
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	labelNames := append(append([]string(nil), h.desc.labels...), "le")
+	for _, key := range sortedKeys(h.values) {
+		hist := h.values[key]
+		hist.mu.Lock()
+		var cumulative uint64
+		for i, bound := range hist.buckets {
+			cumulative += hist.counts[i]
+			labels := append(append([]string(nil), hist.labels...), formatValue(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.desc.name, formatLabels(labelNames, labels), cumulative)
+		}
+		labels := append(append([]string(nil), hist.labels...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.desc.name, formatLabels(labelNames, labels), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.desc.name, formatLabels(h.desc.labels, hist.labels), formatValue(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.desc.name, formatLabels(h.desc.labels, hist.labels), hist.count)
+		hist.mu.Unlock()
+	}
+}
+
+// Observe records a single observation
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// Helper functions
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + v)
+		if bits.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+func labelKey(names, values []string) string {
+	if len(names) != len(values) {
+		panic(fmt.Sprintf("expected %d label values, got %d", len(names), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Quote(values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
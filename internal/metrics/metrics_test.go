@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestRegistryWrite verifies the text exposition output:
+// - Counters and gauges with labels
+// - Cumulative histogram buckets with sum and count
+// - Re-registering a name returns the existing family
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+
+	counter := r.NewCounterVec("test_total", "A test counter.", "endpoint")
+	counter.With("web").Add(3)
+	counter.With("web").Inc()
+	if again := r.NewCounterVec("test_total", "A test counter.", "endpoint"); again != counter {
+		t.Error("expected re-registration to return the existing counter")
+	}
+
+	gauge := r.NewGaugeVec("test_gauge", "A test gauge.")
+	gauge.With().Set(2)
+	gauge.With().Dec()
+
+	hist := r.NewHistogramVec("test_seconds", "A test histogram.", []float64{1, 0.1}, "endpoint")
+	hist.With("web").Observe(0.05)
+	hist.With("web").Observe(0.5)
+	hist.With("web").Observe(5)
+
+	var buf bytes.Buffer
+	r.Write(&buf)
+	out := buf.String()
+
+	expected := []string{
+		"# TYPE test_total counter",
+		`test_total{endpoint="web"} 4`,
+		"test_gauge 1",
+		`test_seconds_bucket{endpoint="web",le="0.1"} 1`,
+		`test_seconds_bucket{endpoint="web",le="1"} 2`,
+		`test_seconds_bucket{endpoint="web",le="+Inf"} 3`,
+		`test_seconds_sum{endpoint="web"} 5.55`,
+		`test_seconds_count{endpoint="web"} 3`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("expected output to contain %q, got:\n%s", line, out)
+		}
+	}
+}
+
+// TestRegisterKindMismatch verifies registering a name with a different kind panics
+func TestRegisterKindMismatch(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_metric", "A counter.")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on kind mismatch")
+		}
+	}()
+	r.NewGaugeVec("test_metric", "A gauge.")
+}
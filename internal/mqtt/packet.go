@@ -0,0 +1,500 @@
+// Package mqtt implements an MQTT 3.1.1 broker over WebSocket that maps MQTT topics onto hub topics.
+package mqtt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Packet types
+const (
+	TypeConnect     = 1
+	TypeConnack     = 2
+	TypePublish     = 3
+	TypePuback      = 4
+	TypeSubscribe   = 8
+	TypeSuback      = 9
+	TypeUnsubscribe = 10
+	TypeUnsuback    = 11
+	TypePingreq     = 12
+	TypePingresp    = 13
+	TypeDisconnect  = 14
+)
+
+// CONNACK return codes
+const (
+	ConnAccepted              = 0
+	ConnUnacceptableProtocol  = 1
+	ConnIdentifierRejected    = 2
+	ConnBadUsernameOrPassword = 4
+	ConnNotAuthorized         = 5
+)
+
+// SubackFailure is the SUBACK return code of a rejected subscription
+const SubackFailure byte = 0x80
+
+// ErrMalformed is returned for packets violating the MQTT 3.1.1 format
+var ErrMalformed = errors.New("malformed packet")
+
+// Packet is an MQTT control packet
+type Packet interface {
+	Encode() []byte
+}
+
+// Connect is a CONNECT packet
+type Connect struct {
+	ProtocolName  string
+	ProtocolLevel byte
+	CleanSession  bool
+	KeepAlive     uint16
+	ClientID      string
+	Will          *Publish
+	Username      *string
+	Password      []byte
+}
+
+// Connack is a CONNACK packet
+type Connack struct {
+	SessionPresent bool
+	ReturnCode     byte
+}
+
+// Publish is a PUBLISH packet
+type Publish struct {
+	Dup      bool
+	QoS      byte
+	Retain   bool
+	Topic    string
+	PacketID uint16
+	Payload  []byte
+}
+
+// Puback is a PUBACK packet
+type Puback struct {
+	PacketID uint16
+}
+
+// Subscription is a topic filter with its requested or granted QoS
+type Subscription struct {
+	Filter string
+	QoS    byte
+}
+
+// Subscribe is a SUBSCRIBE packet
+type Subscribe struct {
+	PacketID      uint16
+	Subscriptions []Subscription
+}
+
+// Suback is a SUBACK packet
+type Suback struct {
+	PacketID    uint16
+	ReturnCodes []byte
+}
+
+// Unsubscribe is an UNSUBSCRIBE packet
+type Unsubscribe struct {
+	PacketID uint16
+	Filters  []string
+}
+
+// Unsuback is an UNSUBACK packet
+type Unsuback struct {
+	PacketID uint16
+}
+
+// Pingreq is a PINGREQ packet
+type Pingreq struct{}
+
+// Pingresp is a PINGRESP packet
+type Pingresp struct{}
+
+// Disconnect is a DISCONNECT packet
+type Disconnect struct{}
+
+// ReadPacket reads a single control packet, packets with a remaining length above maxSize are rejected
+func ReadPacket(r io.Reader, maxSize int) (Packet, error) {
+	var header [1]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	length, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxSize {
+		return nil, fmt.Errorf("packet of %d bytes exceeds the maximum of %d", length, maxSize)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return decode(header[0]>>4, header[0]&0x0f, body)
+}
+
+// readLength reads the variable length encoded remaining length
+func readLength(r io.Reader) (int, error) {
+	var b [1]byte
+	length, multiplier := 0, 1
+	for i := 0; i < 4; i++ {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		length += int(b[0]&0x7f) * multiplier
+		if b[0]&0x80 == 0 {
+			return length, nil
+		}
+		multiplier *= 128
+	}
+	return 0, fmt.Errorf("%w: remaining length", ErrMalformed)
+}
+
+// decode decodes the body of a packet
+func decode(packetType, flags byte, body []byte) (Packet, error) {
+	d := &decoder{buf: body}
+
+	switch packetType {
+	case TypeConnect:
+		return decodeConnect(d, flags)
+
+	case TypeConnack:
+		if len(body) != 2 {
+			return nil, fmt.Errorf("%w: CONNACK length", ErrMalformed)
+		}
+		return &Connack{SessionPresent: body[0]&0x01 != 0, ReturnCode: body[1]}, nil
+
+	case TypePublish:
+		p := &Publish{
+			Dup:    flags&0x08 != 0,
+			QoS:    (flags >> 1) & 0x03,
+			Retain: flags&0x01 != 0,
+		}
+		if p.QoS > 2 {
+			return nil, fmt.Errorf("%w: PUBLISH QoS", ErrMalformed)
+		}
+		p.Topic = d.string()
+		if p.QoS > 0 {
+			p.PacketID = d.uint16()
+		}
+		p.Payload = d.rest()
+		return p, d.err
+
+	case TypePuback:
+		p := &Puback{PacketID: d.uint16()}
+		return p, d.done()
+
+	case TypeSubscribe:
+		if flags != 0x02 {
+			return nil, fmt.Errorf("%w: SUBSCRIBE flags", ErrMalformed)
+		}
+		p := &Subscribe{PacketID: d.uint16()}
+		for d.err == nil && d.remaining() > 0 {
+			p.Subscriptions = append(p.Subscriptions, Subscription{Filter: d.string(), QoS: d.byte()})
+		}
+		if d.err == nil && len(p.Subscriptions) == 0 {
+			return nil, fmt.Errorf("%w: SUBSCRIBE without topic filters", ErrMalformed)
+		}
+		return p, d.err
+
+	case TypeSuback:
+		p := &Suback{PacketID: d.uint16()}
+		p.ReturnCodes = d.rest()
+		return p, d.err
+
+	case TypeUnsubscribe:
+		if flags != 0x02 {
+			return nil, fmt.Errorf("%w: UNSUBSCRIBE flags", ErrMalformed)
+		}
+		p := &Unsubscribe{PacketID: d.uint16()}
+		for d.err == nil && d.remaining() > 0 {
+			p.Filters = append(p.Filters, d.string())
+		}
+		if d.err == nil && len(p.Filters) == 0 {
+			return nil, fmt.Errorf("%w: UNSUBSCRIBE without topic filters", ErrMalformed)
+		}
+		return p, d.err
+
+	case TypeUnsuback:
+		p := &Unsuback{PacketID: d.uint16()}
+		return p, d.done()
+
+	case TypePingreq:
+		return &Pingreq{}, d.done()
+
+	case TypePingresp:
+		return &Pingresp{}, d.done()
+
+	case TypeDisconnect:
+		return &Disconnect{}, d.done()
+	}
+
+	return nil, fmt.Errorf("unsupported packet type %d", packetType)
+}
+
+// decodeConnect decodes the body of a CONNECT packet
+func decodeConnect(d *decoder, flags byte) (Packet, error) {
+	if flags != 0 {
+		return nil, fmt.Errorf("%w: CONNECT flags", ErrMalformed)
+	}
+
+	p := &Connect{
+		ProtocolName:  d.string(),
+		ProtocolLevel: d.byte(),
+	}
+	connectFlags := d.byte()
+	p.KeepAlive = d.uint16()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if connectFlags&0x01 != 0 {
+		return nil, fmt.Errorf("%w: reserved CONNECT flag", ErrMalformed)
+	}
+	p.CleanSession = connectFlags&0x02 != 0
+
+	p.ClientID = d.string()
+	if connectFlags&0x04 != 0 {
+		p.Will = &Publish{
+			QoS:    (connectFlags >> 3) & 0x03,
+			Retain: connectFlags&0x20 != 0,
+			Topic:  d.string(),
+		}
+		p.Will.Payload = d.bytes()
+	}
+	if connectFlags&0x80 != 0 {
+		username := d.string()
+		p.Username = &username
+	}
+	if connectFlags&0x40 != 0 {
+		p.Password = d.bytes()
+	}
+	return p, d.done()
+}
+
+// Encode encodes the CONNECT packet
+func (p *Connect) Encode() []byte {
+	e := &encoder{}
+	e.string(p.ProtocolName)
+	e.byte(p.ProtocolLevel)
+
+	var flags byte
+	if p.CleanSession {
+		flags |= 0x02
+	}
+	if p.Will != nil {
+		flags |= 0x04 | p.Will.QoS<<3
+		if p.Will.Retain {
+			flags |= 0x20
+		}
+	}
+	if p.Password != nil {
+		flags |= 0x40
+	}
+	if p.Username != nil {
+		flags |= 0x80
+	}
+	e.byte(flags)
+	e.uint16(p.KeepAlive)
+
+	e.string(p.ClientID)
+	if p.Will != nil {
+		e.string(p.Will.Topic)
+		e.bytes(p.Will.Payload)
+	}
+	if p.Username != nil {
+		e.string(*p.Username)
+	}
+	if p.Password != nil {
+		e.bytes(p.Password)
+	}
+	return e.packet(TypeConnect<<4, 0)
+}
+
+// Encode encodes the CONNACK packet
+func (p *Connack) Encode() []byte {
+	var session byte
+	if p.SessionPresent {
+		session = 1
+	}
+	return []byte{TypeConnack << 4, 2, session, p.ReturnCode}
+}
+
+// Encode encodes the PUBLISH packet
+func (p *Publish) Encode() []byte {
+	flags := p.QoS << 1
+	if p.Dup {
+		flags |= 0x08
+	}
+	if p.Retain {
+		flags |= 0x01
+	}
+
+	e := &encoder{}
+	e.string(p.Topic)
+	if p.QoS > 0 {
+		e.uint16(p.PacketID)
+	}
+	e.buf = append(e.buf, p.Payload...)
+	return e.packet(TypePublish<<4, flags)
+}
+
+// Encode encodes the PUBACK packet
+func (p *Puback) Encode() []byte {
+	return []byte{TypePuback << 4, 2, byte(p.PacketID >> 8), byte(p.PacketID)}
+}
+
+// Encode encodes the SUBSCRIBE packet
+func (p *Subscribe) Encode() []byte {
+	e := &encoder{}
+	e.uint16(p.PacketID)
+	for _, s := range p.Subscriptions {
+		e.string(s.Filter)
+		e.byte(s.QoS)
+	}
+	return e.packet(TypeSubscribe<<4, 0x02)
+}
+
+// Encode encodes the SUBACK packet
+func (p *Suback) Encode() []byte {
+	e := &encoder{}
+	e.uint16(p.PacketID)
+	e.buf = append(e.buf, p.ReturnCodes...)
+	return e.packet(TypeSuback<<4, 0)
+}
+
+// Encode encodes the UNSUBSCRIBE packet
+func (p *Unsubscribe) Encode() []byte {
+	e := &encoder{}
+	e.uint16(p.PacketID)
+	for _, filter := range p.Filters {
+		e.string(filter)
+	}
+	return e.packet(TypeUnsubscribe<<4, 0x02)
+}
+
+// Encode encodes the UNSUBACK packet
+func (p *Unsuback) Encode() []byte {
+	return []byte{TypeUnsuback << 4, 2, byte(p.PacketID >> 8), byte(p.PacketID)}
+}
+
+// Encode encodes the PINGREQ packet
+func (p *Pingreq) Encode() []byte {
+	return []byte{TypePingreq << 4, 0}
+}
+
+// Encode encodes the PINGRESP packet
+func (p *Pingresp) Encode() []byte {
+	return []byte{TypePingresp << 4, 0}
+}
+
+// Encode encodes the DISCONNECT packet
+func (p *Disconnect) Encode() []byte {
+	return []byte{TypeDisconnect << 4, 0}
+}
+
+// decoder reads fields from a packet body, the first error sticks
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) remaining() int {
+	return len(d.buf)
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.buf) < n {
+		d.err = fmt.Errorf("%w: unexpected end of packet", ErrMalformed)
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint16()
+	b := d.take(int(n))
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) rest() []byte {
+	b := d.buf
+	d.buf = nil
+	return b
+}
+
+// done returns the decode error, trailing bytes are malformed
+func (d *decoder) done() error {
+	if d.err == nil && len(d.buf) > 0 {
+		d.err = fmt.Errorf("%w: unexpected trailing bytes", ErrMalformed)
+	}
+	return d.err
+}
+
+// encoder builds a packet body
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) byte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.buf = binary.BigEndian.AppendUint16(e.buf, v)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint16(uint16(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) string(s string) {
+	e.bytes([]byte(s))
+}
+
+// packet prepends the fixed header to the body
+func (e *encoder) packet(packetType, flags byte) []byte {
+	out := []byte{packetType | flags}
+	length := len(e.buf)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(out, e.buf...)
+}
@@ -0,0 +1,182 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// packet decodes a recorded packet written as space separated hex bytes
+func packet(t *testing.T, recorded string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(recorded, " ", ""))
+	if err != nil {
+		t.Fatalf("invalid recorded packet: %v", err)
+	}
+	return b
+}
+
+func TestPacketConformance(t *testing.T) {
+	username := "user"
+
+	tests := []struct {
+		name     string
+		recorded string
+		packet   Packet
+	}{
+		{
+			name:     "CONNECT with username and password",
+			recorded: "10 1c 00 04 4d 51 54 54 04 c2 00 3c 00 04 74 65 73 74 00 04 75 73 65 72 00 04 70 61 73 73",
+			packet: &Connect{
+				ProtocolName:  "MQTT",
+				ProtocolLevel: 4,
+				CleanSession:  true,
+				KeepAlive:     60,
+				ClientID:      "test",
+				Username:      &username,
+				Password:      []byte("pass"),
+			},
+		},
+		{
+			name:     "CONNECT with retained QoS 1 will",
+			recorded: "10 18 00 04 4d 51 54 54 04 2e 00 0a 00 02 63 31 00 03 77 2f 74 00 03 62 79 65",
+			packet: &Connect{
+				ProtocolName:  "MQTT",
+				ProtocolLevel: 4,
+				CleanSession:  true,
+				KeepAlive:     10,
+				ClientID:      "c1",
+				Will:          &Publish{QoS: 1, Retain: true, Topic: "w/t", Payload: []byte("bye")},
+			},
+		},
+		{
+			name:     "CONNACK accepted",
+			recorded: "20 02 00 00",
+			packet:   &Connack{ReturnCode: ConnAccepted},
+		},
+		{
+			name:     "CONNACK not authorized",
+			recorded: "20 02 00 05",
+			packet:   &Connack{ReturnCode: ConnNotAuthorized},
+		},
+		{
+			name:     "PUBLISH QoS 0",
+			recorded: "30 0b 00 03 61 2f 62 68 65 6c 6c 6f 21",
+			packet:   &Publish{Topic: "a/b", Payload: []byte("hello!")},
+		},
+		{
+			name:     "PUBLISH QoS 1 retained",
+			recorded: "33 0a 00 03 61 2f 62 00 01 68 69 21",
+			packet:   &Publish{QoS: 1, Retain: true, Topic: "a/b", PacketID: 1, Payload: []byte("hi!")},
+		},
+		{
+			name:     "PUBACK",
+			recorded: "40 02 00 01",
+			packet:   &Puback{PacketID: 1},
+		},
+		{
+			name:     "SUBSCRIBE with wildcards",
+			recorded: "82 0c 00 01 00 03 61 2f 2b 01 00 01 23 00",
+			packet: &Subscribe{PacketID: 1, Subscriptions: []Subscription{
+				{Filter: "a/+", QoS: 1},
+				{Filter: "#", QoS: 0},
+			}},
+		},
+		{
+			name:     "SUBACK with failure",
+			recorded: "90 05 00 01 01 00 80",
+			packet:   &Suback{PacketID: 1, ReturnCodes: []byte{1, 0, SubackFailure}},
+		},
+		{
+			name:     "UNSUBSCRIBE",
+			recorded: "a2 07 00 02 00 03 61 2f 2b",
+			packet:   &Unsubscribe{PacketID: 2, Filters: []string{"a/+"}},
+		},
+		{
+			name:     "UNSUBACK",
+			recorded: "b0 02 00 02",
+			packet:   &Unsuback{PacketID: 2},
+		},
+		{
+			name:     "PINGREQ",
+			recorded: "c0 00",
+			packet:   &Pingreq{},
+		},
+		{
+			name:     "PINGRESP",
+			recorded: "d0 00",
+			packet:   &Pingresp{},
+		},
+		{
+			name:     "DISCONNECT",
+			recorded: "e0 00",
+			packet:   &Disconnect{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorded := packet(t, tt.recorded)
+
+			decoded, err := ReadPacket(bytes.NewReader(recorded), MaxPacketSize)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, tt.packet) {
+				t.Errorf("expected %+v, got %+v", tt.packet, decoded)
+			}
+
+			if encoded := tt.packet.Encode(); !bytes.Equal(encoded, recorded) {
+				t.Errorf("expected % x, got % x", recorded, encoded)
+			}
+		})
+	}
+}
+
+func TestRemainingLength(t *testing.T) {
+	publish := &Publish{Topic: "a/b", Payload: bytes.Repeat([]byte("x"), 200)}
+	encoded := publish.Encode()
+
+	// 205 bytes remaining length is encoded as cd 01
+	if encoded[1] != 0xcd || encoded[2] != 0x01 {
+		t.Errorf("expected remaining length cd 01, got % x", encoded[1:3])
+	}
+
+	decoded, err := ReadPacket(bytes.NewReader(encoded), MaxPacketSize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, publish) {
+		t.Error("expected packet to round trip")
+	}
+
+	if _, err := ReadPacket(bytes.NewReader(encoded), 100); err == nil {
+		t.Error("expected error for packet above the maximum size")
+	}
+}
+
+func TestMalformedPackets(t *testing.T) {
+	tests := []struct {
+		name     string
+		recorded string
+	}{
+		{"SUBSCRIBE with reserved flags", "80 0c 00 01 00 03 61 2f 2b 01 00 01 23 00"},
+		{"SUBSCRIBE without filters", "82 02 00 01"},
+		{"CONNECT with reserved flag", "10 10 00 04 4d 51 54 54 04 03 00 3c 00 04 74 65 73 74"},
+		{"truncated string", "30 05 00 09 61 2f 62"},
+		{"PINGREQ with body", "c0 01 00"},
+		{"remaining length over four bytes", "30 ff ff ff ff 7f"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadPacket(bytes.NewReader(packet(t, tt.recorded)), MaxPacketSize)
+			if !errors.Is(err, ErrMalformed) {
+				t.Errorf("expected malformed packet error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,406 @@
+package mqtt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	gorilla "github.com/gorilla/websocket"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/websocket"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// Subprotocol is the WebSocket subprotocol of MQTT connections
+	Subprotocol = "mqtt"
+	// MaxPacketSize is the maximum remaining length of a packet sent by a client
+	MaxPacketSize = 256 * 1024
+	// connectTimeout is the time allowed to send CONNECT after the connection is opened
+	connectTimeout = 10 * time.Second
+	// writeWait is the time allowed to write a packet to the client
+	writeWait = 10 * time.Second
+)
+
+// Authenticator validates the JWT passed as CONNECT password
+type Authenticator func(token string) (*jwt.Claims, error)
+
+// Server runs MQTT connections on top of the hub
+type Server struct {
+	logger   *slog.Logger
+	hub      *websocket.Hub
+	mu       sync.Mutex
+	sessions map[string]*conn
+}
+
+// conn is a single MQTT connection
+type conn struct {
+	server   *Server
+	endpoint string
+	ws       *gorilla.Conn
+	clientID string
+	client   *websocket.Client
+	// publish holds the topic filters the client may publish to
+	publish []string
+	will    *Publish
+	out     *websocket.Outbox
+	mu      sync.RWMutex
+	// subs maps subscribed topic filters to their granted QoS
+	subs     map[string]byte
+	packetID uint16
+}
+
+// NewServer creates a new MQTT server
+func NewServer(logger *slog.Logger, hub *websocket.Hub) *Server {
+	return &Server{
+		logger:   logger,
+		hub:      hub,
+		sessions: make(map[string]*conn),
+	}
+}
+
+// Serve runs an MQTT connection until it is closed
+func (s *Server) Serve(ws *gorilla.Conn, endpoint string, authenticate Authenticator) {
+	defer ws.Close()
+
+	stream := websocket.NewStreamReader(ws, gorilla.BinaryMessage)
+	ws.SetReadDeadline(time.Now().Add(connectTimeout))
+	packet, err := ReadPacket(stream, MaxPacketSize)
+	if err != nil {
+		s.logger.Debug("Could not read MQTT CONNECT", "endpoint", endpoint, "error", err)
+		return
+	}
+	connect, ok := packet.(*Connect)
+	if !ok {
+		s.logger.Debug("First MQTT packet is not CONNECT", "endpoint", endpoint)
+		return
+	}
+
+	c, code := s.connect(ws, endpoint, connect, authenticate)
+	s.writeRaw(ws, (&Connack{ReturnCode: code}).Encode())
+	if c == nil {
+		return
+	}
+	defer s.disconnect(c)
+
+	// Disconnect a previous connection with the same client id
+	s.mu.Lock()
+	key := endpoint + "\x00" + c.clientID
+	if previous, ok := s.sessions[key]; ok {
+		previous.out.Stop()
+	}
+	s.sessions[key] = c
+	s.mu.Unlock()
+
+	keepAlive := time.Duration(connect.KeepAlive) * time.Second * 3 / 2
+	if keepAlive == 0 {
+		keepAlive = 2 * s.hub.PingInterval()
+	}
+
+	go c.writePump()
+	c.readPump(stream, keepAlive)
+}
+
+// connect validates a CONNECT packet and attaches the client, returning the CONNACK return code
+func (s *Server) connect(ws *gorilla.Conn, endpoint string, connect *Connect, authenticate Authenticator) (*conn, byte) {
+	if connect.ProtocolName != "MQTT" || connect.ProtocolLevel != 4 {
+		return nil, ConnUnacceptableProtocol
+	}
+
+	clientID := connect.ClientID
+	if clientID == "" {
+		if !connect.CleanSession {
+			return nil, ConnIdentifierRejected
+		}
+		clientID = newClientID()
+	}
+
+	if connect.Password == nil {
+		s.logger.Debug("Missing MQTT password token", "endpoint", endpoint, "client_id", clientID)
+		return nil, ConnNotAuthorized
+	}
+	claims, err := authenticate(string(connect.Password))
+	if err != nil {
+		s.logger.Debug("Invalid token", "endpoint", endpoint, "client_id", clientID, "error", err)
+		return nil, ConnBadUsernameOrPassword
+	}
+
+	c := &conn{
+		server:   s,
+		endpoint: endpoint,
+		ws:       ws,
+		clientID: clientID,
+		publish:  PublishFilters(claims.Raw),
+		out:      websocket.NewOutbox(64),
+		subs:     make(map[string]byte),
+	}
+
+	// The will message is published like any other message, so the client must be allowed to publish it
+	if will := connect.Will; will != nil {
+		if will.QoS > 1 || !ValidTopic(will.Topic) || !c.mayPublish(will.Topic) {
+			s.logger.Debug("MQTT will not allowed", "endpoint", endpoint, "client_id", clientID, "topic", will.Topic)
+			return nil, ConnNotAuthorized
+		}
+		c.will = will
+	}
+
+	c.client = s.hub.Attach(endpoint, claims, websocket.WithID(clientID))
+	return c, ConnAccepted
+}
+
+// disconnect publishes the will of a connection closed without DISCONNECT and detaches the client
+func (s *Server) disconnect(c *conn) {
+	c.out.Stop()
+
+	s.mu.Lock()
+	key := c.endpoint + "\x00" + c.clientID
+	if s.sessions[key] == c {
+		delete(s.sessions, key)
+	}
+	s.mu.Unlock()
+
+	if c.will != nil {
+		if err := c.broadcast(c.will); err != nil {
+			s.logger.Debug("Could not publish MQTT will", "endpoint", c.endpoint, "client_id", c.clientID, "error", err)
+		}
+	}
+	c.client.Detach()
+}
+
+// writeRaw writes a packet before the write pump is started
+func (s *Server) writeRaw(ws *gorilla.Conn, packet []byte) error {
+	ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return ws.WriteMessage(gorilla.BinaryMessage, packet)
+}
+
+// PublishFilters returns the topic filters of the mqtt.publish claim
+func PublishFilters(payload map[string]interface{}) []string {
+	claim, ok := payload["mqtt"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	list, ok := claim["publish"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	filters := make([]string, 0, len(list))
+	for _, item := range list {
+		if filter, ok := item.(string); ok && ValidFilter(filter) {
+			filters = append(filters, filter)
+		}
+	}
+	return filters
+}
+
+// mayPublish reports whether the client may publish to a topic
+func (c *conn) mayPublish(topic string) bool {
+	for _, filter := range c.publish {
+		if Match(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// send queues a packet without blocking, a connection that can not keep up is closed
+func (c *conn) send(packet Packet) {
+	c.out.Queue(packet.Encode())
+}
+
+// readPump handles packets sent by the client
+func (c *conn) readPump(stream io.Reader, keepAlive time.Duration) {
+	defer c.out.Stop()
+
+	c.ws.SetReadDeadline(time.Now().Add(keepAlive))
+	c.ws.SetPongHandler(func(string) error {
+		c.ws.SetReadDeadline(time.Now().Add(keepAlive))
+		return nil
+	})
+
+	for {
+		packet, err := ReadPacket(stream, MaxPacketSize)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !gorilla.IsCloseError(err, gorilla.CloseNormalClosure, gorilla.CloseGoingAway) {
+				c.server.logger.Debug("MQTT connection closed", "endpoint", c.endpoint, "client_id", c.clientID, "error", err)
+			}
+			return
+		}
+		c.ws.SetReadDeadline(time.Now().Add(keepAlive))
+
+		switch p := packet.(type) {
+		case *Publish:
+			if err := c.handlePublish(p); err != nil {
+				c.server.logger.Debug("MQTT publish rejected", "endpoint", c.endpoint, "client_id", c.clientID, "topic", p.Topic, "error", err)
+				return
+			}
+
+		case *Puback:
+			// Deliveries are not retried, so acknowledgements need no bookkeeping
+
+		case *Subscribe:
+			c.handleSubscribe(p)
+
+		case *Unsubscribe:
+			for _, filter := range p.Filters {
+				c.mu.Lock()
+				delete(c.subs, filter)
+				c.mu.Unlock()
+				c.client.Unsubscribe(filter)
+			}
+			c.send(&Unsuback{PacketID: p.PacketID})
+
+		case *Pingreq:
+			c.send(&Pingresp{})
+
+		case *Disconnect:
+			// A clean disconnect discards the will
+			c.will = nil
+			return
+
+		default:
+			c.server.logger.Debug("Unexpected MQTT packet", "endpoint", c.endpoint, "client_id", c.clientID, "packet", fmt.Sprintf("%T", p))
+			return
+		}
+	}
+}
+
+// handlePublish publishes a client message to the hub, errors close the connection
+func (c *conn) handlePublish(p *Publish) error {
+	if p.QoS > 1 {
+		return fmt.Errorf("QoS %d is not supported", p.QoS)
+	}
+	if p.QoS == 1 && p.PacketID == 0 {
+		return fmt.Errorf("missing packet id")
+	}
+	if !ValidTopic(p.Topic) {
+		return fmt.Errorf("invalid topic name")
+	}
+	if !c.mayPublish(p.Topic) {
+		return fmt.Errorf("not allowed to publish to topic")
+	}
+
+	if err := c.broadcast(p); err != nil {
+		return err
+	}
+	if p.QoS == 1 {
+		c.send(&Puback{PacketID: p.PacketID})
+	}
+	return nil
+}
+
+// broadcast publishes a PUBLISH packet to the hub
+func (c *conn) broadcast(p *Publish) error {
+	return c.server.hub.Broadcast(websocket.BroadcastMessage{
+		Message:  websocket.EncodePayload(p.Payload),
+		Endpoint: c.endpoint,
+		Topic:    p.Topic,
+	})
+}
+
+// handleSubscribe subscribes the client to topic filters, granting at most QoS 1
+func (c *conn) handleSubscribe(p *Subscribe) {
+	codes := make([]byte, len(p.Subscriptions))
+	for i, sub := range p.Subscriptions {
+		if !ValidFilter(sub.Filter) || sub.QoS > 2 {
+			codes[i] = SubackFailure
+			continue
+		}
+
+		var err error
+		if IsWildcard(sub.Filter) {
+			filter := sub.Filter
+			err = c.client.SubscribePattern(filter, func(topic string) bool {
+				return Match(filter, topic)
+			}, "")
+		} else {
+			err = c.client.Subscribe(websocket.SubscriptionMessage{
+				Type:  websocket.MessageTypeSubscribe,
+				Topic: sub.Filter,
+			})
+		}
+		if err != nil {
+			codes[i] = SubackFailure
+			continue
+		}
+
+		codes[i] = min(sub.QoS, 1)
+		c.mu.Lock()
+		c.subs[sub.Filter] = codes[i]
+		c.mu.Unlock()
+	}
+	c.send(&Suback{PacketID: p.PacketID, ReturnCodes: codes})
+}
+
+// route returns the topic and QoS a hub message is delivered with, the first subscribed topic wins
+func (c *conn) route(msg *websocket.BroadcastMessage) (string, byte, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, topic := range msg.AllTopics() {
+		granted, found := byte(0), false
+		for filter, qos := range c.subs {
+			if Match(filter, topic) {
+				granted, found = max(granted, qos), true
+			}
+		}
+		if found {
+			return topic, granted, true
+		}
+	}
+	return "", 0, false
+}
+
+// writePump sends hub deliveries and queued packets to the client
+func (c *conn) writePump() {
+	websocket.Pump{
+		Outbox:  c.out,
+		Client:  c.client,
+		Conn:    c.ws,
+		Write:   c.write,
+		Deliver: c.deliver,
+		Closed: func(reason websocket.CloseReason) {
+			// MQTT 3.1.1 has no server DISCONNECT, the close frame carries the reason
+			c.ws.WriteControl(gorilla.CloseMessage, reason.Message(), time.Now().Add(writeWait))
+		},
+		Ping: websocket.PingTick(c.ws, c.server.hub.PingInterval(), writeWait),
+	}.Run()
+}
+
+// deliver sends a hub message as a PUBLISH packet if it matches a subscription
+func (c *conn) deliver(delivery websocket.Delivery) error {
+	if delivery.Message == nil {
+		return nil
+	}
+	topic, qos, ok := c.route(delivery.Message)
+	if !ok {
+		return nil
+	}
+
+	payload, _ := websocket.DecodePayload(delivery.Message.Message)
+	publish := &Publish{Topic: topic, QoS: qos, Payload: payload}
+	if qos > 0 {
+		c.packetID++
+		if c.packetID == 0 {
+			c.packetID = 1
+		}
+		publish.PacketID = c.packetID
+	}
+	return c.write(publish.Encode())
+}
+
+// write writes a single packet to the client
+func (c *conn) write(packet []byte) error {
+	c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.ws.WriteMessage(gorilla.BinaryMessage, packet)
+}
+
+// newClientID generates a client id for clients connecting without one
+func newClientID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "driplet-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,57 @@
+package mqtt
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidTopic reports whether a topic name may be published to, it must not contain wildcards
+func ValidTopic(topic string) bool {
+	return topic != "" && utf8.ValidString(topic) && !strings.ContainsAny(topic, "+#\x00")
+}
+
+// ValidFilter reports whether a topic filter is valid: + must occupy a whole level and # must be the last level
+func ValidFilter(filter string) bool {
+	if filter == "" || !utf8.ValidString(filter) || strings.Contains(filter, "\x00") {
+		return false
+	}
+
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "+") && level != "+" {
+			return false
+		}
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsWildcard reports whether a topic filter contains wildcards
+func IsWildcard(filter string) bool {
+	return strings.ContainsAny(filter, "+#")
+}
+
+// Match reports whether a topic name matches a topic filter.
+// Topics starting with $ are not matched by filters starting with a wildcard.
+func Match(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,57 @@
+package mqtt
+
+import (
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		filter   string
+		topic    string
+		expected bool
+	}{
+		{"sport/tennis/player1", "sport/tennis/player1", true},
+		{"sport/tennis/player1", "sport/tennis/player2", false},
+		{"sport/tennis/player1/#", "sport/tennis/player1", true},
+		{"sport/tennis/player1/#", "sport/tennis/player1/ranking", true},
+		{"sport/tennis/player1/#", "sport/tennis/player1/score/wimbledon", true},
+		{"sport/#", "sport", true},
+		{"#", "sport/tennis", true},
+		{"sport/tennis/+", "sport/tennis/player1", true},
+		{"sport/tennis/+", "sport/tennis/player1/ranking", false},
+		{"sport/+", "sport", false},
+		{"sport/+", "sport/", true},
+		{"+/+", "/finance", true},
+		{"/+", "/finance", true},
+		{"+", "/finance", false},
+		{"#", "$SYS/broker", false},
+		{"+/broker", "$SYS/broker", false},
+		{"$SYS/#", "$SYS/broker", true},
+	}
+
+	for _, tt := range tests {
+		if got := Match(tt.filter, tt.topic); got != tt.expected {
+			t.Errorf("Match(%q, %q): expected %v, got %v", tt.filter, tt.topic, tt.expected, got)
+		}
+	}
+}
+
+func TestValidFilter(t *testing.T) {
+	valid := []string{"#", "+", "a/+/c", "a/#", "+/+", "/", "a//b"}
+	invalid := []string{"", "a#", "a/#/b", "a+", "a/b+/c", "a/\x00"}
+
+	for _, filter := range valid {
+		if !ValidFilter(filter) {
+			t.Errorf("expected %q to be valid", filter)
+		}
+	}
+	for _, filter := range invalid {
+		if ValidFilter(filter) {
+			t.Errorf("expected %q to be invalid", filter)
+		}
+	}
+
+	if ValidTopic("a/+") || ValidTopic("a/#") || ValidTopic("") || !ValidTopic("a/b") {
+		t.Error("expected topic names without wildcards to be valid")
+	}
+}
@@ -0,0 +1,342 @@
+// Package multiplex runs several endpoint connections over one WebSocket, each in its own channel.
+package multiplex
+
+import (
+	"encoding/json"
+	"fmt"
+	gorilla "github.com/gorilla/websocket"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/websocket"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxChannels is the maximum number of open channels of a connection
+	MaxChannels = 16
+	// MaxChannelID is the maximum length of a channel id
+	MaxChannelID = 64
+	// MaxMessageSize is the maximum size of a message sent by the client
+	MaxMessageSize = 64 * 1024
+	// writeWait is the time allowed to write a message to the client
+	writeWait = 10 * time.Second
+)
+
+const (
+	MessageTypeAuth          = "auth"
+	MessageTypeAuthenticated = "authenticated"
+	MessageTypeLeave         = "leave"
+	MessageTypeLeft          = "left"
+	MessageTypeClose         = "close"
+)
+
+// Authenticator validates the JWT of an auth message for an endpoint
+type Authenticator func(endpoint, token string) (*jwt.Claims, error)
+
+// Envelope holds the fields common to all client messages
+type Envelope struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+	// Endpoint and Token authenticate a channel
+	Endpoint string `json:"endpoint,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// ChannelMessage is a reply about a channel, e.g. the confirmation of an auth message
+type ChannelMessage struct {
+	Type     string `json:"type"`
+	Channel  string `json:"channel"`
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// CloseMessage is sent when driplet closes a channel
+type CloseMessage struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+	websocket.CloseHint
+}
+
+// Server runs multiplexed connections on top of the hub
+type Server struct {
+	logger *slog.Logger
+	hub    *websocket.Hub
+}
+
+// channel is an endpoint connection within a multiplexed connection
+type channel struct {
+	id     string
+	client *websocket.Client
+}
+
+// conn is a single multiplexed connection
+type conn struct {
+	server       *Server
+	ws           *gorilla.Conn
+	authenticate Authenticator
+	out          *websocket.Outbox
+	mu           sync.Mutex
+	channels     map[string]*channel
+}
+
+// NewServer creates a new multiplexing server
+func NewServer(logger *slog.Logger, hub *websocket.Hub) *Server {
+	return &Server{
+		logger: logger,
+		hub:    hub,
+	}
+}
+
+// Serve runs a multiplexed connection until it is closed
+func (s *Server) Serve(ws *gorilla.Conn, authenticate Authenticator) {
+	c := &conn{
+		server:       s,
+		ws:           ws,
+		authenticate: authenticate,
+		out:          websocket.NewOutbox(256),
+		channels:     make(map[string]*channel),
+	}
+	defer ws.Close()
+
+	go c.writePump()
+	c.readPump()
+	c.out.Stop()
+
+	// Every channel is detached like a separate connection going away
+	c.mu.Lock()
+	channels := c.channels
+	c.channels = make(map[string]*channel)
+	c.mu.Unlock()
+	for _, ch := range channels {
+		ch.client.Detach()
+	}
+}
+
+// readPump handles the messages sent by the client
+func (c *conn) readPump() {
+	pongWait := 2 * c.server.hub.PingInterval()
+	c.ws.SetReadLimit(MaxMessageSize)
+	c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	c.ws.SetPongHandler(func(string) error {
+		c.ws.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	for {
+		_, message, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var envelope Envelope
+		if err := json.Unmarshal(message, &envelope); err != nil {
+			continue
+		}
+
+		switch envelope.Type {
+		case MessageTypeAuth:
+			c.open(envelope)
+
+		case websocket.MessageTypeSubscribe:
+			ch, ok := c.channel(envelope.Channel)
+			if !ok {
+				continue
+			}
+			var sub websocket.SubscriptionMessage
+			if err := json.Unmarshal(message, &sub); err != nil {
+				continue
+			}
+			if err := ch.client.Subscribe(sub); err != nil {
+				c.server.logger.Debug("Client subscription rejected",
+					"channel", ch.id,
+					"topic", sub.Topic,
+					"error", err,
+				)
+				c.fail(ch.id, sub.Topic, err.Error())
+			}
+
+		case websocket.MessageTypeUnsubscribe:
+			ch, ok := c.channel(envelope.Channel)
+			if !ok {
+				continue
+			}
+			var sub websocket.SubscriptionMessage
+			if err := json.Unmarshal(message, &sub); err != nil {
+				continue
+			}
+			ch.client.Unsubscribe(sub.Topic)
+
+		case MessageTypeLeave:
+			c.leave(envelope.Channel)
+
+		case websocket.MessageTypePing:
+			var ping websocket.PingMessage
+			if err := json.Unmarshal(message, &ping); err != nil {
+				continue
+			}
+			pong, _ := json.Marshal(websocket.PongMessage{
+				Type:       websocket.MessageTypePong,
+				ID:         ping.ID,
+				Timestamp:  ping.Timestamp,
+				ServerTime: time.Now().UnixMilli(),
+			})
+			if envelope.Channel != "" {
+				pong = WithChannel(envelope.Channel, pong)
+			}
+			c.out.Queue(pong)
+		}
+	}
+}
+
+// open authenticates a channel and attaches it to the hub as a client of its endpoint
+func (c *conn) open(envelope Envelope) {
+	if envelope.Channel == "" || len(envelope.Channel) > MaxChannelID {
+		c.fail(envelope.Channel, "", fmt.Sprintf("channel id must have 1 to %d characters", MaxChannelID))
+		return
+	}
+
+	c.mu.Lock()
+	_, exists := c.channels[envelope.Channel]
+	count := len(c.channels)
+	c.mu.Unlock()
+	if exists {
+		c.fail(envelope.Channel, "", "channel already open")
+		return
+	}
+	if count >= MaxChannels {
+		c.fail(envelope.Channel, "", fmt.Sprintf("at most %d channels can be open", MaxChannels))
+		return
+	}
+
+	claims, err := c.authenticate(envelope.Endpoint, envelope.Token)
+	if err != nil {
+		c.server.logger.Debug("Invalid token", "endpoint", envelope.Endpoint, "channel", envelope.Channel, "error", err)
+		c.fail(envelope.Channel, "", "invalid token")
+		return
+	}
+
+	ch := &channel{
+		id:     envelope.Channel,
+		client: c.server.hub.Attach(envelope.Endpoint, claims),
+	}
+	c.mu.Lock()
+	c.channels[ch.id] = ch
+	c.mu.Unlock()
+
+	authenticated, _ := json.Marshal(ChannelMessage{
+		Type:     MessageTypeAuthenticated,
+		Channel:  ch.id,
+		Endpoint: envelope.Endpoint,
+	})
+	c.out.Queue(authenticated)
+	go c.forward(ch)
+}
+
+// leave detaches a channel on request of the client
+func (c *conn) leave(id string) {
+	c.mu.Lock()
+	ch, ok := c.channels[id]
+	delete(c.channels, id)
+	c.mu.Unlock()
+	if !ok {
+		c.fail(id, "", "unknown channel")
+		return
+	}
+
+	ch.client.Detach()
+	left, _ := json.Marshal(ChannelMessage{Type: MessageTypeLeft, Channel: id})
+	c.out.Queue(left)
+}
+
+// channel returns an open channel, unknown channels are answered with an error
+func (c *conn) channel(id string) (*channel, bool) {
+	c.mu.Lock()
+	ch, ok := c.channels[id]
+	c.mu.Unlock()
+	if !ok {
+		c.fail(id, "", "unknown channel")
+	}
+	return ch, ok
+}
+
+// forward sends the deliveries of a channel until its client is closed
+func (c *conn) forward(ch *channel) {
+	for delivery := range ch.client.Deliveries() {
+		// Block instead of dropping so a slow connection is detected per channel by the hub
+		if !c.out.Send(websocket.OutFrame{Data: WithChannel(ch.id, delivery.Data)}) {
+			return
+		}
+	}
+
+	// Channels left by the client or closed with the connection need no close message
+	c.mu.Lock()
+	current := c.channels[ch.id] == ch
+	if current {
+		delete(c.channels, ch.id)
+	}
+	c.mu.Unlock()
+	if !current {
+		return
+	}
+
+	// Tell the client why the channel was closed and when to reconnect it
+	reason := ch.client.CloseReason()
+	closing, _ := json.Marshal(CloseMessage{
+		Type:      MessageTypeClose,
+		Channel:   ch.id,
+		CloseHint: reason.Hint(),
+	})
+	next := websocket.OutFrame{Data: closing}
+	if reason.Code == websocket.CloseCodeShutdown {
+		// The whole connection goes away on shutdown
+		next.Close = reason.Message()
+	}
+	c.out.Send(next)
+}
+
+// fail queues an error message
+func (c *conn) fail(id, topic, message string) {
+	data, _ := json.Marshal(websocket.ErrorMessage{
+		Type:  websocket.MessageTypeError,
+		Topic: topic,
+		Error: message,
+	})
+	if id != "" {
+		data = WithChannel(id, data)
+	}
+	c.out.Queue(data)
+}
+
+// writePump writes queued messages and pings to the client
+func (c *conn) writePump() {
+	websocket.Pump{
+		Outbox: c.out,
+		Conn:   c.ws,
+		Write: func(data []byte) error {
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			return c.ws.WriteMessage(gorilla.TextMessage, data)
+		},
+		WriteClose: func(message []byte) {
+			c.ws.WriteControl(gorilla.CloseMessage, message, time.Now().Add(writeWait))
+		},
+		Stopped: func() {
+			c.ws.WriteControl(gorilla.CloseMessage,
+				gorilla.FormatCloseMessage(gorilla.CloseNormalClosure, ""),
+				time.Now().Add(writeWait),
+			)
+		},
+		Ping: websocket.PingTick(c.ws, c.server.hub.PingInterval(), writeWait),
+	}.Run()
+}
+
+// WithChannel adds the channel id to a JSON object message
+func WithChannel(id string, data []byte) []byte {
+	channel, _ := json.Marshal(id)
+	framed := make([]byte, 0, len(data)+len(channel)+12)
+	framed = append(framed, `{"channel":`...)
+	framed = append(framed, channel...)
+	if len(data) > 2 {
+		framed = append(framed, ',')
+	}
+	return append(framed, data[1:]...)
+}
@@ -0,0 +1,177 @@
+package multiplex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	gorilla "github.com/gorilla/websocket"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/websocket"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tokens maps test tokens to the endpoint they are valid for and their claims
+var tokens = map[string]struct {
+	endpoint string
+	role     string
+}{
+	"shop-admin": {"shop", "admin"},
+	"shop-user":  {"shop", "user"},
+	"blog-user":  {"blog", "user"},
+}
+
+func newTestServer(t *testing.T) (*websocket.Hub, *gorilla.Conn) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	hub := websocket.NewHub(logger)
+	go hub.Run()
+	server := NewServer(logger, hub)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := hub.Upgrade(w, r, "", nil)
+		if err != nil {
+			return
+		}
+		server.Serve(ws, func(endpoint, token string) (*jwt.Claims, error) {
+			if tokens[token].endpoint != endpoint {
+				return nil, errors.New("invalid token")
+			}
+			return &jwt.Claims{Custom: map[string]interface{}{"role": tokens[token].role}}, nil
+		})
+	}))
+	t.Cleanup(ts.Close)
+
+	ws, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return hub, ws
+}
+
+func send(t *testing.T, ws *gorilla.Conn, message string) {
+	t.Helper()
+	if err := ws.WriteMessage(gorilla.TextMessage, []byte(message)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, ws *gorilla.Conn) map[string]interface{} {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("could not read message: %v", err)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("invalid message %q: %v", data, err)
+	}
+	return msg
+}
+
+// TestChannels verifies multiplexed channels:
+// - Channels authenticate to their own endpoint with their own token
+// - Messages are delivered to the channels of their endpoint only, tagged with the channel id
+// - Targeting uses the claims of each channel
+// - Errors of channel commands carry the channel id
+// - Left channels receive no more messages
+func TestChannels(t *testing.T) {
+	hub, ws := newTestServer(t)
+
+	send(t, ws, `{"type":"auth","channel":"a","endpoint":"shop","token":"shop-admin"}`)
+	if msg := read(t, ws); msg["type"] != "authenticated" || msg["channel"] != "a" || msg["endpoint"] != "shop" {
+		t.Fatalf("expected channel a to be authenticated, got %v", msg)
+	}
+	send(t, ws, `{"type":"auth","channel":"b","endpoint":"blog","token":"shop-user"}`)
+	if msg := read(t, ws); msg["type"] != "error" || msg["channel"] != "b" || msg["error"] != "invalid token" {
+		t.Fatalf("expected token for another endpoint to be rejected, got %v", msg)
+	}
+	send(t, ws, `{"type":"auth","channel":"b","endpoint":"blog","token":"blog-user"}`)
+	read(t, ws)
+	send(t, ws, `{"type":"auth","channel":"c","endpoint":"shop","token":"shop-user"}`)
+	read(t, ws)
+	send(t, ws, `{"type":"auth","channel":"a","endpoint":"shop","token":"shop-user"}`)
+	if msg := read(t, ws); msg["error"] != "channel already open" {
+		t.Errorf("expected duplicate channel to be rejected, got %v", msg)
+	}
+
+	for _, id := range []string{"a", "b", "c"} {
+		send(t, ws, `{"type":"subscribe","channel":"`+id+`","topic":"news"}`)
+	}
+	send(t, ws, `{"type":"subscribe","channel":"b","topic":"news","filter":"("}`)
+	if msg := read(t, ws); msg["type"] != "error" || msg["channel"] != "b" || msg["topic"] != "news" {
+		t.Errorf("expected subscription error on channel b, got %v", msg)
+	}
+	send(t, ws, `{"type":"subscribe","channel":"x","topic":"news"}`)
+	if msg := read(t, ws); msg["error"] != "unknown channel" || msg["channel"] != "x" {
+		t.Errorf("expected unknown channel error, got %v", msg)
+	}
+
+	// Only the admin channel of the shop endpoint receives the targeted message
+	hub.Broadcast(websocket.BroadcastMessage{
+		Endpoint: "shop",
+		Topic:    "news",
+		Message:  json.RawMessage(`"admins"`),
+		Target:   websocket.Target{Include: map[string]interface{}{"role": "admin"}},
+	})
+	if msg := read(t, ws); msg["channel"] != "a" || msg["message"] != "admins" || msg["endpoint"] != "shop" {
+		t.Errorf("expected targeted message on channel a, got %v", msg)
+	}
+	hub.Broadcast(websocket.BroadcastMessage{Endpoint: "blog", Topic: "news", Message: json.RawMessage(`"blog"`)})
+	if msg := read(t, ws); msg["channel"] != "b" || msg["message"] != "blog" {
+		t.Errorf("expected blog message on channel b, got %v", msg)
+	}
+
+	send(t, ws, `{"type":"leave","channel":"b"}`)
+	if msg := read(t, ws); msg["type"] != "left" || msg["channel"] != "b" {
+		t.Errorf("expected channel b to be left, got %v", msg)
+	}
+	hub.Broadcast(websocket.BroadcastMessage{Endpoint: "blog", Topic: "news", Message: json.RawMessage(`"skipped"`)})
+	send(t, ws, `{"type":"ping","channel":"c","id":"p1"}`)
+	if msg := read(t, ws); msg["type"] != "pong" || msg["channel"] != "c" || msg["id"] != "p1" {
+		t.Errorf("expected pong, got %v", msg)
+	}
+
+	// Shutdown closes the channels and then the connection
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	hub.Shutdown(ctx)
+	if msg := read(t, ws); msg["type"] != "close" || msg["reason"] != "shutdown" {
+		t.Errorf("expected channel close message, got %v", msg)
+	}
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			if !gorilla.IsCloseError(err, websocket.CloseCodeShutdown) {
+				t.Errorf("expected shutdown close frame, got %v", err)
+			}
+			break
+		}
+	}
+}
+
+// TestWithChannel verifies that the channel id is added to JSON objects
+func TestWithChannel(t *testing.T) {
+	tests := []struct {
+		id       string
+		data     string
+		expected string
+	}{
+		{"a", `{"type":"pong"}`, `{"channel":"a","type":"pong"}`},
+		{"a", `{}`, `{"channel":"a"}`},
+		{`"q"`, `{"n":1}`, `{"channel":"\"q\"","n":1}`},
+	}
+
+	for _, tt := range tests {
+		if framed := string(WithChannel(tt.id, []byte(tt.data))); framed != tt.expected {
+			t.Errorf("expected %s, got %s", tt.expected, framed)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Package pusher implements the Pusher Channels WebSocket protocol and REST API signatures
+// needed to map Pusher apps onto hub endpoints.
+package pusher
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProtocolVersion is the Pusher protocol version spoken by the server
+const ProtocolVersion = 7
+
+// MaxTimestampSkew is the maximum age of a signed REST request
+const MaxTimestampSkew = 600 * time.Second
+
+// App is a Pusher app mapped onto a driplet endpoint
+type App struct {
+	ID  string
+	Key string
+	// Secrets are tried in order, more than one is active while a secret is rotated
+	Secrets  []string
+	Endpoint string
+}
+
+// Event is a Pusher protocol frame, data is a string for all events sent by the server
+type Event struct {
+	Event   string          `json:"event"`
+	Channel string          `json:"channel,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	UserID  string          `json:"user_id,omitempty"`
+}
+
+// SubscribeData is the data of a pusher:subscribe event
+type SubscribeData struct {
+	Channel     string `json:"channel"`
+	Auth        string `json:"auth,omitempty"`
+	ChannelData string `json:"channel_data,omitempty"`
+}
+
+// Member is a presence channel member from the channel_data of a subscription
+type Member struct {
+	UserID   string          `json:"user_id"`
+	UserInfo json.RawMessage `json:"user_info,omitempty"`
+}
+
+// Error is the data of a pusher:error event
+type Error struct {
+	Message string `json:"message"`
+	Code    *int   `json:"code"`
+}
+
+// IsPrivate reports whether a channel requires an auth signature
+func IsPrivate(channel string) bool {
+	return strings.HasPrefix(channel, "private-") || IsPresence(channel)
+}
+
+// IsPresence reports whether a channel is a presence channel
+func IsPresence(channel string) bool {
+	return strings.HasPrefix(channel, "presence-")
+}
+
+// NewSocketID generates a socket id in the Pusher format
+func NewSocketID() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("error generating socket id: %w", err)
+	}
+	return fmt.Sprintf("%d.%d", binary.BigEndian.Uint32(b[:4]), binary.BigEndian.Uint32(b[4:])), nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the message
+func Sign(secret, message string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ChannelAuth returns the auth string of a private or presence channel subscription
+func ChannelAuth(key, secret, socketID, channel, channelData string) string {
+	message := socketID + ":" + channel
+	if channelData != "" {
+		message += ":" + channelData
+	}
+	return key + ":" + Sign(secret, message)
+}
+
+// VerifyChannelAuth checks the auth string of a private or presence channel subscription against each secret
+func VerifyChannelAuth(key string, secrets []string, socketID string, sub SubscribeData) bool {
+	channelData := ""
+	if IsPresence(sub.Channel) {
+		channelData = sub.ChannelData
+	}
+	for _, secret := range secrets {
+		expected := ChannelAuth(key, secret, socketID, sub.Channel, channelData)
+		if hmac.Equal([]byte(expected), []byte(sub.Auth)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseMember decodes presence channel_data, numeric user ids are converted to strings
+func ParseMember(channelData string) (*Member, error) {
+	var raw struct {
+		UserID   json.RawMessage `json:"user_id"`
+		UserInfo json.RawMessage `json:"user_info"`
+	}
+	if err := json.Unmarshal([]byte(channelData), &raw); err != nil {
+		return nil, fmt.Errorf("invalid channel_data: %w", err)
+	}
+
+	var id string
+	if err := json.Unmarshal(raw.UserID, &id); err != nil {
+		var number json.Number
+		if err := json.Unmarshal(raw.UserID, &number); err != nil {
+			return nil, fmt.Errorf("invalid user_id")
+		}
+		id = number.String()
+	}
+	if id == "" {
+		return nil, fmt.Errorf("missing user_id")
+	}
+	return &Member{UserID: id, UserInfo: raw.UserInfo}, nil
+}
+
+// SignQuery returns the REST API auth_signature of a request
+func SignQuery(secret, method, path string, query url.Values) string {
+	params := make([]string, 0, len(query))
+	for key, values := range query {
+		if key == "auth_signature" || len(values) == 0 {
+			continue
+		}
+		params = append(params, strings.ToLower(key)+"="+values[0])
+	}
+	sort.Strings(params)
+
+	return Sign(secret, method+"\n"+path+"\n"+strings.Join(params, "&"))
+}
+
+// VerifyRequest checks the REST API signature, key, timestamp and body digest of a request
+func VerifyRequest(app App, method, path string, query url.Values, body []byte, now time.Time) error {
+	if query.Get("auth_key") != app.Key {
+		return fmt.Errorf("unknown auth_key")
+	}
+
+	timestamp, err := strconv.ParseInt(query.Get("auth_timestamp"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid auth_timestamp")
+	}
+	if skew := now.Sub(time.Unix(timestamp, 0)); skew > MaxTimestampSkew || skew < -MaxTimestampSkew {
+		return fmt.Errorf("auth_timestamp expired")
+	}
+
+	if len(body) > 0 {
+		digest := md5.Sum(body)
+		if query.Get("body_md5") != hex.EncodeToString(digest[:]) {
+			return fmt.Errorf("body_md5 does not match")
+		}
+	}
+
+	for _, secret := range app.Secrets {
+		expected := SignQuery(secret, method, path, query)
+		if hmac.Equal([]byte(expected), []byte(query.Get("auth_signature"))) {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid auth_signature")
+}
+
+// Frame encodes an event with string data
+func Frame(event, channel, data string) []byte {
+	return UserFrame(event, channel, data, "")
+}
+
+// UserFrame encodes an event with string data sent by a presence channel member
+func UserFrame(event, channel, data, userID string) []byte {
+	encoded, _ := json.Marshal(data)
+	frame, _ := json.Marshal(Event{Event: event, Channel: channel, Data: encoded, UserID: userID})
+	return frame
+}
+
+// IsClientEvent reports whether an event is a client event relayed to the other members of a channel
+func IsClientEvent(event string) bool {
+	return strings.HasPrefix(event, "client-")
+}
+
+// ErrorFrame encodes a pusher:error event
+func ErrorFrame(message string, code int) []byte {
+	data := Error{Message: message}
+	if code != 0 {
+		data.Code = &code
+	}
+	encoded, _ := json.Marshal(data)
+	frame, _ := json.Marshal(Event{Event: "pusher:error", Data: encoded})
+	return frame
+}
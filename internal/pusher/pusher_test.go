@@ -0,0 +1,128 @@
+package pusher
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+const (
+	testKey    = "278d425bdf160c739803"
+	testSecret = "7ad3773142a6692b25b8"
+)
+
+func TestChannelAuth(t *testing.T) {
+	tests := []struct {
+		name        string
+		channel     string
+		channelData string
+		expected    string
+	}{
+		{
+			name:     "private channel",
+			channel:  "private-foobar",
+			expected: testKey + ":58df8b0c36d6982b82c3ecf6b4662e34fe8c25bba48f5369f135bf843651c3a4",
+		},
+		{
+			name:        "presence channel",
+			channel:     "presence-foobar",
+			channelData: `{"user_id":10,"user_info":{"name":"Mr. Channels"}}`,
+			expected:    testKey + ":31935e7d86dba64c2a90aed31fdc61869f9b22ba9d8863bba239c03ca481bc80",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := ChannelAuth(testKey, testSecret, "1234.1234", tt.channel, tt.channelData)
+			if auth != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, auth)
+			}
+
+			sub := SubscribeData{Channel: tt.channel, Auth: auth, ChannelData: tt.channelData}
+			if !VerifyChannelAuth(testKey, []string{testSecret}, "1234.1234", sub) {
+				t.Error("expected auth to verify")
+			}
+			if VerifyChannelAuth(testKey, []string{testSecret}, "1234.1235", sub) {
+				t.Error("expected auth for another socket to fail")
+			}
+		})
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	app := App{ID: "3", Key: testKey, Secrets: []string{testSecret}}
+	body := []byte(`{"name":"foo","channels":["project-3"],"data":"{\"some\":\"data\"}"}`)
+	query := url.Values{
+		"auth_key":       {testKey},
+		"auth_timestamp": {"1353088179"},
+		"auth_version":   {"1.0"},
+		"body_md5":       {"ec365a775a4cd0599faeb73354201b6f"},
+		"auth_signature": {"da454824c97ba181a32ccc17a72625ba02771f50b50e1e7430e47a1f3f457e6c"},
+	}
+	now := time.Unix(1353088179, 0)
+
+	if err := VerifyRequest(app, "POST", "/apps/3/events", query, body, now); err != nil {
+		t.Fatalf("expected request to verify, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(url.Values) ([]byte, time.Time)
+	}{
+		{"wrong key", func(q url.Values) ([]byte, time.Time) {
+			q.Set("auth_key", "other")
+			return body, now
+		}},
+		{"expired timestamp", func(q url.Values) ([]byte, time.Time) {
+			return body, now.Add(MaxTimestampSkew + time.Second)
+		}},
+		{"modified body", func(q url.Values) ([]byte, time.Time) {
+			return []byte(`{"name":"bar"}`), now
+		}},
+		{"modified query", func(q url.Values) ([]byte, time.Time) {
+			q.Set("auth_version", "2.0")
+			return body, now
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := url.Values{}
+			for k, v := range query {
+				q[k] = v
+			}
+			body, now := tt.modify(q)
+			if err := VerifyRequest(app, "POST", "/apps/3/events", q, body, now); err == nil {
+				t.Error("expected verification to fail")
+			}
+		})
+	}
+}
+
+func TestParseMember(t *testing.T) {
+	member, err := ParseMember(`{"user_id":10,"user_info":{"name":"Mr. Channels"}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if member.UserID != "10" {
+		t.Errorf("expected user id 10, got %s", member.UserID)
+	}
+
+	if _, err := ParseMember(`{"user_info":{}}`); err == nil {
+		t.Error("expected error for missing user_id")
+	}
+}
+
+func TestUserFrame(t *testing.T) {
+	if !IsClientEvent("client-typing") || IsClientEvent("pusher:ping") {
+		t.Error("expected only client- events to be client events")
+	}
+
+	frame := UserFrame("client-typing", "presence-room", `{"n":1}`, "10")
+	if string(frame) != `{"event":"client-typing","channel":"presence-room","data":"{\"n\":1}","user_id":"10"}` {
+		t.Errorf("unexpected frame %s", frame)
+	}
+	if frame := Frame("message", "room", "hi"); string(frame) != `{"event":"message","channel":"room","data":"hi"}` {
+		t.Errorf("unexpected frame %s", frame)
+	}
+}
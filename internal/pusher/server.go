@@ -0,0 +1,443 @@
+package pusher
+
+import (
+	"encoding/json"
+	"fmt"
+	gorilla "github.com/gorilla/websocket"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/websocket"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// ActivityTimeout is the idle time in seconds after which clients send pusher:ping
+	ActivityTimeout = 120
+	// writeWait is the time allowed to write a frame to the client
+	writeWait = 10 * time.Second
+	// maxFrameSize is the maximum size of a frame sent by a client
+	maxFrameSize = 10 * 1024
+)
+
+// Pusher close codes: 4000-4099 do not reconnect, 4100-4199 reconnect with backoff, 4200-4299 reconnect
+const (
+	CodeUnsupportedProtocol = 4007
+	CodeUnauthorized        = 4009
+	CodeOverCapacity        = 4100
+	CodeReconnect           = 4200
+)
+
+// Server runs Pusher protocol connections on top of the hub and tracks presence channel members
+type Server struct {
+	logger   *slog.Logger
+	hub      *websocket.Hub
+	mu       sync.Mutex
+	presence map[string]*presenceChannel
+}
+
+// presenceChannel holds the members and connections of a presence channel
+type presenceChannel struct {
+	members map[string]*presenceMember
+	conns   map[*conn]string
+}
+
+// presenceMember is a member of a presence channel, connected with one or more sockets
+type presenceMember struct {
+	info    json.RawMessage
+	sockets int
+}
+
+// conn is a single Pusher protocol connection
+type conn struct {
+	server   *Server
+	app      App
+	ws       *gorilla.Conn
+	socketID string
+	client   *websocket.Client
+	out      *websocket.Outbox
+	mu       sync.RWMutex
+	channels map[string]bool
+}
+
+// NewServer creates a new Pusher protocol server
+func NewServer(logger *slog.Logger, hub *websocket.Hub) *Server {
+	return &Server{
+		logger:   logger,
+		hub:      hub,
+		presence: make(map[string]*presenceChannel),
+	}
+}
+
+// Serve runs a Pusher protocol connection until it is closed
+func (s *Server) Serve(ws *gorilla.Conn, app App) {
+	defer ws.Close()
+
+	socketID, err := NewSocketID()
+	if err != nil {
+		s.logger.Error("Could not create socket id", "error", err)
+		return
+	}
+
+	c := &conn{
+		server:   s,
+		app:      app,
+		ws:       ws,
+		socketID: socketID,
+		out:      websocket.NewOutbox(64),
+		channels: make(map[string]bool),
+	}
+	c.client = s.hub.Attach(app.Endpoint, &jwt.Claims{},
+		websocket.WithID(socketID),
+		websocket.WithAuthorizer(c.authorized),
+		websocket.WithAnonymous(),
+	)
+	defer c.client.Detach()
+	defer s.leaveAll(c)
+
+	established, _ := json.Marshal(map[string]interface{}{
+		"socket_id":        socketID,
+		"activity_timeout": ActivityTimeout,
+	})
+	c.out.Queue(Frame("pusher:connection_established", "", string(established)))
+
+	go c.writePump()
+	c.readPump()
+}
+
+// authorized reports whether the connection authenticated to a private channel
+func (c *conn) authorized(channel string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return IsPrivate(channel) && c.channels[channel]
+}
+
+// subscribed reports whether the connection is subscribed to a channel
+func (c *conn) subscribed(channel string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.channels[channel]
+}
+
+// readPump handles events sent by the client
+func (c *conn) readPump() {
+	defer c.out.Stop()
+
+	c.ws.SetReadLimit(maxFrameSize)
+	deadline := time.Duration(ActivityTimeout)*time.Second + c.server.hub.PingInterval()
+	c.ws.SetReadDeadline(time.Now().Add(deadline))
+	c.ws.SetPongHandler(func(string) error {
+		c.ws.SetReadDeadline(time.Now().Add(deadline))
+		return nil
+	})
+
+	for {
+		var event Event
+		if err := c.ws.ReadJSON(&event); err != nil {
+			return
+		}
+		c.ws.SetReadDeadline(time.Now().Add(deadline))
+
+		switch event.Event {
+		case "pusher:ping":
+			c.out.Queue([]byte(`{"event":"pusher:pong","data":{}}`))
+
+		case "pusher:subscribe":
+			var sub SubscribeData
+			if err := json.Unmarshal(event.Data, &sub); err != nil || sub.Channel == "" {
+				c.out.Queue(ErrorFrame("Invalid subscribe data", 0))
+				continue
+			}
+			c.subscribe(sub)
+
+		case "pusher:unsubscribe":
+			var sub SubscribeData
+			if err := json.Unmarshal(event.Data, &sub); err != nil {
+				c.out.Queue(ErrorFrame("Invalid unsubscribe data", 0))
+				continue
+			}
+			c.unsubscribe(sub.Channel)
+
+		default:
+			if IsClientEvent(event.Event) {
+				c.clientEvent(event)
+				continue
+			}
+			c.out.Queue(ErrorFrame(fmt.Sprintf("Unsupported event: %s", event.Event), 0))
+		}
+	}
+}
+
+// subscribe authenticates and subscribes the connection to a channel
+func (c *conn) subscribe(sub SubscribeData) {
+	if c.subscribed(sub.Channel) {
+		return
+	}
+
+	var member *Member
+	if IsPrivate(sub.Channel) {
+		if !VerifyChannelAuth(c.app.Key, c.app.Secrets, c.socketID, sub) {
+			c.server.logger.Debug("Invalid Pusher channel auth", "endpoint", c.app.Endpoint, "channel", sub.Channel)
+			c.subscriptionError(sub.Channel, "Invalid signature")
+			return
+		}
+	}
+	if IsPresence(sub.Channel) {
+		var err error
+		if member, err = ParseMember(sub.ChannelData); err != nil {
+			c.subscriptionError(sub.Channel, err.Error())
+			return
+		}
+	}
+
+	if err := c.client.Subscribe(websocket.SubscriptionMessage{
+		Type:  websocket.MessageTypeSubscribe,
+		Topic: sub.Channel,
+	}); err != nil {
+		c.subscriptionError(sub.Channel, err.Error())
+		return
+	}
+	c.mu.Lock()
+	c.channels[sub.Channel] = true
+	c.mu.Unlock()
+
+	data := "{}"
+	if member != nil {
+		data = c.server.join(c, sub.Channel, member)
+	}
+	c.out.Queue(Frame("pusher_internal:subscription_succeeded", sub.Channel, data))
+}
+
+// clientEvent relays a client event to the other connections authorized for its private or presence channel
+func (c *conn) clientEvent(event Event) {
+	if !IsPrivate(event.Channel) {
+		c.out.Queue(ErrorFrame("Client event rejected - only supported on private and presence channels", 0))
+		return
+	}
+	if !c.authorized(event.Channel) {
+		c.out.Queue(ErrorFrame("Client event rejected - not subscribed to channel", 0))
+		return
+	}
+
+	// String data is relayed as the string, other JSON as is
+	data := []byte(event.Data)
+	var text string
+	if json.Unmarshal(event.Data, &text) == nil {
+		data = []byte(text)
+	}
+	var userID string
+	if IsPresence(event.Channel) {
+		userID = c.server.member(c, event.Channel)
+	}
+
+	if err := c.server.hub.Broadcast(websocket.BroadcastMessage{
+		Message:       websocket.EncodePayload(data),
+		Endpoint:      c.app.Endpoint,
+		Topic:         event.Channel,
+		Event:         event.Event,
+		Private:       true,
+		ExcludeClient: c.socketID,
+		UserID:        userID,
+	}); err != nil {
+		c.out.Queue(ErrorFrame(fmt.Sprintf("Client event rejected - %s", err), 0))
+	}
+}
+
+// subscriptionError sends a pusher:subscription_error event
+func (c *conn) subscriptionError(channel, message string) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":   "AuthError",
+		"error":  message,
+		"status": 401,
+	})
+	frame, _ := json.Marshal(Event{Event: "pusher:subscription_error", Channel: channel, Data: data})
+	c.out.Queue(frame)
+}
+
+// unsubscribe removes the connection from a channel
+func (c *conn) unsubscribe(channel string) {
+	c.mu.Lock()
+	subscribed := c.channels[channel]
+	delete(c.channels, channel)
+	c.mu.Unlock()
+	if !subscribed {
+		return
+	}
+
+	c.client.Unsubscribe(channel)
+	if IsPresence(channel) {
+		c.server.leave(c, channel)
+	}
+}
+
+// writePump sends hub deliveries and queued frames to the client
+func (c *conn) writePump() {
+	websocket.Pump{
+		Outbox: c.out,
+		Client: c.client,
+		Conn:   c.ws,
+		Write:  c.write,
+		Deliver: func(delivery websocket.Delivery) error {
+			if delivery.Message == nil {
+				return nil
+			}
+			return c.deliver(delivery.Message)
+		},
+		Closed: c.close,
+		Ping:   websocket.PingTick(c.ws, c.server.hub.PingInterval(), writeWait),
+	}.Run()
+}
+
+// deliver sends a hub message once for every subscribed channel it was published to
+func (c *conn) deliver(msg *websocket.BroadcastMessage) error {
+	event := msg.Event
+	if event == "" {
+		event = "message"
+	}
+	data, _ := websocket.DecodePayload(msg.Message)
+
+	for _, channel := range msg.AllTopics() {
+		if !c.subscribed(channel) {
+			continue
+		}
+		if err := c.write(UserFrame(event, channel, string(data), msg.UserID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write writes a single frame to the client
+func (c *conn) write(frame []byte) error {
+	c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.ws.WriteMessage(gorilla.TextMessage, frame)
+}
+
+// close sends a pusher:error event and a close frame mapped from the hub close reason
+func (c *conn) close(reason websocket.CloseReason) {
+	code := closeCode(reason)
+	c.write(ErrorFrame(reason.Reason, code))
+	c.ws.WriteControl(gorilla.CloseMessage,
+		gorilla.FormatCloseMessage(code, reason.Reason),
+		time.Now().Add(writeWait),
+	)
+}
+
+// closeCode maps a hub close reason onto the Pusher reconnect semantics
+func closeCode(reason websocket.CloseReason) int {
+	switch reason.Code {
+	case websocket.CloseCodeShutdown:
+		return CodeReconnect
+	case websocket.CloseCodeSlowConsumer:
+		return CodeOverCapacity
+	case websocket.CloseCodeKicked, websocket.CloseCodeTokenExpired:
+		return CodeUnauthorized
+	default:
+		return CodeReconnect
+	}
+}
+
+// join adds a member to a presence channel and returns the subscription_succeeded data
+func (s *Server) join(c *conn, channel string, member *Member) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := c.app.Endpoint + "\x00" + channel
+	p, ok := s.presence[key]
+	if !ok {
+		p = &presenceChannel{
+			members: make(map[string]*presenceMember),
+			conns:   make(map[*conn]string),
+		}
+		s.presence[key] = p
+	}
+
+	m, ok := p.members[member.UserID]
+	if !ok {
+		m = &presenceMember{info: member.UserInfo}
+		p.members[member.UserID] = m
+
+		// Other connections are notified when a user joins with its first socket
+		added, _ := json.Marshal(Member{UserID: member.UserID, UserInfo: member.UserInfo})
+		for other := range p.conns {
+			other.out.Queue(Frame("pusher_internal:member_added", channel, string(added)))
+		}
+	}
+	m.sockets++
+	p.conns[c] = member.UserID
+
+	ids := make([]string, 0, len(p.members))
+	hash := make(map[string]json.RawMessage, len(p.members))
+	for id, m := range p.members {
+		ids = append(ids, id)
+		hash[id] = m.info
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"presence": map[string]interface{}{
+			"ids":   ids,
+			"hash":  hash,
+			"count": len(ids),
+		},
+	})
+	return string(data)
+}
+
+// member returns the user id a connection joined a presence channel with
+func (s *Server) member(c *conn, channel string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.presence[c.app.Endpoint+"\x00"+channel]; ok {
+		return p.conns[c]
+	}
+	return ""
+}
+
+// leave removes a connection from a presence channel
+func (s *Server) leave(c *conn, channel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := c.app.Endpoint + "\x00" + channel
+	p, ok := s.presence[key]
+	if !ok {
+		return
+	}
+	userID, ok := p.conns[c]
+	if !ok {
+		return
+	}
+	delete(p.conns, c)
+
+	m := p.members[userID]
+	m.sockets--
+	if m.sockets > 0 {
+		return
+	}
+
+	// Other connections are notified when the last socket of a user leaves
+	delete(p.members, userID)
+	removed, _ := json.Marshal(Member{UserID: userID})
+	for other := range p.conns {
+		other.out.Queue(Frame("pusher_internal:member_removed", channel, string(removed)))
+	}
+	if len(p.conns) == 0 {
+		delete(s.presence, key)
+	}
+}
+
+// leaveAll removes a closed connection from all presence channels
+func (s *Server) leaveAll(c *conn) {
+	c.mu.RLock()
+	channels := make([]string, 0, len(c.channels))
+	for channel := range c.channels {
+		if IsPresence(channel) {
+			channels = append(channels, channel)
+		}
+	}
+	c.mu.RUnlock()
+
+	for _, channel := range channels {
+		s.leave(c, channel)
+	}
+}
@@ -0,0 +1,150 @@
+// Package revocation keeps a list of revoked client tokens, persisted to a local file.
+package revocation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/websocket"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Entry revokes the tokens of an endpoint matching all of its selectors until it expires
+type Entry struct {
+	Endpoint string `json:"endpoint"`
+	JTI      string `json:"jti,omitempty"`
+	Sub      string `json:"sub,omitempty"`
+	// Claim is a dot-separated path in the token payload, it matches if the claim equals or contains Value
+	Claim     string      `json:"claim,omitempty"`
+	Value     interface{} `json:"value,omitempty"`
+	ExpiresAt time.Time   `json:"expires_at"`
+}
+
+// Validate checks that the entry has an endpoint, a selector and an expiry
+func (e Entry) Validate() error {
+	switch {
+	case e.Endpoint == "":
+		return errors.New("missing endpoint")
+	case e.JTI == "" && e.Sub == "" && e.Claim == "":
+		return errors.New("missing jti, sub or claim")
+	case e.Claim != "" && e.Value == nil:
+		return errors.New("missing claim value")
+	case e.ExpiresAt.IsZero():
+		return errors.New("missing expiry")
+	}
+	return nil
+}
+
+// Matches reports whether the token payload matches all selectors of the entry
+func (e Entry) Matches(payload map[string]interface{}) bool {
+	if e.JTI != "" && payload["jti"] != e.JTI {
+		return false
+	}
+	if e.Sub != "" && payload["sub"] != e.Sub {
+		return false
+	}
+	if e.Claim != "" {
+		// A list target matches claims equal to or containing the value
+		value, ok := jwt.Lookup(payload, e.Claim)
+		if !ok || !websocket.MatchValue(value, []interface{}{e.Value}) {
+			return false
+		}
+	}
+	return true
+}
+
+// List is a revocation list, expired entries are dropped when the list is saved
+type List struct {
+	path    string
+	timeNow func() time.Time
+
+	mu      sync.RWMutex
+	entries []Entry
+}
+
+// Open loads the list from a file, the file is created on the first revocation. An empty path keeps the list in memory.
+func Open(path string) (*List, error) {
+	l := &List{path: path, timeNow: time.Now}
+	if path == "" {
+		return l, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &l.entries); err != nil {
+		return nil, fmt.Errorf("invalid revocation list %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// Revoke adds an entry and saves the list
+func (l *List) Revoke(entry Entry) error {
+	if err := entry.Validate(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.timeNow()
+	entries := []Entry{entry}
+	for _, e := range l.entries {
+		if now.Before(e.ExpiresAt) {
+			entries = append(entries, e)
+		}
+	}
+	if err := l.save(entries); err != nil {
+		return err
+	}
+	l.entries = entries
+	return nil
+}
+
+// Revoked reports whether the payload of a token of an endpoint matches an unexpired entry
+func (l *List) Revoked(endpoint string, payload map[string]interface{}) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	now := l.timeNow()
+	for _, e := range l.entries {
+		if e.Endpoint == endpoint && now.Before(e.ExpiresAt) && e.Matches(payload) {
+			return true
+		}
+	}
+	return false
+}
+
+// save writes the entries to a temporary file and renames it over the list, callers must hold mu
+func (l *List) save(entries []Entry) error {
+	if l.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.path)
+}
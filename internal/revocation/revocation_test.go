@@ -0,0 +1,120 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/make0x20/driplet/internal/jwt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestMatches verifies the selectors of entries, all set selectors must match
+func TestMatches(t *testing.T) {
+	payload := map[string]interface{}{
+		"jti": "token-1",
+		"sub": "42",
+		"custom": map[string]interface{}{
+			"roles":  []interface{}{"user", "banned"},
+			"tenant": "acme",
+		},
+	}
+
+	tests := []struct {
+		name  string
+		entry Entry
+		want  bool
+	}{
+		{"jti", Entry{JTI: "token-1"}, true},
+		{"other jti", Entry{JTI: "token-2"}, false},
+		{"sub", Entry{Sub: "42"}, true},
+		{"claim", Entry{Claim: "custom.tenant", Value: "acme"}, true},
+		{"claim list", Entry{Claim: "custom.roles", Value: "banned"}, true},
+		{"missing claim", Entry{Claim: "custom.team", Value: "acme"}, false},
+		{"sub and other claim", Entry{Sub: "42", Claim: "custom.tenant", Value: "other"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.entry.Matches(payload); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestList verifies the revocation list:
+// - Entries apply to their endpoint until they expire
+// - Invalid entries are rejected
+// - The list survives a restart through its file, expired entries are dropped
+// - Revoked tokens are rejected by the validator
+func TestList(t *testing.T) {
+	now := time.Now()
+	path := filepath.Join(t.TempDir(), "revocations.json")
+	list, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := list.Revoke(Entry{Endpoint: "web", Sub: "42", ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []Entry{
+		{Sub: "42", ExpiresAt: now.Add(time.Hour)},
+		{Endpoint: "web", ExpiresAt: now.Add(time.Hour)},
+		{Endpoint: "web", Claim: "role"},
+		{Endpoint: "web", JTI: "token-1"},
+	} {
+		if err := list.Revoke(entry); err == nil {
+			t.Errorf("expected entry %+v to be rejected", entry)
+		}
+	}
+
+	if !list.Revoked("web", map[string]interface{}{"sub": "42"}) {
+		t.Error("expected sub 42 to be revoked")
+	}
+	if list.Revoked("shop", map[string]interface{}{"sub": "42"}) {
+		t.Error("expected revocation to apply to its endpoint only")
+	}
+
+	// Expired entries are ignored and dropped when the list is saved
+	list.timeNow = func() time.Time { return now.Add(2 * time.Hour) }
+	if list.Revoked("web", map[string]interface{}{"sub": "42"}) {
+		t.Error("expected expired revocation to be ignored")
+	}
+	if err := list.Revoke(Entry{Endpoint: "web", JTI: "token-1", ExpiresAt: now.Add(3 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.entries) != 1 || reopened.entries[0].JTI != "token-1" {
+		t.Errorf("unexpected entries after reopening %+v", reopened.entries)
+	}
+
+	// The validator rejects revoked tokens
+	keys, err := jwt.NewKeys([]jwt.Key{{Key: []byte("secret")}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := jwt.NewValidator(nil, jwt.WithEndpointKeys("web", keys), jwt.WithRevoker(reopened))
+	sign := func(jti string) string {
+		signed, err := gojwt.NewWithClaims(gojwt.SigningMethodHS256, gojwt.MapClaims{
+			"jti": jti,
+			"exp": now.Add(time.Hour).Unix(),
+		}).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	if _, err := v.ValidateEndpointToken(context.Background(), sign("token-1"), "web"); !errors.Is(err, jwt.ErrTokenRevoked) {
+		t.Errorf("expected revoked token to be rejected, got %v", err)
+	}
+	if _, err := v.ValidateEndpointToken(context.Background(), sign("token-2"), "web"); err != nil {
+		t.Errorf("expected other token to be valid: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+// Package session authenticates clients by forwarding their session cookies and headers to a backend.
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/make0x20/driplet/internal/callback"
+	"net/http"
+	"time"
+)
+
+// ErrDenied is returned when the request has no session or the backend denies it
+var ErrDenied = errors.New("session denied")
+
+// Options configures a session callback
+type Options struct {
+	// URL is the backend callback
+	URL string
+	// Endpoint is the driplet endpoint sent to the backend
+	Endpoint string
+	// Secret returns the secret signing the callback like API requests, it is called for every callback
+	Secret func() string
+	// Cookies and Headers are the names forwarded from the client request
+	Cookies []string
+	Headers []string
+	Timeout time.Duration
+}
+
+// Request is the signed callback body
+type Request struct {
+	Endpoint string            `json:"endpoint"`
+	Cookies  map[string]string `json:"cookies"`
+	Headers  map[string]string `json:"headers"`
+	callback.Stamp
+}
+
+// Client calls the session callback of an endpoint
+type Client struct {
+	options  Options
+	callback *callback.Client
+}
+
+// New creates a session callback client, a zero timeout uses the default
+func New(options Options) *Client {
+	return &Client{
+		options:  options,
+		callback: callback.New("session", options.URL, options.Secret, options.Timeout),
+	}
+}
+
+// Authenticate forwards the configured cookies and headers of a client request to the backend
+// and returns its claims as a JSON object. Returns ErrDenied without calling the backend if none are present.
+func (c *Client) Authenticate(ctx context.Context, r *http.Request) (json.RawMessage, error) {
+	body := Request{
+		Endpoint: c.options.Endpoint,
+		Cookies:  make(map[string]string),
+		Headers:  make(map[string]string),
+	}
+	for _, name := range c.options.Cookies {
+		if cookie, err := r.Cookie(name); err == nil {
+			body.Cookies[name] = cookie.Value
+		}
+	}
+	for _, name := range c.options.Headers {
+		if value := r.Header.Get(name); value != "" {
+			body.Headers[name] = value
+		}
+	}
+	if len(body.Cookies) == 0 && len(body.Headers) == 0 {
+		return nil, fmt.Errorf("%w: no session cookie or header", ErrDenied)
+	}
+
+	status, claims, err := c.callback.Post(ctx, &body)
+	if err != nil {
+		return nil, err
+	}
+	switch status {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, ErrDenied
+	default:
+		return nil, fmt.Errorf("session callback failed: unexpected status %d", status)
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal(claims, &object); err != nil || object == nil {
+		return nil, fmt.Errorf("invalid session callback response, expected a JSON object of claims")
+	}
+	return claims, nil
+}
@@ -0,0 +1,79 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/make0x20/driplet/internal/callback"
+	"github.com/make0x20/driplet/internal/jwt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// TestAuthenticate verifies session callbacks:
+// - The configured cookies and headers are forwarded, others are not
+// - The callback is signed with the secret
+// - The returned claims are used for targeting, a 401 denies the client
+// - Requests without a session are denied without calling the backend
+func TestAuthenticate(t *testing.T) {
+	var requests atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Driplet-Signature") != callback.Sign("api-secret", body) {
+			t.Error("invalid callback signature")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var req Request
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Error(err)
+			return
+		}
+		if req.Endpoint != "web" || req.Nonce == "" || req.Timestamp == 0 || req.Cookies["other"] != "" {
+			t.Errorf("unexpected callback %+v", req)
+		}
+		if req.Cookies["PHPSESSID"] != "good" || req.Headers["X-Tenant"] != "acme" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"sub":"42","roles":["admin"]}`))
+	}))
+	defer backend.Close()
+
+	client := New(Options{URL: backend.URL, Endpoint: "web", Secret: func() string { return "api-secret" }, Cookies: []string{"PHPSESSID"}, Headers: []string{"X-Tenant"}})
+	v := jwt.NewValidator(nil, jwt.WithEndpointRequestAuthenticator("web", client))
+
+	request := func(session string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/ws/web", nil)
+		r.Header.Set("X-Tenant", "acme")
+		r.AddCookie(&http.Cookie{Name: "other", Value: "secret"})
+		if session != "" {
+			r.AddCookie(&http.Cookie{Name: "PHPSESSID", Value: session})
+		}
+		return r
+	}
+
+	claims, err := v.ValidateEndpointRequest(request("good"), "", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles, ok := claims.GetCustomClaim("roles"); !ok || roles.([]interface{})[0] != "admin" || claims.Subject != "42" {
+		t.Errorf("unexpected claims %+v", claims.Raw)
+	}
+
+	if _, err := v.ValidateEndpointRequest(request("bad"), "", "web"); !errors.Is(err, ErrDenied) {
+		t.Errorf("expected denied session, got %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/ws/web", nil)
+	if _, err := client.Authenticate(r.Context(), r); !errors.Is(err, ErrDenied) {
+		t.Errorf("expected request without session to be denied, got %v", err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("expected 2 callbacks, got %d", n)
+	}
+}
@@ -0,0 +1,146 @@
+package sse
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+)
+
+// HTML fragment formats of published messages
+const (
+	// FormatTurboStream sends messages as <turbo-stream> elements for Hotwire Turbo stream sources
+	FormatTurboStream = "turbo-stream"
+	// FormatHTMX sends messages as named events for the htmx SSE extension
+	FormatHTMX = "htmx"
+)
+
+// TurboStream is a single Turbo Stream action
+type TurboStream struct {
+	Action string `json:"action"`
+	// Target is the id of the target element
+	Target string `json:"target,omitempty"`
+	// Targets is a CSS selector of the target elements
+	Targets string `json:"targets,omitempty"`
+	// HTML is the content of the template
+	HTML string `json:"html,omitempty"`
+	// Attributes are additional attributes of the element, e.g. method for morphing
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Fragment renders a message published with an HTML fragment format as an event, name is the optional event name of the message
+func Fragment(format, name string, message json.RawMessage) (Event, error) {
+	switch format {
+	case FormatTurboStream:
+		data, err := turboStreams(message)
+		if err != nil {
+			return Event{}, err
+		}
+		// Turbo only listens to unnamed message events
+		return Event{Data: data}, nil
+
+	case FormatHTMX:
+		var fragment string
+		if err := json.Unmarshal(message, &fragment); err != nil {
+			return Event{}, fmt.Errorf("htmx messages must be an HTML string")
+		}
+		if name == "" {
+			name = "message"
+		}
+		return Event{Event: name, Data: []byte(fragment)}, nil
+
+	default:
+		return Event{}, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// turboStreams renders a Turbo Stream action, a list of actions or passes a pre-rendered string through
+func turboStreams(message json.RawMessage) ([]byte, error) {
+	var rendered string
+	if err := json.Unmarshal(message, &rendered); err == nil {
+		if !strings.HasPrefix(strings.TrimSpace(rendered), "<turbo-stream") {
+			return nil, fmt.Errorf("turbo-stream strings must contain rendered <turbo-stream> elements")
+		}
+		return []byte(rendered), nil
+	}
+
+	var streams []TurboStream
+	if strings.HasPrefix(strings.TrimSpace(string(message)), "[") {
+		if err := json.Unmarshal(message, &streams); err != nil {
+			return nil, fmt.Errorf("invalid turbo-stream actions: %w", err)
+		}
+	} else {
+		var stream TurboStream
+		if err := json.Unmarshal(message, &stream); err != nil {
+			return nil, fmt.Errorf("invalid turbo-stream action: %w", err)
+		}
+		streams = append(streams, stream)
+	}
+	if len(streams) == 0 {
+		return nil, fmt.Errorf("turbo-stream messages need at least one action")
+	}
+
+	var b strings.Builder
+	for i, stream := range streams {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		if err := stream.render(&b); err != nil {
+			return nil, err
+		}
+	}
+	return []byte(b.String()), nil
+}
+
+// render writes the <turbo-stream> element of the action
+func (s TurboStream) render(b *strings.Builder) error {
+	if s.Action == "" {
+		return fmt.Errorf("turbo-stream action is required")
+	}
+	if s.Target == "" && s.Targets == "" && s.Action != "refresh" {
+		return fmt.Errorf("turbo-stream %s action needs a target or targets", s.Action)
+	}
+
+	b.WriteString(`<turbo-stream action="` + html.EscapeString(s.Action) + `"`)
+	if s.Target != "" {
+		b.WriteString(` target="` + html.EscapeString(s.Target) + `"`)
+	}
+	if s.Targets != "" {
+		b.WriteString(` targets="` + html.EscapeString(s.Targets) + `"`)
+	}
+
+	names := make([]string, 0, len(s.Attributes))
+	for name := range s.Attributes {
+		if !validAttribute(name) {
+			return fmt.Errorf("invalid turbo-stream attribute name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString(` ` + name + `="` + html.EscapeString(s.Attributes[name]) + `"`)
+	}
+	b.WriteByte('>')
+
+	// Removing and refreshing take no content
+	if s.Action != "remove" && s.Action != "refresh" {
+		b.WriteString("<template>" + s.HTML + "</template>")
+	}
+	b.WriteString("</turbo-stream>")
+	return nil
+}
+
+// validAttribute reports whether an attribute name is safe to write, the element attributes can not be overridden
+func validAttribute(name string) bool {
+	switch name {
+	case "", "action", "target", "targets":
+		return false
+	}
+	for _, c := range name {
+		if !(c == '-' || c == '_' || c == ':' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package sse
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestFragment verifies HTML fragment rendering:
+// - Turbo Stream actions are framed as escaped <turbo-stream> elements
+// - Lists of actions are sent in a single event
+// - Pre-rendered Turbo Streams pass through
+// - htmx fragments are sent as named events
+func TestFragment(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		event     string
+		message   string
+		wantEvent string
+		wantData  string
+	}{
+		{
+			name:     "turbo-stream append",
+			format:   FormatTurboStream,
+			message:  `{"action":"append","target":"messages","html":"<div id=\"m1\">hi</div>"}`,
+			wantData: `<turbo-stream action="append" target="messages"><template><div id="m1">hi</div></template></turbo-stream>`,
+		},
+		{
+			name:     "turbo-stream remove with escaped targets",
+			format:   FormatTurboStream,
+			message:  `{"action":"remove","targets":"li[data-id=\"5\"]"}`,
+			wantData: `<turbo-stream action="remove" targets="li[data-id=&#34;5&#34;]"></turbo-stream>`,
+		},
+		{
+			name:     "turbo-stream list with attributes",
+			format:   FormatTurboStream,
+			message:  `[{"action":"replace","target":"a","html":"<p>a</p>","attributes":{"method":"morph"}},{"action":"refresh","attributes":{"request-id":"r1"}}]`,
+			wantData: "<turbo-stream action=\"replace\" target=\"a\" method=\"morph\"><template><p>a</p></template></turbo-stream>\n<turbo-stream action=\"refresh\" request-id=\"r1\"></turbo-stream>",
+		},
+		{
+			name:     "pre-rendered turbo-stream",
+			format:   FormatTurboStream,
+			message:  `"<turbo-stream action=\"update\" target=\"count\"><template>5</template></turbo-stream>"`,
+			wantData: `<turbo-stream action="update" target="count"><template>5</template></turbo-stream>`,
+		},
+		{
+			name:      "htmx default event",
+			format:    FormatHTMX,
+			message:   `"<li>one</li>\n<li>two</li>"`,
+			wantEvent: "message",
+			wantData:  "<li>one</li>\n<li>two</li>",
+		},
+		{
+			name:      "htmx named event",
+			format:    FormatHTMX,
+			event:     "chat",
+			message:   `"<p>hi</p>"`,
+			wantEvent: "chat",
+			wantData:  "<p>hi</p>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := Fragment(tt.format, tt.event, json.RawMessage(tt.message))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if event.Event != tt.wantEvent {
+				t.Errorf("got event %q, want %q", event.Event, tt.wantEvent)
+			}
+			if string(event.Data) != tt.wantData {
+				t.Errorf("got data %q, want %q", event.Data, tt.wantData)
+			}
+		})
+	}
+}
+
+// TestFragmentErrors verifies that messages that can not be rendered are rejected
+func TestFragmentErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		message string
+	}{
+		{"unknown format", "xml", `"<p>hi</p>"`},
+		{"turbo-stream without action", FormatTurboStream, `{"target":"a","html":"<p>a</p>"}`},
+		{"turbo-stream without target", FormatTurboStream, `{"action":"append","html":"<p>a</p>"}`},
+		{"turbo-stream empty list", FormatTurboStream, `[]`},
+		{"turbo-stream plain string", FormatTurboStream, `"<p>a</p>"`},
+		{"turbo-stream invalid attribute", FormatTurboStream, `{"action":"append","target":"a","attributes":{"onload x":"y"}}`},
+		{"turbo-stream overridden attribute", FormatTurboStream, `{"action":"append","target":"a","attributes":{"target":"b"}}`},
+		{"htmx object", FormatHTMX, `{"html":"<p>a</p>"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Fragment(tt.format, "", json.RawMessage(tt.message)); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Package sse implements writing Server-Sent Events streams.
+package sse
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Event is a single server-sent event
+type Event struct {
+	ID    string
+	Event string
+	Data  []byte
+	Retry int
+}
+
+// Writer writes events to a text/event-stream response
+type Writer struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// NewWriter sets the event stream headers and returns a Writer for the response
+func NewWriter(w http.ResponseWriter) (*Writer, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("response does not support flushing")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &Writer{w: w, flusher: flusher}, nil
+}
+
+// WriteEvent writes and flushes an event
+func (s *Writer) WriteEvent(e Event) error {
+	var buf bytes.Buffer
+	if e.ID != "" {
+		buf.WriteString("id: " + sanitize(e.ID) + "\n")
+	}
+	if e.Event != "" {
+		buf.WriteString("event: " + sanitize(e.Event) + "\n")
+	}
+	if e.Retry > 0 {
+		buf.WriteString("retry: " + strconv.Itoa(e.Retry) + "\n")
+	}
+
+	// Every line of the data gets its own data field
+	data := strings.ReplaceAll(string(e.Data), "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+
+	if _, err := s.w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// WriteComment writes and flushes a comment, used as a heartbeat
+func (s *Writer) WriteComment(comment string) error {
+	if _, err := fmt.Fprintf(s.w, ": %s\n\n", sanitize(comment)); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// EventID encodes the last sequence of every topic of a stream as an event id
+func EventID(sequences map[string]uint64) string {
+	topics := make([]string, 0, len(sequences))
+	for topic := range sequences {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	values := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		values = append(values, url.QueryEscape(topic)+"="+strconv.FormatUint(sequences[topic], 10))
+	}
+	return strings.Join(values, "&")
+}
+
+// ParseEventID decodes an event id created with EventID, invalid entries are ignored
+func ParseEventID(id string) map[string]uint64 {
+	sequences := make(map[string]uint64)
+	values, err := url.ParseQuery(id)
+	if err != nil {
+		return sequences
+	}
+	for topic, v := range values {
+		if len(v) == 0 {
+			continue
+		}
+		if seq, err := strconv.ParseUint(v[0], 10, 64); err == nil {
+			sequences[topic] = seq
+		}
+	}
+	return sequences
+}
+
+// sanitize removes line breaks from single line fields
+func sanitize(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
@@ -0,0 +1,48 @@
+package sse
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// TestWriteEvent verifies event framing:
+// - id and event fields
+// - multi-line data split into data fields
+// - line breaks stripped from single line fields
+func TestWriteEvent(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w, err := NewWriter(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.WriteEvent(Event{ID: "a=1", Event: "up\ndate", Data: []byte("line1\nline2")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteComment("heartbeat"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "id: a=1\nevent: update\ndata: line1\ndata: line2\n\n: heartbeat\n\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("got content type %q", ct)
+	}
+}
+
+// TestEventID verifies that event ids round trip, including topics with reserved characters
+func TestEventID(t *testing.T) {
+	sequences := map[string]uint64{"orders": 5, "chat/room&1": 12}
+
+	id := EventID(sequences)
+	got := ParseEventID(id)
+	if len(got) != 2 || got["orders"] != 5 || got["chat/room&1"] != 12 {
+		t.Errorf("round trip of %q got %v", id, got)
+	}
+
+	if got := ParseEventID("garbage;%zz"); len(got) != 0 {
+		t.Errorf("expected invalid id to be ignored, got %v", got)
+	}
+}
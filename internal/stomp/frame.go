@@ -0,0 +1,229 @@
+// Package stomp implements STOMP 1.2 over WebSocket, mapping destinations onto hub topics.
+package stomp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Limits of frames sent by clients
+const (
+	MaxHeaders    = 64
+	MaxHeaderLine = 8 * 1024
+	MaxBodySize   = 256 * 1024
+)
+
+// ErrMalformed is returned for frames violating the STOMP 1.2 format
+var ErrMalformed = errors.New("malformed frame")
+
+// Header is a single frame header
+type Header struct {
+	Key   string
+	Value string
+}
+
+// Frame is a STOMP frame
+type Frame struct {
+	Command string
+	Headers []Header
+	Body    []byte
+}
+
+// NewFrame creates a frame with headers given as key value pairs
+func NewFrame(command string, headers ...string) *Frame {
+	f := &Frame{Command: command}
+	for i := 0; i+1 < len(headers); i += 2 {
+		f.Headers = append(f.Headers, Header{Key: headers[i], Value: headers[i+1]})
+	}
+	return f
+}
+
+// Get returns the first value of a header, repeated headers are ignored as required by the spec
+func (f *Frame) Get(key string) (string, bool) {
+	for _, h := range f.Headers {
+		if h.Key == key {
+			return h.Value, true
+		}
+	}
+	return "", false
+}
+
+// Value returns the first value of a header or an empty string
+func (f *Frame) Value(key string) string {
+	value, _ := f.Get(key)
+	return value
+}
+
+// Set adds a header
+func (f *Frame) Set(key, value string) {
+	f.Headers = append(f.Headers, Header{Key: key, Value: value})
+}
+
+// escaped reports whether header values of the frame are escaped, CONNECT and CONNECTED are not
+func escaped(command string) bool {
+	return command != "CONNECT" && command != "CONNECTED"
+}
+
+// Encode encodes the frame, a content-length header is added for non-empty bodies
+func (f *Frame) Encode() []byte {
+	var b bytes.Buffer
+	b.WriteString(f.Command)
+	b.WriteByte('\n')
+	for _, h := range f.Headers {
+		if escaped(f.Command) {
+			b.WriteString(escape(h.Key))
+			b.WriteByte(':')
+			b.WriteString(escape(h.Value))
+		} else {
+			b.WriteString(h.Key)
+			b.WriteByte(':')
+			b.WriteString(h.Value)
+		}
+		b.WriteByte('\n')
+	}
+	if _, ok := f.Get("content-length"); !ok && len(f.Body) > 0 {
+		b.WriteString("content-length:")
+		b.WriteString(strconv.Itoa(len(f.Body)))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	b.Write(f.Body)
+	b.WriteByte(0)
+	return b.Bytes()
+}
+
+// ReadFrame reads a single frame, a heart-beat end of line between frames returns a nil frame
+func ReadFrame(r *bufio.Reader) (*Frame, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, nil
+	}
+
+	f := &Frame{Command: line}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+		if len(f.Headers) >= MaxHeaders {
+			return nil, fmt.Errorf("%w: too many headers", ErrMalformed)
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: header without colon", ErrMalformed)
+		}
+		if escaped(f.Command) {
+			if key, err = unescape(key); err != nil {
+				return nil, err
+			}
+			if value, err = unescape(value); err != nil {
+				return nil, err
+			}
+		}
+		f.Headers = append(f.Headers, Header{Key: key, Value: value})
+	}
+
+	if length, ok := f.Get("content-length"); ok {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%w: invalid content-length", ErrMalformed)
+		}
+		if n > MaxBodySize {
+			return nil, fmt.Errorf("%w: body exceeds %d bytes", ErrMalformed, MaxBodySize)
+		}
+		f.Body = make([]byte, n)
+		if _, err := io.ReadFull(r, f.Body); err != nil {
+			return nil, err
+		}
+		if terminator, err := r.ReadByte(); err != nil {
+			return nil, err
+		} else if terminator != 0 {
+			return nil, fmt.Errorf("%w: body not terminated by NUL", ErrMalformed)
+		}
+		return f, nil
+	}
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return f, nil
+		}
+		if len(f.Body) >= MaxBodySize {
+			return nil, fmt.Errorf("%w: body exceeds %d bytes", ErrMalformed, MaxBodySize)
+		}
+		f.Body = append(f.Body, b)
+	}
+}
+
+// readLine reads a line ending in LF or CRLF
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == '\n' {
+			break
+		}
+		if len(line) >= MaxHeaderLine {
+			return "", fmt.Errorf("%w: line exceeds %d bytes", ErrMalformed, MaxHeaderLine)
+		}
+		line = append(line, b)
+	}
+	return strings.TrimSuffix(string(line), "\r"), nil
+}
+
+var escaper = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+
+// escape escapes a header key or value
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// unescape unescapes a header key or value, undefined escape sequences are malformed
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 == len(s) {
+			return "", fmt.Errorf("%w: incomplete escape sequence", ErrMalformed)
+		}
+		i++
+		switch s[i] {
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		case 'c':
+			b.WriteByte(':')
+		case '\\':
+			b.WriteByte('\\')
+		default:
+			return "", fmt.Errorf("%w: undefined escape sequence \\%c", ErrMalformed, s[i])
+		}
+	}
+	return b.String(), nil
+}
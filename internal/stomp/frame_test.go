@@ -0,0 +1,145 @@
+package stomp
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func read(t *testing.T, input string) (*Frame, error) {
+	t.Helper()
+	return ReadFrame(bufio.NewReader(strings.NewReader(input)))
+}
+
+func TestReadFrame(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected *Frame
+	}{
+		{
+			name:  "CONNECT headers are not unescaped",
+			input: "CONNECT\naccept-version:1.2\nhost:stomp.example.com\npasscode:a\\cb\n\n\x00",
+			expected: NewFrame("CONNECT",
+				"accept-version", "1.2",
+				"host", "stomp.example.com",
+				"passcode", "a\\cb",
+			),
+		},
+		{
+			name:     "SEND with CRLF line endings",
+			input:    "SEND\r\ndestination:/queue/a\r\n\r\nhello queue a\x00",
+			expected: &Frame{Command: "SEND", Headers: []Header{{"destination", "/queue/a"}}, Body: []byte("hello queue a")},
+		},
+		{
+			name:  "SEND with content-length containing NUL",
+			input: "SEND\ndestination:/queue/a\ncontent-length:3\n\na\x00b\x00",
+			expected: &Frame{Command: "SEND", Headers: []Header{
+				{"destination", "/queue/a"},
+				{"content-length", "3"},
+			}, Body: []byte("a\x00b")},
+		},
+		{
+			name:  "escaped header values",
+			input: "SUBSCRIBE\nid:0\ndestination:a\\cb\\nc\\\\d\n\n\x00",
+			expected: NewFrame("SUBSCRIBE",
+				"id", "0",
+				"destination", "a:b\nc\\d",
+			),
+		},
+		{
+			name:  "repeated headers keep every entry",
+			input: "SEND\ndestination:first\ndestination:second\n\n\x00",
+			expected: NewFrame("SEND",
+				"destination", "first",
+				"destination", "second",
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := read(t, tt.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(frame, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, frame)
+			}
+		})
+	}
+}
+
+func TestRepeatedHeaderFirstWins(t *testing.T) {
+	frame, err := read(t, "SEND\ndestination:first\ndestination:second\n\n\x00")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if destination := frame.Value("destination"); destination != "first" {
+		t.Errorf("expected first, got %s", destination)
+	}
+}
+
+func TestHeartBeat(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("\n\r\nSEND\ndestination:a\n\n\x00"))
+
+	for i := 0; i < 2; i++ {
+		frame, err := ReadFrame(r)
+		if err != nil || frame != nil {
+			t.Fatalf("expected heart-beat, got %+v, %v", frame, err)
+		}
+	}
+	frame, err := ReadFrame(r)
+	if err != nil || frame.Command != "SEND" {
+		t.Fatalf("expected SEND frame, got %+v, %v", frame, err)
+	}
+}
+
+func TestMalformedFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"header without colon", "SEND\ndestination\n\n\x00"},
+		{"undefined escape sequence", "SEND\ndestination:a\\tb\n\n\x00"},
+		{"invalid content-length", "SEND\ncontent-length:x\n\n\x00"},
+		{"body longer than content-length", "SEND\ncontent-length:1\n\nab\x00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := read(t, tt.input); !errors.Is(err, ErrMalformed) {
+				t.Errorf("expected malformed frame error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	frame := NewFrame("MESSAGE",
+		"subscription", "0",
+		"destination", "a:b",
+	)
+	frame.Body = []byte("hello")
+
+	expected := "MESSAGE\nsubscription:0\ndestination:a\\cb\ncontent-length:5\n\nhello\x00"
+	if encoded := string(frame.Encode()); encoded != expected {
+		t.Errorf("expected %q, got %q", expected, encoded)
+	}
+
+	connected := NewFrame("CONNECTED", "version", "1.2", "server", "a:b")
+	expected = "CONNECTED\nversion:1.2\nserver:a:b\n\n\x00"
+	if encoded := string(connected.Encode()); encoded != expected {
+		t.Errorf("expected %q, got %q", expected, encoded)
+	}
+
+	decoded, err := read(t, string(frame.Encode()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decoded.Value("destination") != "a:b" || string(decoded.Body) != "hello" {
+		t.Errorf("expected frame to round trip, got %+v", decoded)
+	}
+}
@@ -0,0 +1,473 @@
+package stomp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	gorilla "github.com/gorilla/websocket"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/websocket"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Subprotocol is the WebSocket subprotocol of STOMP 1.2 connections
+	Subprotocol = "v12.stomp"
+	// connectTimeout is the time allowed to send CONNECT after the connection is opened
+	connectTimeout = 10 * time.Second
+	// writeWait is the time allowed to write a frame to the client
+	writeWait = 10 * time.Second
+)
+
+// Authenticator validates the JWT sent in the CONNECT headers
+type Authenticator func(token string) (*jwt.Claims, error)
+
+// Server runs STOMP connections on top of the hub
+type Server struct {
+	logger *slog.Logger
+	hub    *websocket.Hub
+}
+
+// subscription is a STOMP subscription to a destination
+type subscription struct {
+	destination string
+	ack         string
+}
+
+// conn is a single STOMP connection
+type conn struct {
+	server   *Server
+	endpoint string
+	ws       *gorilla.Conn
+	client   *websocket.Client
+	// send holds the destinations the client may send to
+	send []string
+	// heartbeat is the interval of heart-beats sent to the client, zero if disabled
+	heartbeat time.Duration
+	out       *websocket.Outbox
+	mu        sync.RWMutex
+	subs      map[string]*subscription
+	acks      uint64
+}
+
+// NewServer creates a new STOMP server
+func NewServer(logger *slog.Logger, hub *websocket.Hub) *Server {
+	return &Server{
+		logger: logger,
+		hub:    hub,
+	}
+}
+
+// Serve runs a STOMP connection until it is closed
+func (s *Server) Serve(ws *gorilla.Conn, endpoint string, authenticate Authenticator) {
+	defer ws.Close()
+
+	r := bufio.NewReader(websocket.NewStreamReader(ws, 0))
+	ws.SetReadDeadline(time.Now().Add(connectTimeout))
+	var connect *Frame
+	for connect == nil {
+		var err error
+		if connect, err = ReadFrame(r); err != nil {
+			s.logger.Debug("Could not read STOMP CONNECT", "endpoint", endpoint, "error", err)
+			return
+		}
+	}
+
+	c, connected, failure := s.connect(ws, endpoint, connect, authenticate)
+	if failure != nil {
+		writeRaw(ws, failure.Encode())
+		return
+	}
+	if err := writeRaw(ws, connected.Encode()); err != nil {
+		c.client.Detach()
+		return
+	}
+	defer c.client.Detach()
+
+	// Heart-beats from the client are expected at the negotiated interval, WebSocket pongs also count
+	cx, _ := parseHeartBeat(connect.Value("heart-beat"))
+	readTimeout := 2 * max(s.hub.PingInterval(), time.Duration(cx)*time.Millisecond)
+
+	go c.writePump()
+	if c.readPump(r, readTimeout) {
+		// Give the write pump time to flush the final ERROR or RECEIPT frame
+		c.out.Wait(writeWait)
+	}
+	c.out.Stop()
+}
+
+// connect validates a CONNECT frame and attaches the client, returning CONNECTED or an ERROR frame
+func (s *Server) connect(ws *gorilla.Conn, endpoint string, connect *Frame, authenticate Authenticator) (*conn, *Frame, *Frame) {
+	if connect.Command != "CONNECT" && connect.Command != "STOMP" {
+		return nil, nil, errorFrame(connect, "Expected CONNECT frame", "")
+	}
+
+	versions := strings.Split(connect.Value("accept-version"), ",")
+	if !slices.Contains(versions, "1.2") {
+		failure := errorFrame(connect, "Supported protocol versions are 1.2", "")
+		failure.Set("version", "1.2")
+		return nil, nil, failure
+	}
+
+	token := connect.Value("passcode")
+	if authorization := connect.Value("Authorization"); token == "" && strings.HasPrefix(authorization, "Bearer ") {
+		token = strings.TrimPrefix(authorization, "Bearer ")
+	}
+	if token == "" {
+		s.logger.Debug("Missing STOMP token", "endpoint", endpoint)
+		return nil, nil, errorFrame(connect, "Missing token", "Send the JWT in the passcode or Authorization header")
+	}
+	claims, err := authenticate(token)
+	if err != nil {
+		s.logger.Debug("Invalid token", "endpoint", endpoint, "error", err)
+		return nil, nil, errorFrame(connect, "Invalid token", "")
+	}
+
+	// Negotiate heart-beats, the server can send and wants to receive at the ping interval
+	interval := int(s.hub.PingInterval() / time.Millisecond)
+	_, cy := parseHeartBeat(connect.Value("heart-beat"))
+	c := &conn{
+		server:   s,
+		endpoint: endpoint,
+		ws:       ws,
+		send:     SendDestinations(claims.Raw),
+		out:      websocket.NewOutbox(64),
+		subs:     make(map[string]*subscription),
+	}
+	if cy > 0 {
+		c.heartbeat = time.Duration(max(interval, cy)) * time.Millisecond
+	}
+	c.client = s.hub.Attach(endpoint, claims)
+
+	connected := NewFrame("CONNECTED",
+		"version", "1.2",
+		"heart-beat", fmt.Sprintf("%d,%d", interval, interval),
+		"server", "driplet",
+	)
+	return c, connected, nil
+}
+
+// parseHeartBeat parses a heart-beat header, invalid values disable heart-beats
+func parseHeartBeat(header string) (int, int) {
+	x, y, ok := strings.Cut(header, ",")
+	if !ok {
+		return 0, 0
+	}
+	cx, err1 := strconv.Atoi(strings.TrimSpace(x))
+	cy, err2 := strconv.Atoi(strings.TrimSpace(y))
+	if err1 != nil || err2 != nil || cx < 0 || cy < 0 {
+		return 0, 0
+	}
+	return cx, cy
+}
+
+// errorFrame creates an ERROR frame for a client frame, echoing its receipt
+func errorFrame(frame *Frame, message, detail string) *Frame {
+	failure := NewFrame("ERROR", "message", message)
+	if frame != nil {
+		if receipt, ok := frame.Get("receipt"); ok {
+			failure.Set("receipt-id", receipt)
+		}
+	}
+	if detail != "" {
+		failure.Set("content-type", "text/plain")
+		failure.Body = []byte(detail)
+	}
+	return failure
+}
+
+// writeRaw writes a frame before the write pump is started
+func writeRaw(ws *gorilla.Conn, frame []byte) error {
+	ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return ws.WriteMessage(gorilla.TextMessage, frame)
+}
+
+// SendDestinations returns the destinations of the stomp.send claim
+func SendDestinations(payload map[string]interface{}) []string {
+	claim, ok := payload["stomp"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	list, ok := claim["send"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	destinations := make([]string, 0, len(list))
+	for _, item := range list {
+		if destination, ok := item.(string); ok && destination != "" {
+			destinations = append(destinations, destination)
+		}
+	}
+	return destinations
+}
+
+// maySend reports whether the client may send to a destination, a trailing * matches any suffix
+func (c *conn) maySend(destination string) bool {
+	for _, allowed := range c.send {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok {
+			if strings.HasPrefix(destination, prefix) {
+				return true
+			}
+		} else if allowed == destination {
+			return true
+		}
+	}
+	return false
+}
+
+// fail queues an ERROR frame followed by closing the connection
+func (c *conn) fail(frame *Frame, message, detail string) {
+	c.server.logger.Debug("STOMP error", "endpoint", c.endpoint, "message", message, "detail", detail)
+	c.out.Queue(errorFrame(frame, message, detail).Encode())
+	c.out.QueueClose(gorilla.FormatCloseMessage(gorilla.CloseNormalClosure, ""))
+}
+
+// receipt queues a RECEIPT frame if the client frame requested one
+func (c *conn) receipt(frame *Frame) {
+	if receipt, ok := frame.Get("receipt"); ok {
+		c.out.Queue(NewFrame("RECEIPT", "receipt-id", receipt).Encode())
+	}
+}
+
+// readPump handles frames sent by the client, returns true if the connection is closing after a final frame
+func (c *conn) readPump(r *bufio.Reader, timeout time.Duration) bool {
+	c.ws.SetReadDeadline(time.Now().Add(timeout))
+	c.ws.SetPongHandler(func(string) error {
+		c.ws.SetReadDeadline(time.Now().Add(timeout))
+		return nil
+	})
+
+	for {
+		frame, err := ReadFrame(r)
+		if errors.Is(err, ErrMalformed) {
+			c.fail(nil, "Malformed frame", err.Error())
+			return true
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !gorilla.IsCloseError(err, gorilla.CloseNormalClosure, gorilla.CloseGoingAway) {
+				c.server.logger.Debug("STOMP connection closed", "endpoint", c.endpoint, "error", err)
+			}
+			return false
+		}
+		c.ws.SetReadDeadline(time.Now().Add(timeout))
+
+		// Heart-beat
+		if frame == nil {
+			continue
+		}
+
+		switch frame.Command {
+		case "SUBSCRIBE":
+			if !c.subscribe(frame) {
+				return true
+			}
+
+		case "UNSUBSCRIBE":
+			id, ok := frame.Get("id")
+			if !ok {
+				c.fail(frame, "Missing id header", "")
+				return true
+			}
+			c.unsubscribe(id)
+
+		case "SEND":
+			if !c.publish(frame) {
+				return true
+			}
+
+		case "ACK", "NACK":
+			// Messages are not redelivered, acknowledgements only need a valid id
+			if _, ok := frame.Get("id"); !ok {
+				c.fail(frame, "Missing id header", "")
+				return true
+			}
+
+		case "DISCONNECT":
+			c.receipt(frame)
+			c.out.QueueClose(gorilla.FormatCloseMessage(gorilla.CloseNormalClosure, ""))
+			return true
+
+		case "BEGIN", "COMMIT", "ABORT":
+			c.fail(frame, "Transactions are not supported", "")
+			return true
+
+		default:
+			c.fail(frame, "Unsupported frame", frame.Command)
+			return true
+		}
+
+		c.receipt(frame)
+	}
+}
+
+// subscribe subscribes the client to a destination, returns false after an ERROR frame
+func (c *conn) subscribe(frame *Frame) bool {
+	id, hasID := frame.Get("id")
+	destination := frame.Value("destination")
+	if !hasID || destination == "" {
+		c.fail(frame, "Missing id or destination header", "")
+		return false
+	}
+
+	ack := frame.Value("ack")
+	switch ack {
+	case "":
+		ack = "auto"
+	case "auto", "client", "client-individual":
+	default:
+		c.fail(frame, "Invalid ack header", ack)
+		return false
+	}
+
+	c.mu.Lock()
+	if _, exists := c.subs[id]; exists {
+		c.mu.Unlock()
+		c.fail(frame, "Subscription id already in use", id)
+		return false
+	}
+	c.subs[id] = &subscription{destination: destination, ack: ack}
+	c.mu.Unlock()
+
+	if err := c.client.Subscribe(websocket.SubscriptionMessage{
+		Type:  websocket.MessageTypeSubscribe,
+		Topic: destination,
+	}); err != nil {
+		c.fail(frame, "Invalid destination", err.Error())
+		return false
+	}
+	return true
+}
+
+// unsubscribe removes a subscription, the hub subscription is kept while other subscriptions use it
+func (c *conn) unsubscribe(id string) {
+	c.mu.Lock()
+	sub, ok := c.subs[id]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.subs, id)
+	for _, other := range c.subs {
+		if other.destination == sub.destination {
+			c.mu.Unlock()
+			return
+		}
+	}
+	c.mu.Unlock()
+
+	c.client.Unsubscribe(sub.destination)
+}
+
+// publish publishes a SEND frame to the hub, returns false after an ERROR frame
+func (c *conn) publish(frame *Frame) bool {
+	destination := frame.Value("destination")
+	if destination == "" {
+		c.fail(frame, "Missing destination header", "")
+		return false
+	}
+	if _, ok := frame.Get("transaction"); ok {
+		c.fail(frame, "Transactions are not supported", "")
+		return false
+	}
+	if !c.maySend(destination) {
+		c.fail(frame, "Not allowed to send to destination", destination)
+		return false
+	}
+
+	if err := c.server.hub.Broadcast(websocket.BroadcastMessage{
+		Message:  websocket.EncodePayload(frame.Body),
+		Endpoint: c.endpoint,
+		Topic:    destination,
+	}); err != nil {
+		c.fail(frame, "Could not send message", err.Error())
+		return false
+	}
+	return true
+}
+
+// messages creates a MESSAGE frame for every subscription to a destination of a hub message
+func (c *conn) messages(msg *websocket.BroadcastMessage) [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// JSON strings are sent as plain text
+	contentType := "application/json"
+	body, text := websocket.DecodePayload(msg.Message)
+	if text {
+		contentType = "text/plain"
+	}
+	var frames [][]byte
+	for _, topic := range msg.AllTopics() {
+		for id, sub := range c.subs {
+			if sub.destination != topic {
+				continue
+			}
+
+			messageID := msg.ID
+			if messageID == "" {
+				messageID = fmt.Sprintf("%s:%d", msg.Topic, msg.Sequence)
+			}
+			frame := NewFrame("MESSAGE",
+				"subscription", id,
+				"message-id", messageID,
+				"destination", topic,
+				"content-type", contentType,
+			)
+			if sub.ack != "auto" {
+				c.acks++
+				frame.Set("ack", strconv.FormatUint(c.acks, 10))
+			}
+			frame.Body = body
+			frames = append(frames, frame.Encode())
+		}
+	}
+	return frames
+}
+
+// writePump sends hub deliveries, queued frames and heart-beats to the client
+func (c *conn) writePump() {
+	websocket.Pump{
+		Outbox: c.out,
+		Client: c.client,
+		Conn:   c.ws,
+		Write:  c.write,
+		WriteClose: func(message []byte) {
+			c.ws.WriteControl(gorilla.CloseMessage, message, time.Now().Add(writeWait))
+		},
+		Deliver: func(delivery websocket.Delivery) error {
+			if delivery.Message == nil {
+				return nil
+			}
+			for _, frame := range c.messages(delivery.Message) {
+				if err := c.write(frame); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Closed: func(reason websocket.CloseReason) {
+			c.write(errorFrame(nil, reason.Reason, "").Encode())
+			c.ws.WriteControl(gorilla.CloseMessage, reason.Message(), time.Now().Add(writeWait))
+		},
+		Ping: websocket.PingTick(c.ws, c.server.hub.PingInterval(), writeWait),
+		Heartbeat: websocket.Tick{
+			Interval: c.heartbeat,
+			Write: func() error {
+				return c.write([]byte("\n"))
+			},
+		},
+	}.Run()
+}
+
+// write writes a single frame to the client
+func (c *conn) write(frame []byte) error {
+	c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.ws.WriteMessage(gorilla.TextMessage, frame)
+}
@@ -0,0 +1,278 @@
+// Package tcp serves hub topics over raw TCP connections speaking newline-delimited JSON.
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/websocket"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxLineSize is the maximum size of a line sent by a client
+	MaxLineSize = 64 * 1024
+	// authTimeout is the time allowed to send the auth line after the connection is opened
+	authTimeout = 10 * time.Second
+	// writeWait is the time allowed to write a line to the client
+	writeWait = 10 * time.Second
+)
+
+const (
+	MessageTypeAuth          = "auth"
+	MessageTypeAuthenticated = "authenticated"
+	MessageTypeClose         = "close"
+)
+
+// Authenticator validates the JWT of the auth line for an endpoint, callbacks it makes are cancelled with ctx
+type Authenticator func(ctx context.Context, endpoint, token string) (*jwt.Claims, error)
+
+// AuthMessage is the first line sent by a client
+type AuthMessage struct {
+	Type     string `json:"type"`
+	Endpoint string `json:"endpoint"`
+	Token    string `json:"token"`
+}
+
+// AuthenticatedMessage confirms the auth line, the client can subscribe afterwards
+type AuthenticatedMessage struct {
+	Type     string `json:"type"`
+	Endpoint string `json:"endpoint"`
+}
+
+// CloseMessage is the last line sent before the server closes the connection
+type CloseMessage struct {
+	Type string `json:"type"`
+	websocket.CloseHint
+}
+
+// Server accepts TCP connections and attaches them to the hub
+type Server struct {
+	logger       *slog.Logger
+	hub          *websocket.Hub
+	authenticate Authenticator
+	// ctx is cancelled by Close to abort pending authentications
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	listener net.Listener
+	closed   bool
+}
+
+// conn is a single TCP connection
+type conn struct {
+	server   *Server
+	endpoint string
+	nc       net.Conn
+	client   *websocket.Client
+	out      *websocket.Outbox
+}
+
+// NewServer creates a new TCP server
+func NewServer(logger *slog.Logger, hub *websocket.Hub, authenticate Authenticator) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		logger:       logger,
+		hub:          hub,
+		authenticate: authenticate,
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// ListenAndServe listens on the address and serves connections until Close is called, TLS is used if tlsConfig is set
+func (s *Server) ListenAndServe(address string, tlsConfig *tls.Config) error {
+	// Dead peers are detected by TCP keep-alives sent at the ping interval
+	lc := net.ListenConfig{KeepAlive: s.hub.PingInterval()}
+	ln, err := lc.Listen(context.Background(), "tcp", address)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on the listener until Close is called
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return net.ErrClosed
+	}
+	s.listener = ln
+	s.mu.Unlock()
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return net.ErrClosed
+			}
+			return err
+		}
+		go s.handle(nc)
+	}
+}
+
+// Close stops accepting connections, open connections are closed by the hub shutdown
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.cancel()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// handle authenticates a connection and runs it until it is closed
+func (s *Server) handle(nc net.Conn) {
+	defer nc.Close()
+
+	scanner := bufio.NewScanner(nc)
+	scanner.Buffer(make([]byte, 0, 4096), MaxLineSize)
+
+	nc.SetReadDeadline(time.Now().Add(authTimeout))
+	if !scanner.Scan() {
+		s.logger.Debug("Could not read TCP auth line", "remote", nc.RemoteAddr().String(), "error", scanner.Err())
+		return
+	}
+
+	var auth AuthMessage
+	if err := json.Unmarshal(scanner.Bytes(), &auth); err != nil || auth.Type != MessageTypeAuth {
+		writeLine(nc, errorLine("", "expected auth message"))
+		return
+	}
+	// Callbacks of the authentication are cancelled after the auth timeout or on Close
+	ctx, cancel := context.WithDeadline(s.ctx, time.Now().Add(authTimeout))
+	claims, err := s.authenticate(ctx, auth.Endpoint, auth.Token)
+	cancel()
+	if err != nil {
+		s.logger.Debug("Invalid token", "endpoint", auth.Endpoint, "error", err)
+		writeLine(nc, errorLine("", "invalid token"))
+		return
+	}
+	nc.SetReadDeadline(time.Time{})
+
+	c := &conn{
+		server:   s,
+		endpoint: auth.Endpoint,
+		nc:       nc,
+		out:      websocket.NewOutbox(64),
+	}
+	c.client = s.hub.Attach(auth.Endpoint, claims)
+	defer c.client.Detach()
+
+	authenticated, _ := json.Marshal(AuthenticatedMessage{Type: MessageTypeAuthenticated, Endpoint: auth.Endpoint})
+	if err := writeLine(nc, authenticated); err != nil {
+		return
+	}
+
+	go c.writePump()
+	c.readPump(scanner)
+	c.out.Stop()
+}
+
+// readPump handles the subscribe, unsubscribe and ping lines sent by the client
+func (c *conn) readPump(scanner *bufio.Scanner) {
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var msg websocket.SubscriptionMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			continue
+		}
+
+		switch msg.Type {
+		case websocket.MessageTypeSubscribe:
+			if err := c.client.Subscribe(msg); err != nil {
+				c.server.logger.Debug("Client subscription rejected",
+					"topic", msg.Topic,
+					"error", err,
+				)
+				c.out.Queue(errorLine(msg.Topic, err.Error()))
+			}
+
+		case websocket.MessageTypeUnsubscribe:
+			c.client.Unsubscribe(msg.Topic)
+
+		case websocket.MessageTypePing:
+			var ping websocket.PingMessage
+			if err := json.Unmarshal(line, &ping); err != nil {
+				continue
+			}
+			pong, _ := json.Marshal(websocket.PongMessage{
+				Type:       websocket.MessageTypePong,
+				ID:         ping.ID,
+				Timestamp:  ping.Timestamp,
+				ServerTime: time.Now().UnixMilli(),
+			})
+			c.out.Queue(pong)
+		}
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		c.server.logger.Debug("TCP connection closed", "endpoint", c.endpoint, "error", err)
+	}
+}
+
+// writePump writes hub deliveries and replies to the client
+func (c *conn) writePump() {
+	write := func(line []byte) error {
+		return writeLine(c.nc, line)
+	}
+	websocket.Pump{
+		Outbox: c.out,
+		Client: c.client,
+		Conn:   c.nc,
+		Write:  write,
+		Deliver: func(delivery websocket.Delivery) error {
+			return write(delivery.Data)
+		},
+		Closed: func(reason websocket.CloseReason) {
+			// Tell the client why it was disconnected and when to reconnect
+			closing, _ := json.Marshal(CloseMessage{
+				Type:      MessageTypeClose,
+				CloseHint: reason.Hint(),
+			})
+			write(closing)
+		},
+	}.Run()
+}
+
+// writeLine writes a JSON line to the connection
+func writeLine(nc net.Conn, line []byte) error {
+	nc.SetWriteDeadline(time.Now().Add(writeWait))
+	// Deliveries are shared between clients, the newline is written without appending to them
+	buffers := net.Buffers{line, []byte("\n")}
+	_, err := buffers.WriteTo(nc)
+	return err
+}
+
+// errorLine encodes an error message
+func errorLine(topic, message string) []byte {
+	line, _ := json.Marshal(websocket.ErrorMessage{
+		Type:  websocket.MessageTypeError,
+		Topic: topic,
+		Error: message,
+	})
+	return line
+}
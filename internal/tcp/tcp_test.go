@@ -0,0 +1,178 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/make0x20/driplet/internal/jwt"
+	"github.com/make0x20/driplet/internal/websocket"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	hub := websocket.NewHub(logger)
+	go hub.Run()
+
+	server := NewServer(logger, hub, func(ctx context.Context, endpoint, token string) (*jwt.Claims, error) {
+		if endpoint != "web" || token != "valid" {
+			return nil, errors.New("invalid token")
+		}
+		// Authentication callbacks are bounded by the auth timeout
+		if _, ok := ctx.Deadline(); !ok || ctx.Err() != nil {
+			return nil, errors.New("expected a live connection context with a deadline")
+		}
+		return &jwt.Claims{}, nil
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+	return server, ln.Addr().String()
+}
+
+// testConn is a client connection reading one JSON line at a time
+type testConn struct {
+	t  *testing.T
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+func dial(t *testing.T, address string) *testConn {
+	t.Helper()
+	nc, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nc.Close() })
+	return &testConn{t: t, nc: nc, r: bufio.NewReader(nc)}
+}
+
+func (c *testConn) send(line string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.nc, line+"\n"); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testConn) read() map[string]interface{} {
+	c.t.Helper()
+	c.nc.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("could not read line: %v", err)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &msg); err != nil {
+		c.t.Fatalf("invalid line %q: %v", line, err)
+	}
+	return msg
+}
+
+// TestAuth verifies the auth line:
+// - Anything but an auth line is rejected
+// - Invalid tokens are rejected
+// - A valid token is confirmed with the endpoint
+func TestAuth(t *testing.T) {
+	_, address := newTestServer(t)
+
+	tests := []struct {
+		name     string
+		line     string
+		expected string
+	}{
+		{"not an auth line", `{"type":"subscribe","topic":"news"}`, "expected auth message"},
+		{"invalid json", `hello`, "expected auth message"},
+		{"invalid token", `{"type":"auth","endpoint":"web","token":"invalid"}`, "invalid token"},
+		{"unknown endpoint", `{"type":"auth","endpoint":"other","token":"valid"}`, "invalid token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dial(t, address)
+			c.send(tt.line)
+			if msg := c.read(); msg["type"] != "error" || msg["error"] != tt.expected {
+				t.Errorf("expected error %q, got %v", tt.expected, msg)
+			}
+			if _, err := c.r.ReadString('\n'); err != io.EOF {
+				t.Errorf("expected connection to be closed, got %v", err)
+			}
+		})
+	}
+
+	c := dial(t, address)
+	c.send(`{"type":"auth","endpoint":"web","token":"valid"}`)
+	if msg := c.read(); msg["type"] != "authenticated" || msg["endpoint"] != "web" {
+		t.Errorf("expected authenticated, got %v", msg)
+	}
+}
+
+// TestMessages verifies the NDJSON flow:
+// - Subscribed topics receive the same envelopes as WebSocket clients
+// - Messages of other topics and endpoints are not delivered
+// - Invalid subscriptions are answered with an error line
+// - Pings are answered with a pong
+// - Unsubscribed topics are no longer delivered
+// - The hub shutdown sends a close line
+func TestMessages(t *testing.T) {
+	server, address := newTestServer(t)
+
+	c := dial(t, address)
+	c.send(`{"type":"auth","endpoint":"web","token":"valid"}`)
+	c.read()
+	c.send(`{"type":"subscribe","topic":"news"}`)
+	c.send(`{"type":"subscribe","topic":"sports","filter":"("}`)
+	if msg := c.read(); msg["type"] != "error" || msg["topic"] != "sports" {
+		t.Fatalf("expected subscription error, got %v", msg)
+	}
+
+	// Multi-line JSON is compacted to a single line
+	for _, msg := range []websocket.BroadcastMessage{
+		{Endpoint: "web", Topic: "weather", Message: json.RawMessage(`"skipped"`)},
+		{Endpoint: "other", Topic: "news", Message: json.RawMessage(`"skipped"`)},
+		{Endpoint: "web", Topic: "news", Message: json.RawMessage("{\n  \"n\": 1\n}")},
+	} {
+		if err := server.hub.Broadcast(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg := c.read()
+	if msg["topic"] != "news" || msg["endpoint"] != "web" || !strings.Contains(mustMarshal(t, msg["message"]), `"n":1`) {
+		t.Errorf("expected news message, got %v", msg)
+	}
+
+	c.send(`{"type":"ping","id":"p1","timestamp":1}`)
+	if msg := c.read(); msg["type"] != "pong" || msg["id"] != "p1" {
+		t.Errorf("expected pong, got %v", msg)
+	}
+
+	c.send(`{"type":"unsubscribe","topic":"news"}`)
+	c.send(`{"type":"ping","id":"p2"}`)
+	c.read()
+	server.hub.Broadcast(websocket.BroadcastMessage{Endpoint: "web", Topic: "news", Message: json.RawMessage(`"skipped"`)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	server.hub.Shutdown(ctx)
+	if msg := c.read(); msg["type"] != "close" || msg["reason"] != "shutdown" || msg["code"] != float64(websocket.CloseCodeShutdown) {
+		t.Errorf("expected close line, got %v", msg)
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
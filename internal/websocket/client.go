@@ -4,18 +4,74 @@ import (
     "github.com/make0x20/driplet/internal/jwt"
     "encoding/json"
     "github.com/gorilla/websocket"
+    "strconv"
+    "strings"
     "sync"
+    "sync/atomic"
+    "time"
+)
+
+const (
+    // Time allowed to write a message to the client
+    writeWait = 10 * time.Second
 )
 
 // Client holds information about a websocket client.
 type Client struct {
+    id       string
     hub      *Hub
     conn     *websocket.Conn
-    send     chan []byte
+    send     chan Delivery
     endpoint string
     claims   *jwt.Claims
-    topics   []string
+    topics   map[string]*subscription
     topicsMu sync.RWMutex
+    sendMu   sync.Mutex
+    closed   bool
+    reason   *CloseReason
+    // compression holds the negotiated permessage-deflate settings
+    compression CompressionOptions
+    // wire counts bytes written to the network connection
+    wire *countingConn
+    // rtt holds the last measured protocol-level round trip time in nanoseconds
+    rtt atomic.Int64
+    // expiry disconnects the client when its token expires
+    expiry *time.Timer
+    // authorize reports whether the client may receive private messages on a topic
+    authorize TopicMatcher
+    // anonymous clients have no identity to target, they only receive untargeted messages
+    anonymous bool
+    // attributes holds the targeting attributes set with Hub.UpdateAttributes, they take precedence over the claims
+    attributes   map[string]interface{}
+    attributesMu sync.RWMutex
+}
+
+// removedAttribute marks a targeting attribute removed with Hub.UpdateAttributes
+type removedAttribute struct{}
+
+// ClientOption configures a client attached with Hub.Attach.
+type ClientOption func(*Client)
+
+// WithID sets the id of the client, e.g. a protocol specific socket id.
+func WithID(id string) ClientOption {
+    return func(c *Client) {
+        c.id = id
+    }
+}
+
+// WithAuthorizer allows the client to receive private messages on topics accepted by authorize.
+func WithAuthorizer(authorize TopicMatcher) ClientOption {
+    return func(c *Client) {
+        c.authorize = authorize
+    }
+}
+
+// WithAnonymous marks a client without an identity, e.g. a protocol client authenticated by an app key only.
+// It receives no targeted messages, neither include nor exclude rules can address it.
+func WithAnonymous() ClientOption {
+    return func(c *Client) {
+        c.anonymous = true
+    }
 }
 
 // NewClient creates a new client.
@@ -23,11 +79,225 @@ func NewClient(hub *Hub, conn *websocket.Conn, endpoint string, claims *jwt.Clai
     return &Client{
         hub:      hub,
         conn:     conn,
-        send:     make(chan []byte, 256),
+        send:     make(chan Delivery, 256),
         endpoint: endpoint,
         claims:   claims,
-        topics:   make([]string, 0),
+        topics:   make(map[string]*subscription),
+    }
+}
+
+// Delivery is a message queued for a client
+type Delivery struct {
+    // Data is the JSON encoded message
+    Data []byte
+    // Message is the broadcast message, nil for replies to client commands
+    Message *BroadcastMessage
+    // Topic is the topic of the message the client's subscription matched
+    Topic string
+}
+
+// enqueue queues a message for the client without blocking, returns false if the buffer is full or closed.
+func (c *Client) enqueue(message Delivery) bool {
+    c.sendMu.Lock()
+    defer c.sendMu.Unlock()
+
+    if c.closed {
+        return false
+    }
+
+    select {
+    case c.send <- message:
+        return true
+    default:
+        return false
+    }
+}
+
+// close closes the send channel of the client once.
+func (c *Client) close() {
+    c.sendMu.Lock()
+    defer c.sendMu.Unlock()
+
+    if !c.closed {
+        c.closed = true
+        close(c.send)
+    }
+}
+
+// setCloseReason records why the client is being disconnected, the first reason wins.
+func (c *Client) setCloseReason(reason CloseReason) {
+    c.sendMu.Lock()
+    defer c.sendMu.Unlock()
+
+    if c.reason == nil {
+        c.reason = &reason
+    }
+}
+
+// CloseReason returns why the client was disconnected.
+func (c *Client) CloseReason() CloseReason {
+    c.sendMu.Lock()
+    defer c.sendMu.Unlock()
+
+    if c.reason == nil {
+        return CloseNormal
+    }
+    return *c.reason
+}
+
+// authorized reports whether the client may receive private messages on any of the topics.
+func (c *Client) authorized(topics []string) bool {
+    if c.authorize == nil {
+        return false
+    }
+    for _, topic := range topics {
+        if c.authorize(topic) {
+            return true
+        }
+    }
+    return false
+}
+
+// attribute returns a targeting attribute by its dot-separated path.
+// Attributes set or removed with Hub.UpdateAttributes take precedence over the claims, also for the paths below them.
+func (c *Client) attribute(path string) (interface{}, bool) {
+    c.attributesMu.RLock()
+    value, rest, updated := c.updatedAttribute(path)
+    c.attributesMu.RUnlock()
+    if updated {
+        if _, removed := value.(removedAttribute); removed {
+            return nil, false
+        }
+        if rest == "" {
+            return value, true
+        }
+        attributes, ok := value.(map[string]interface{})
+        if !ok {
+            return nil, false
+        }
+        return jwt.Lookup(attributes, rest)
+    }
+    if c.claims == nil {
+        return nil, false
     }
+    return c.claims.GetCustomClaim(path)
+}
+
+// updatedAttribute returns the updated attribute with the longest path that is the path or a parent of it,
+// and the rest of the path below it. The caller must hold attributesMu.
+func (c *Client) updatedAttribute(path string) (interface{}, string, bool) {
+    prefix := path
+    for {
+        if value, ok := c.attributes[prefix]; ok {
+            return value, strings.TrimPrefix(strings.TrimPrefix(path, prefix), "."), true
+        }
+        i := strings.LastIndex(prefix, ".")
+        if i < 0 {
+            return nil, "", false
+        }
+        prefix = prefix[:i]
+    }
+}
+
+// updateAttributes sets and removes targeting attributes of the client
+func (c *Client) updateAttributes(set map[string]interface{}, remove []string) {
+    c.attributesMu.Lock()
+    defer c.attributesMu.Unlock()
+    if c.attributes == nil {
+        c.attributes = make(map[string]interface{})
+    }
+    for _, path := range remove {
+        c.replaceAttribute(path, removedAttribute{})
+    }
+    for path, value := range set {
+        c.replaceAttribute(path, value)
+    }
+}
+
+// replaceAttribute sets an updated attribute, dropping earlier updates below its path. The caller must hold attributesMu.
+func (c *Client) replaceAttribute(path string, value interface{}) {
+    for existing := range c.attributes {
+        if strings.HasPrefix(existing, path+".") {
+            delete(c.attributes, existing)
+        }
+    }
+    c.attributes[path] = value
+}
+
+// updatedAttributes returns a copy of the attributes set with Hub.UpdateAttributes, removed attributes are nil
+func (c *Client) updatedAttributes() map[string]interface{} {
+    c.attributesMu.RLock()
+    defer c.attributesMu.RUnlock()
+    attributes := make(map[string]interface{}, len(c.attributes))
+    for path, value := range c.attributes {
+        if _, removed := value.(removedAttribute); removed {
+            value = nil
+        }
+        attributes[path] = value
+    }
+    return attributes
+}
+
+// ID returns the id of the client, empty unless set with WithID.
+func (c *Client) ID() string {
+    return c.id
+}
+
+// Endpoint returns the endpoint the client is connected to.
+func (c *Client) Endpoint() string {
+    return c.endpoint
+}
+
+// Deliveries returns the messages queued for the client, the channel is closed when the hub disconnects it.
+func (c *Client) Deliveries() <-chan Delivery {
+    return c.send
+}
+
+// Subscribe subscribes the client to a topic.
+func (c *Client) Subscribe(msg SubscriptionMessage) error {
+    return c.subscribe(msg)
+}
+
+// Unsubscribe unsubscribes the client from a topic.
+func (c *Client) Unsubscribe(topic string) {
+    c.unsubscribe(topic)
+}
+
+// Detach unregisters a client attached with Hub.Attach.
+func (c *Client) Detach() {
+    c.hub.unregister <- c
+}
+
+// RTT returns the last measured protocol-level round trip time, zero if not measured yet.
+func (c *Client) RTT() time.Duration {
+    return time.Duration(c.rtt.Load())
+}
+
+// pong answers an application-level ping.
+func (c *Client) pong(ping PingMessage) {
+    msg := PongMessage{
+        Type:       MessageTypePong,
+        ID:         ping.ID,
+        Timestamp:  ping.Timestamp,
+        ServerTime: time.Now().UnixMilli(),
+    }
+    if rtt := c.RTT(); rtt > 0 {
+        ms := float64(rtt.Microseconds()) / 1000
+        msg.RTT = &ms
+    }
+
+    data, _ := json.Marshal(msg)
+    c.enqueue(Delivery{Data: data})
+}
+
+// sendError queues an error message for the client.
+func (c *Client) sendError(topic string, err error) {
+    data, _ := json.Marshal(ErrorMessage{
+        Type:  MessageTypeError,
+        Topic: topic,
+        Error: err.Error(),
+    })
+    c.enqueue(Delivery{Data: data})
 }
 
 // ReadPump reads messages from the client.
@@ -37,6 +307,19 @@ func (c *Client) ReadPump() {
         c.conn.Close()
     }()
 
+    // Measure the round trip time of protocol-level pings and extend the read deadline
+    pongWait := 2 * c.hub.options.PingInterval
+    c.conn.SetReadDeadline(time.Now().Add(pongWait))
+    c.conn.SetPongHandler(func(appData string) error {
+        c.conn.SetReadDeadline(time.Now().Add(pongWait))
+        if sent, err := strconv.ParseInt(appData, 10, 64); err == nil {
+            rtt := time.Since(time.Unix(0, sent))
+            c.rtt.Store(int64(rtt))
+            c.hub.metrics.rtt.With(c.endpoint).Observe(rtt.Seconds())
+        }
+        return nil
+    })
+
 	// Loops indefinitely to read messages from the client until connection is closed
     for {
 		// Read the message from the client
@@ -62,24 +345,23 @@ func (c *Client) ReadPump() {
 		// Handle the message type
         switch subMsg.Type {
         case MessageTypeSubscribe:
-            c.topicsMu.Lock()
-            if !contains(c.topics, subMsg.Topic) {
-                c.topics = append(c.topics, subMsg.Topic)
-                c.hub.options.Logger.Debug("Client subscribed to topic",
+            if err := c.subscribe(subMsg); err != nil {
+                c.hub.options.Logger.Debug("Client subscription rejected",
                     "topic", subMsg.Topic,
-                    "client_topics", c.topics,
+                    "error", err,
                 )
+                c.sendError(subMsg.Topic, err)
             }
-            c.topicsMu.Unlock()
 
         case MessageTypeUnsubscribe:
-            c.topicsMu.Lock()
-            c.topics = removeString(c.topics, subMsg.Topic)
-            c.hub.options.Logger.Debug("Client unsubscribed from topic",
-                "topic", subMsg.Topic,
-                "client_topics", c.topics,
-            )
-            c.topicsMu.Unlock()
+            c.unsubscribe(subMsg.Topic)
+
+        case MessageTypePing:
+            var ping PingMessage
+            if err := json.Unmarshal(message, &ping); err != nil {
+                continue
+            }
+            c.pong(ping)
         }
     }
 }
@@ -88,28 +370,67 @@ func (c *Client) ReadPump() {
 func (c *Client) WritePump() {
     defer func() {
         c.conn.Close()
+        c.hub.writers.Done()
     }()
 
+    // Send protocol-level pings to keep the connection alive and measure the round trip time
+    ticker := time.NewTicker(c.hub.options.PingInterval)
+    defer ticker.Stop()
+
 	// Loops indefinitely to write messages to the client until connection is closed
     for {
         select {
 		// Wait for a message to be sent
-        case message, ok := <-c.send:
+        case delivery, ok := <-c.send:
             if !ok {
-                c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+                // Tell the client why it was disconnected and when to reconnect
+                c.conn.WriteControl(websocket.CloseMessage, c.CloseReason().Message(), time.Now().Add(writeWait))
                 return
             }
 
-            w, err := c.conn.NextWriter(websocket.TextMessage)
-            if err != nil {
+            if err := c.writeMessage(delivery.Data); err != nil {
                 return
             }
 
-            w.Write(message)
-
-            if err := w.Close(); err != nil {
+        // Send a ping carrying the send time, answered in the pong handler
+        case <-ticker.C:
+            payload := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+            if err := c.conn.WriteControl(websocket.PingMessage, payload, time.Now().Add(writeWait)); err != nil {
                 return
             }
         }
     }
 }
+
+// writeMessage writes a text message, compressing it when it reaches the endpoint threshold.
+func (c *Client) writeMessage(message []byte) error {
+    compress := c.compression.Enabled && len(message) >= c.compression.MinSize
+    c.conn.EnableWriteCompression(compress)
+    c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+    var before int64
+    if c.wire != nil {
+        before = c.wire.written.Load()
+    }
+
+    w, err := c.conn.NextWriter(websocket.TextMessage)
+    if err != nil {
+        return err
+    }
+
+    w.Write(message)
+
+    if err := w.Close(); err != nil {
+        return err
+    }
+
+    // Record payload and wire sizes so the compression ratio can be derived
+    c.hub.metrics.messagesSent.With(c.endpoint).Inc()
+    c.hub.metrics.payloadBytes.With(c.endpoint).Add(float64(len(message)))
+    if compress && c.wire != nil {
+        c.hub.metrics.compressedPayload.With(c.endpoint).Add(float64(len(message)))
+        c.hub.metrics.compressedWire.With(c.endpoint).Add(float64(c.wire.written.Load() - before))
+    }
+
+    return nil
+}
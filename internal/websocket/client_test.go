@@ -0,0 +1,69 @@
+package websocket
+
+import (
+	"github.com/make0x20/driplet/internal/jwt"
+	"testing"
+	"time"
+)
+
+// TestPong verifies the reply to an application-level ping:
+// - The id and timestamp of the ping are echoed with the server time
+// - The round trip time is left out until a protocol-level pong was measured
+func TestPong(t *testing.T) {
+	hub := newTestHub(t)
+	conn := dialTestClient(t, hub, &jwt.Claims{}, nil)
+
+	before := time.Now().UnixMilli()
+	if err := conn.WriteJSON(PingMessage{Type: MessageTypePing, ID: "ping-1", Timestamp: 1700000000000}); err != nil {
+		t.Fatal(err)
+	}
+	var pong PongMessage
+	if err := readJSON(conn, &pong); err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now().UnixMilli()
+
+	if pong.Type != MessageTypePong || pong.ID != "ping-1" || pong.Timestamp != 1700000000000 {
+		t.Errorf("expected the ping to be echoed, got %+v", pong)
+	}
+	if pong.ServerTime < before || pong.ServerTime > after {
+		t.Errorf("expected the server time between %d and %d, got %d", before, after, pong.ServerTime)
+	}
+	if pong.RTT != nil {
+		t.Errorf("expected no round trip time before a protocol pong, got %v", *pong.RTT)
+	}
+}
+
+// TestProtocolPingRTT verifies that the round trip time of protocol-level pings is recorded
+// and reported in the reply to application-level pings
+func TestProtocolPingRTT(t *testing.T) {
+	hub := newTestHub(t, WithPingInterval(20*time.Millisecond))
+	conn := dialTestClient(t, hub, &jwt.Claims{}, nil)
+
+	// The client answers protocol pings while it reads
+	var pong PongMessage
+	deadline := time.Now().Add(2 * time.Second)
+	for pong.RTT == nil && time.Now().Before(deadline) {
+		if err := conn.WriteJSON(PingMessage{Type: MessageTypePing}); err != nil {
+			t.Fatal(err)
+		}
+		if err := readJSON(conn, &pong); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pong.RTT == nil || *pong.RTT <= 0 {
+		t.Fatalf("expected a measured round trip time, got %+v", pong)
+	}
+
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	if len(hub.clients) != 1 {
+		t.Fatalf("expected 1 client, got %d", len(hub.clients))
+	}
+	for client := range hub.clients {
+		if rtt := client.RTT(); rtt <= 0 || rtt > time.Second {
+			t.Errorf("expected the client round trip time to be recorded, got %s", rtt)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package websocket
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"math/rand/v2"
+	"time"
+)
+
+// Close codes sent by driplet, in the private use range of RFC 6455
+const (
+	CloseCodeShutdown     = 4000
+	CloseCodeTokenExpired = 4001
+	CloseCodeSlowConsumer = 4002
+	CloseCodeKicked       = 4003
+)
+
+// CloseReason describes why the hub disconnected a client and when it should reconnect
+type CloseReason struct {
+	Code       int
+	Reason     string
+	RetryAfter time.Duration
+	Jitter     time.Duration
+}
+
+var (
+	// CloseNormal is used when the connection ends without the hub disconnecting the client
+	CloseNormal = CloseReason{Code: websocket.CloseNormalClosure, Reason: "normal"}
+	// CloseShutdown is used when the server shuts down, reconnects are spread over a wide window
+	CloseShutdown = CloseReason{Code: CloseCodeShutdown, Reason: "shutdown", RetryAfter: time.Second, Jitter: 10 * time.Second}
+	// CloseTokenExpired is used when the client token expires, the client should reconnect with a fresh token
+	CloseTokenExpired = CloseReason{Code: CloseCodeTokenExpired, Reason: "token_expired", Jitter: time.Second}
+	// CloseSlowConsumer is used when the client does not read messages fast enough
+	CloseSlowConsumer = CloseReason{Code: CloseCodeSlowConsumer, Reason: "slow_consumer", RetryAfter: 2 * time.Second, Jitter: 3 * time.Second}
+	// CloseKicked is used when the backend disconnects the client
+	CloseKicked = CloseReason{Code: CloseCodeKicked, Reason: "kicked", RetryAfter: 30 * time.Second, Jitter: 30 * time.Second}
+)
+
+// CloseHint is the JSON reason sent with a close frame
+type CloseHint struct {
+	Code       int     `json:"code"`
+	Reason     string  `json:"reason"`
+	RetryAfter float64 `json:"retry_after"`
+}
+
+// Hint returns the close hint with a randomized retry delay in seconds
+func (r CloseReason) Hint() CloseHint {
+	retry := r.RetryAfter
+	if r.Jitter > 0 {
+		retry += rand.N(r.Jitter)
+	}
+
+	return CloseHint{
+		Code:       r.Code,
+		Reason:     r.Reason,
+		RetryAfter: float64(retry.Milliseconds()) / 1000,
+	}
+}
+
+// Message returns the close frame payload for the reason
+func (r CloseReason) Message() []byte {
+	hint, _ := json.Marshal(r.Hint())
+	return websocket.FormatCloseMessage(r.Code, string(hint))
+}
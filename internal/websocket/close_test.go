@@ -0,0 +1,122 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"github.com/make0x20/driplet/internal/jwt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readCloseFrame reads messages until the close frame and checks its code and JSON reason
+func readCloseFrame(t *testing.T, conn *websocket.Conn, reason CloseReason) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var err error
+	for err == nil {
+		_, _, err = conn.ReadMessage()
+	}
+
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("expected a close frame, got %v", err)
+	}
+	if closeErr.Code != reason.Code {
+		t.Errorf("expected close code %d, got %d", reason.Code, closeErr.Code)
+	}
+	var hint CloseHint
+	if err := json.Unmarshal([]byte(closeErr.Text), &hint); err != nil {
+		t.Fatalf("expected a JSON close reason, got %q: %v", closeErr.Text, err)
+	}
+	if hint.Code != reason.Code || hint.Reason != reason.Reason {
+		t.Errorf("expected %d %s, got %+v", reason.Code, reason.Reason, hint)
+	}
+	if min, max := reason.RetryAfter.Seconds(), (reason.RetryAfter + reason.Jitter).Seconds(); hint.RetryAfter < min || hint.RetryAfter > max {
+		t.Errorf("expected retry_after between %v and %v, got %v", min, max, hint.RetryAfter)
+	}
+}
+
+// TestCloseFrames verifies the close frame sent when the hub disconnects a client:
+// - Shutdown, token expiry and kicks close with their code and a JSON reason
+// - retry_after is within the retry window of the reason
+func TestCloseFrames(t *testing.T) {
+	tests := []struct {
+		name       string
+		reason     CloseReason
+		expiresIn  time.Duration
+		disconnect func(hub *Hub)
+	}{
+		{"shutdown", CloseShutdown, 0, func(hub *Hub) {
+			hub.Shutdown(context.Background())
+		}},
+		{"token expired", CloseTokenExpired, 300 * time.Millisecond, nil},
+		{"kicked", CloseKicked, 0, func(hub *Hub) {
+			if n := hub.Kick("web", func(claims *jwt.Claims) bool { return claims.Subject == "42" }); n != 1 {
+				t.Errorf("expected 1 kicked client, got %d", n)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := newTestHub(t)
+			claims := &jwt.Claims{}
+			claims.Subject = "42"
+			if tt.expiresIn > 0 {
+				claims.ExpiresAt = &gojwt.NumericDate{Time: time.Now().Add(tt.expiresIn)}
+			}
+			conn := dialTestClient(t, hub, claims, nil)
+
+			if tt.disconnect != nil {
+				tt.disconnect(hub)
+			}
+			readCloseFrame(t, conn, tt.reason)
+		})
+	}
+}
+
+// TestSlowConsumerCloseFrame verifies that a client that does not read is disconnected once its send buffer is full,
+// and that the close frame follows the messages queued before
+func TestSlowConsumerCloseFrame(t *testing.T) {
+	hub := newTestHub(t)
+	conn := dialTestClient(t, hub, &jwt.Claims{}, nil)
+	if err := conn.WriteJSON(SubscriptionMessage{Type: MessageTypeSubscribe, Topic: "news"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Large messages fill the socket buffers, then the send buffer of the client
+	message := json.RawMessage(`"` + strings.Repeat("a", 64*1024) + `"`)
+	connected := func() bool {
+		hub.mu.RLock()
+		defer hub.mu.RUnlock()
+		return len(hub.clients) > 0
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for connected() && time.Now().Before(deadline) {
+		if err := hub.Broadcast(BroadcastMessage{Endpoint: "web", Topic: "news", Message: message}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if connected() {
+		t.Fatal("expected the slow client to be disconnected")
+	}
+	readCloseFrame(t, conn, CloseSlowConsumer)
+}
+
+// TestCloseHint verifies that retry delays are spread over the jitter window
+func TestCloseHint(t *testing.T) {
+	seen := make(map[float64]bool)
+	for i := 0; i < 20; i++ {
+		hint := CloseShutdown.Hint()
+		if hint.RetryAfter < 1 || hint.RetryAfter > 11 {
+			t.Fatalf("expected retry_after between 1 and 11 seconds, got %v", hint.RetryAfter)
+		}
+		seen[hint.RetryAfter] = true
+	}
+	if len(seen) < 2 {
+		t.Error("expected jittered retry delays")
+	}
+}
@@ -0,0 +1,75 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"io"
+	"strings"
+)
+
+// ErrMessageType is returned by a StreamReader for WebSocket messages of an unexpected type
+var ErrMessageType = errors.New("unexpected WebSocket message type")
+
+// EncodePayload converts the payload of a protocol adapter to a hub message: JSON objects and arrays are kept as JSON,
+// anything else becomes a JSON string
+func EncodePayload(payload []byte) json.RawMessage {
+	trimmed := strings.TrimSpace(string(payload))
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		if json.Valid([]byte(trimmed)) {
+			return json.RawMessage(trimmed)
+		}
+	}
+	encoded, _ := json.Marshal(string(payload))
+	return encoded
+}
+
+// DecodePayload converts a hub message to the payload of a protocol adapter: JSON strings are unquoted and reported
+// as text, other JSON is returned as is
+func DecodePayload(message json.RawMessage) ([]byte, bool) {
+	var s string
+	if err := json.Unmarshal(message, &s); err == nil {
+		return []byte(s), true
+	}
+	return message, false
+}
+
+// StreamReader reads a byte stream spanning any number of WebSocket messages, e.g. MQTT packets or STOMP frames
+type StreamReader struct {
+	ws *websocket.Conn
+	// messageType is the required message type, any type is accepted if zero
+	messageType int
+	r           io.Reader
+}
+
+// NewStreamReader creates a stream reader of a connection, messages of another type than messageType fail
+// with ErrMessageType unless it is zero
+func NewStreamReader(ws *websocket.Conn, messageType int) *StreamReader {
+	return &StreamReader{ws: ws, messageType: messageType}
+}
+
+// Read reads from the current message, moving on to the next message at its end
+func (s *StreamReader) Read(p []byte) (int, error) {
+	for {
+		if s.r == nil {
+			messageType, r, err := s.ws.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			if s.messageType != 0 && messageType != s.messageType {
+				return 0, ErrMessageType
+			}
+			s.r = r
+		}
+
+		n, err := s.r.Read(p)
+		if err == io.EOF {
+			s.r = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
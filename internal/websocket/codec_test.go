@@ -0,0 +1,21 @@
+package websocket
+
+import "testing"
+
+// TestPayload verifies that protocol payloads round trip through hub messages and JSON is kept as JSON
+func TestPayload(t *testing.T) {
+	for _, data := range []string{`{"some":"data"}`, `[1,2]`, `"quoted"`, `42`, `plain text`} {
+		if decoded, _ := DecodePayload(EncodePayload([]byte(data))); string(decoded) != data {
+			t.Errorf("expected %s to round trip, got %s", data, decoded)
+		}
+	}
+	if encoded := string(EncodePayload([]byte(`{"some":"data"}`))); encoded != `{"some":"data"}` {
+		t.Errorf("expected JSON objects to be kept, got %s", encoded)
+	}
+	if decoded, _ := DecodePayload([]byte(`"hello\nworld"`)); string(decoded) != "hello\nworld" {
+		t.Errorf("expected JSON strings to be unquoted, got %q", decoded)
+	}
+	if _, text := DecodePayload(EncodePayload([]byte(`plain text`))); !text {
+		t.Error("expected plain text to be decoded as text")
+	}
+}
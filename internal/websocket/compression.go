@@ -0,0 +1,67 @@
+package websocket
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// CompressionOptions holds permessage-deflate settings for an endpoint
+type CompressionOptions struct {
+	Enabled bool
+	Level   int
+	MinSize int
+}
+
+// compressionFor returns the compression options for the given endpoint
+func (h *Hub) compressionFor(endpoint string) CompressionOptions {
+	return h.options.Compression[endpoint]
+}
+
+// offersCompression reports whether the client offered permessage-deflate during the handshake
+func offersCompression(r *http.Request) bool {
+	for _, ext := range r.Header.Values("Sec-WebSocket-Extensions") {
+		if strings.Contains(ext, "permessage-deflate") {
+			return true
+		}
+	}
+	return false
+}
+
+// countingConn counts bytes written to the underlying network connection
+type countingConn struct {
+	net.Conn
+	written atomic.Int64
+}
+
+// Write writes to the underlying connection and counts written bytes
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// countingResponseWriter wraps the hijacked connection of an upgrade in a countingConn
+type countingResponseWriter struct {
+	http.ResponseWriter
+	conn *countingConn
+}
+
+// Hijack hijacks the connection and wraps it so written bytes can be counted
+func (w *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response does not implement http.Hijacker")
+	}
+
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.conn = &countingConn{Conn: conn}
+	return w.conn, brw, nil
+}
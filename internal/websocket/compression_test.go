@@ -0,0 +1,115 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/make0x20/driplet/internal/jwt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// recordingConn keeps a copy of the bytes read from the server
+type recordingConn struct {
+	net.Conn
+	read bytes.Buffer
+}
+
+// Read reads from the connection and records the bytes
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Write(p[:n])
+	return n, err
+}
+
+// textFrames returns whether each text frame read by the client was compressed, the RSV1 bit marks compressed frames
+func (c *recordingConn) textFrames(t *testing.T) []bool {
+	t.Helper()
+	data := c.read.Bytes()
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end < 0 {
+		t.Fatal("no handshake response recorded")
+	}
+	data = data[end+4:]
+
+	var compressed []bool
+	for len(data) >= 2 {
+		// Server frames are not masked
+		header, length := 2, int(data[1]&0x7f)
+		switch length {
+		case 126:
+			length, header = int(binary.BigEndian.Uint16(data[2:4])), 4
+		case 127:
+			length, header = int(binary.BigEndian.Uint64(data[2:10])), 10
+		}
+		if data[0]&0x0f == websocket.TextMessage {
+			compressed = append(compressed, data[0]&0x40 != 0)
+		}
+		data = data[header+length:]
+	}
+	return compressed
+}
+
+// TestCompression verifies per-endpoint permessage-deflate:
+// - Messages reaching MinSize are compressed when the endpoint enables compression and the client offers it
+// - Smaller messages are sent uncompressed
+// - Nothing is compressed when the client or the endpoint does not use compression
+func TestCompression(t *testing.T) {
+	tests := []struct {
+		name       string
+		endpoint   bool
+		client     bool
+		compressed bool
+	}{
+		{"negotiated", true, true, true},
+		{"client without compression", true, false, false},
+		{"endpoint without compression", false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := newTestHub(t, WithCompression("web", CompressionOptions{Enabled: tt.endpoint, Level: 1, MinSize: 256}))
+			var recorder *recordingConn
+			dialer := &websocket.Dialer{
+				EnableCompression: tt.client,
+				NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+					recorder = &recordingConn{Conn: conn}
+					return recorder, err
+				},
+			}
+			conn := dialTestClient(t, hub, &jwt.Claims{}, dialer)
+			subscribe(t, conn, SubscriptionMessage{Topic: "news"})
+			var pong PongMessage
+			if err := readJSON(conn, &pong); err != nil {
+				t.Fatal(err)
+			}
+
+			small := json.RawMessage(`"short"`)
+			large := json.RawMessage(`"` + strings.Repeat("compressible ", 100) + `"`)
+			for _, message := range []json.RawMessage{small, large} {
+				if err := hub.Broadcast(BroadcastMessage{Endpoint: "web", Topic: "news", Message: message}); err != nil {
+					t.Fatal(err)
+				}
+				var received BroadcastMessage
+				if err := readJSON(conn, &received); err != nil || !bytes.Equal(received.Message, message) {
+					t.Fatalf("expected the message to arrive intact, got %s: %v", received.Message, err)
+				}
+			}
+
+			// The ping replies come first, then the small and the large message
+			frames := recorder.textFrames(t)
+			if len(frames) != 4 {
+				t.Fatalf("expected 4 text frames, got %d", len(frames))
+			}
+			if frames[2] {
+				t.Error("expected the message below MinSize to be sent uncompressed")
+			}
+			if frames[3] != tt.compressed {
+				t.Errorf("expected the large message compressed %v, got %v", tt.compressed, frames[3])
+			}
+		})
+	}
+}
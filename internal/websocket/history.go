@@ -0,0 +1,230 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+)
+
+// maxHistoryTopics is the maximum number of topics with a history, idle topics are dropped first when it is reached
+const maxHistoryTopics = 10000
+
+// HistoryOptions holds the message history settings for an endpoint
+type HistoryOptions struct {
+	Size   int
+	MaxAge time.Duration
+}
+
+// historyEntry is a message kept in the history of a topic
+type historyEntry struct {
+	msg  BroadcastMessage
+	time time.Time
+	// order is the position of the message in the history across topics
+	order uint64
+}
+
+// topicHistory is a ring buffer of the latest messages on a topic
+type topicHistory struct {
+	endpoint string
+	sequence uint64
+	entries  []historyEntry
+	start    int
+	count    int
+	// updated is when the last message was appended
+	updated time.Time
+}
+
+// history keeps bounded per endpoint/topic message histories and assigns sequence numbers.
+// Endpoints with a zero Size keep no history and their messages have no sequence.
+type history struct {
+	mu      sync.Mutex
+	options map[string]HistoryOptions
+	topics  map[string]*topicHistory
+	// maxTopics caps the number of topics with a history
+	maxTopics int
+	// floor is the highest sequence of a dropped topic, topics start above it so resumed sequences stay valid
+	floor uint64
+	// appended counts the messages appended, it orders entries across topics
+	appended uint64
+	timeNow  func() time.Time
+}
+
+// newHistory creates a new history with the given per-endpoint options
+func newHistory(options map[string]HistoryOptions) *history {
+	return &history{
+		options:   options,
+		topics:    make(map[string]*topicHistory),
+		maxTopics: maxHistoryTopics,
+		timeNow:   time.Now,
+	}
+}
+
+// topic returns the history of a topic, creating it if create is set. Callers must hold mu.
+func (h *history) topic(endpoint, topic string, create bool) *topicHistory {
+	key := endpoint + "\x00" + topic
+	t, ok := h.topics[key]
+	if !ok && create {
+		if len(h.topics) >= h.maxTopics {
+			h.evict()
+		}
+		t = &topicHistory{endpoint: endpoint, sequence: h.floor, entries: make([]historyEntry, h.options[endpoint].Size), updated: h.timeNow()}
+		h.topics[key] = t
+	}
+	return t
+}
+
+// evict drops the topics whose messages have all expired, or the least recently updated topic if none have. Callers must hold mu.
+func (h *history) evict() {
+	now := h.timeNow()
+	var oldestKey string
+	var oldest *topicHistory
+	for key, t := range h.topics {
+		if maxAge := h.options[t.endpoint].MaxAge; maxAge > 0 && now.Sub(t.updated) > maxAge {
+			h.drop(key, t)
+			continue
+		}
+		if oldest == nil || t.updated.Before(oldest.updated) {
+			oldestKey, oldest = key, t
+		}
+	}
+	if len(h.topics) >= h.maxTopics && oldest != nil {
+		h.drop(oldestKey, oldest)
+	}
+}
+
+// drop removes the history of a topic, callers must hold mu
+func (h *history) drop(key string, t *topicHistory) {
+	if t.sequence > h.floor {
+		h.floor = t.sequence
+	}
+	delete(h.topics, key)
+}
+
+// append assigns the next sequence number of each topic of the message to it and stores it in their histories
+func (h *history) append(msg *BroadcastMessage) {
+	if h.options[msg.Endpoint].Size <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.timeNow()
+	var topics []*topicHistory
+	msg.Sequences = make(map[string]uint64)
+	for _, topic := range msg.AllTopics() {
+		if _, ok := msg.Sequences[topic]; ok {
+			continue
+		}
+		t := h.topic(msg.Endpoint, topic, true)
+		t.sequence++
+		t.updated = now
+		msg.Sequences[topic] = t.sequence
+		topics = append(topics, t)
+	}
+	msg.Sequence = msg.Sequences[msg.Topic]
+
+	h.appended++
+	entry := historyEntry{msg: *msg, time: now, order: h.appended}
+	for _, t := range topics {
+		t.push(entry)
+	}
+}
+
+// push adds an entry to the ring buffer, replacing the oldest one when it is full
+func (t *topicHistory) push(entry historyEntry) {
+	if t.count < len(t.entries) {
+		t.entries[(t.start+t.count)%len(t.entries)] = entry
+		t.count++
+		return
+	}
+	t.entries[t.start] = entry
+	t.start = (t.start + 1) % len(t.entries)
+}
+
+// replay returns the messages of a topic after the given sequence, at most the last limit of them
+// if limit is positive. fn is called with the messages and the latest sequence of the topic while
+// the history is locked, so no message can be appended before fn has handled the replay.
+func (h *history) replay(endpoint, topic string, since uint64, limit int, fn func(msgs []BroadcastMessage, latest uint64)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Replays do not create topics, topics without a history have nothing to replay
+	t := h.topic(endpoint, topic, false)
+	if t == nil {
+		fn(nil, h.floor)
+		return
+	}
+	maxAge := h.options[endpoint].MaxAge
+	now := h.timeNow()
+
+	var msgs []BroadcastMessage
+	for i := 0; i < t.count; i++ {
+		entry := t.entries[(t.start+i)%len(t.entries)]
+		if entry.msg.Sequences[topic] <= since {
+			continue
+		}
+		if maxAge > 0 && now.Sub(entry.time) > maxAge {
+			continue
+		}
+		msgs = append(msgs, entry.msg)
+	}
+
+	if limit > 0 && len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+	}
+
+	fn(msgs, t.sequence)
+}
+
+// position finds the first message accepted by match in the histories of the topics and returns the sequence
+// each topic had when it was published, replaying the topics since them resumes after the message.
+// Returns false if no message of the histories is accepted.
+func (h *history) position(endpoint string, topics []string, match func(BroadcastMessage) bool) (map[string]uint64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var order uint64
+	found := false
+	for _, topic := range topics {
+		t := h.topic(endpoint, topic, false)
+		if t == nil {
+			continue
+		}
+		for i := 0; i < t.count && !found; i++ {
+			entry := t.entries[(t.start+i)%len(t.entries)]
+			if match(entry.msg) {
+				order, found = entry.order, true
+			}
+		}
+		if found {
+			break
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	// Topics without a message up to it replay their whole history
+	sequences := make(map[string]uint64, len(topics))
+	for _, topic := range topics {
+		t := h.topic(endpoint, topic, false)
+		if t == nil {
+			continue
+		}
+		sequences[topic] = 0
+		for i := 0; i < t.count; i++ {
+			entry := t.entries[(t.start+i)%len(t.entries)]
+			if entry.order > order {
+				break
+			}
+			sequences[topic] = entry.msg.Sequences[topic]
+		}
+	}
+	return sequences, true
+}
+
+// HistoryPosition finds the first message accepted by match in the histories of the topics of an endpoint, e.g. by its
+// event id, and returns the sequence of each topic when it was published. Returns false if no message is accepted.
+func (h *Hub) HistoryPosition(endpoint string, topics []string, match func(BroadcastMessage) bool) (map[string]uint64, bool) {
+	return h.history.position(endpoint, topics, match)
+}
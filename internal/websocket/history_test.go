@@ -0,0 +1,97 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+// TestHistoryTopics verifies that the topics with a history stay bounded:
+// - Endpoints without history keep no topics and assign no sequences
+// - Replays do not create topics
+// - Idle topics are dropped first when the cap is reached, sequences keep increasing
+func TestHistoryTopics(t *testing.T) {
+	now := time.Now()
+	h := newHistory(map[string]HistoryOptions{"web": {Size: 2, MaxAge: time.Minute}})
+	h.maxTopics = 2
+	h.timeNow = func() time.Time { return now }
+
+	msg := BroadcastMessage{Endpoint: "live", Topic: "news"}
+	h.append(&msg)
+	if msg.Sequence != 0 || len(h.topics) != 0 {
+		t.Errorf("expected no history without Size, got sequence %d and %d topics", msg.Sequence, len(h.topics))
+	}
+
+	h.replay("web", "unknown", 0, 10, func(msgs []BroadcastMessage, latest uint64) {
+		if len(msgs) != 0 || latest != 0 {
+			t.Errorf("unexpected replay %v, %d", msgs, latest)
+		}
+	})
+	if len(h.topics) != 0 {
+		t.Errorf("expected replay not to create topics, got %d", len(h.topics))
+	}
+
+	for i := 0; i < 3; i++ {
+		h.append(&BroadcastMessage{Endpoint: "web", Topic: "idle"})
+	}
+	now = now.Add(2 * time.Minute)
+	h.append(&BroadcastMessage{Endpoint: "web", Topic: "a"})
+	b := BroadcastMessage{Endpoint: "web", Topic: "b"}
+	h.append(&b)
+	if _, ok := h.topics["web\x00idle"]; ok || len(h.topics) != 2 {
+		t.Errorf("expected the idle topic to be dropped, got %d topics", len(h.topics))
+	}
+	if b.Sequence <= 3 {
+		t.Errorf("expected topics to start above dropped sequences, got %d", b.Sequence)
+	}
+
+	h.append(&BroadcastMessage{Endpoint: "web", Topic: "c"})
+	if len(h.topics) != 2 {
+		t.Errorf("expected at most 2 topics, got %d", len(h.topics))
+	}
+}
+
+// TestHistoryTopicSequences verifies that messages on several topics get a sequence per topic and are replayed on each of them
+func TestHistoryTopicSequences(t *testing.T) {
+	h := newHistory(map[string]HistoryOptions{"web": {Size: 10}})
+
+	h.append(&BroadcastMessage{Endpoint: "web", Topic: "b"})
+	msg := BroadcastMessage{Endpoint: "web", Topic: "a", Topics: []string{"b"}}
+	h.append(&msg)
+	if msg.Sequence != 1 || msg.Sequences["a"] != 1 || msg.Sequences["b"] != 2 {
+		t.Errorf("unexpected sequences %d %v", msg.Sequence, msg.Sequences)
+	}
+
+	h.replay("web", "b", 1, 0, func(msgs []BroadcastMessage, latest uint64) {
+		if len(msgs) != 1 || msgs[0].Topic != "a" || latest != 2 {
+			t.Errorf("expected the message to be replayed on its additional topic, got %v, %d", msgs, latest)
+		}
+	})
+}
+
+// TestHistoryPosition verifies that a message found by its id gives the sequence of every topic when it was published
+func TestHistoryPosition(t *testing.T) {
+	h := newHistory(map[string]HistoryOptions{"web": {Size: 10}})
+	for _, msg := range []BroadcastMessage{
+		{Endpoint: "web", Topic: "a", ID: "1"},
+		{Endpoint: "web", Topic: "b", ID: "2"},
+		{Endpoint: "web", Topic: "a", ID: "3"},
+		{Endpoint: "web", Topic: "b", ID: "4"},
+	} {
+		h.append(&msg)
+	}
+
+	byID := func(id string) func(BroadcastMessage) bool {
+		return func(msg BroadcastMessage) bool { return msg.ID == id }
+	}
+	sequences, ok := h.position("web", []string{"a", "b", "c"}, byID("3"))
+	if !ok || len(sequences) != 2 || sequences["a"] != 2 || sequences["b"] != 1 {
+		t.Errorf("expected a at 2 and b at 1, got %v, %v", sequences, ok)
+	}
+	sequences, ok = h.position("web", []string{"a", "b"}, byID("1"))
+	if !ok || sequences["a"] != 1 || sequences["b"] != 0 {
+		t.Errorf("expected b to replay its whole history, got %v, %v", sequences, ok)
+	}
+	if _, ok := h.position("web", []string{"a", "b"}, byID("5")); ok {
+		t.Error("expected unknown ids not to be found")
+	}
+}
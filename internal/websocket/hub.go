@@ -1,11 +1,16 @@
 package websocket
 
 import (
+    "context"
+    "fmt"
     "github.com/gorilla/websocket"
 	"github.com/make0x20/driplet/internal/jwt"
+    "github.com/make0x20/driplet/internal/filter"
+    "github.com/make0x20/driplet/internal/metrics"
     "log/slog"
     "net/http"
     "sync"
+    "time"
 )
 
 // HubOptions holds the options for the hub
@@ -14,17 +19,63 @@ type HubOptions struct {
     Upgrader        *websocket.Upgrader
     ReadBufferSize  int
     WriteBufferSize int
+    Metrics         *metrics.Registry
+    Compression     map[string]CompressionOptions
+    FilterLimits    filter.Limits
+    PingInterval    time.Duration
+    History         map[string]HistoryOptions
 }
 
 type Option func(*HubOptions)
 
+// WithMetrics sets the metrics registry used by the hub
+func WithMetrics(registry *metrics.Registry) Option {
+    return func(o *HubOptions) {
+        o.Metrics = registry
+    }
+}
+
+// WithFilterLimits sets the complexity limits for subscription filters
+func WithFilterLimits(limits filter.Limits) Option {
+    return func(o *HubOptions) {
+        o.FilterLimits = limits
+    }
+}
+
+// WithPingInterval sets the interval of protocol-level pings
+func WithPingInterval(interval time.Duration) Option {
+    return func(o *HubOptions) {
+        if interval > 0 {
+            o.PingInterval = interval
+        }
+    }
+}
+
+// WithHistory sets the message history options for an endpoint
+func WithHistory(endpoint string, history HistoryOptions) Option {
+    return func(o *HubOptions) {
+        o.History[endpoint] = history
+    }
+}
+
+// WithCompression sets the permessage-deflate options for an endpoint
+func WithCompression(endpoint string, compression CompressionOptions) Option {
+    return func(o *HubOptions) {
+        o.Compression[endpoint] = compression
+    }
+}
+
 // Hub is the main websocket hub.
 type Hub struct {
     options    *HubOptions
+    metrics    *hubMetrics
+    history    *history
     clients    map[*Client]bool
     register   chan *Client
     unregister chan *Client
     mu         sync.RWMutex
+    writers    sync.WaitGroup
+    closing    bool
 }
 
 // NewHub creates a new websocket hub
@@ -32,6 +83,10 @@ func defaultHubOptions() *HubOptions {
     return &HubOptions{
         ReadBufferSize:  1024,
         WriteBufferSize: 1024,
+        Compression:     make(map[string]CompressionOptions),
+        FilterLimits:    filter.DefaultLimits,
+        PingInterval:    30 * time.Second,
+        History:         make(map[string]HistoryOptions),
     }
 }
 
@@ -48,6 +103,10 @@ func NewHub(logger *slog.Logger, options ...Option) *Hub {
         opt(opts)
     }
 
+    if opts.Metrics == nil {
+        opts.Metrics = metrics.NewRegistry()
+    }
+
     if opts.Upgrader == nil {
         opts.Upgrader = &websocket.Upgrader{
             ReadBufferSize:  opts.ReadBufferSize,
@@ -60,6 +119,8 @@ func NewHub(logger *slog.Logger, options ...Option) *Hub {
 
     return &Hub{
         options:    opts,
+        metrics:    newHubMetrics(opts.Metrics),
+        history:    newHistory(opts.History),
         clients:    make(map[*Client]bool),
         register:   make(chan *Client),
         unregister: make(chan *Client),
@@ -71,14 +132,11 @@ func (h *Hub) Run() {
     for {
         select {
         case client := <-h.register:
-            h.mu.Lock()
-            h.clients[client] = true
-            h.mu.Unlock()
+            h.addClient(client)
         case client := <-h.unregister:
             h.mu.Lock()
             if _, ok := h.clients[client]; ok {
-                delete(h.clients, client)
-                close(client.send)
+                h.removeClient(client)
             }
             h.mu.Unlock()
         }
@@ -87,23 +145,220 @@ func (h *Hub) Run() {
 
 // HandleConnection handles websocket connections
 func (h *Hub) HandleConnection(w http.ResponseWriter, r *http.Request, endpoint string, claims *jwt.Claims) error {
-    conn, err := h.options.Upgrader.Upgrade(w, r, nil)
+    conn, wire, err := h.upgrade(w, r, endpoint, nil)
     if err != nil {
         return err
     }
 
     client := NewClient(h, conn, endpoint, claims)
+    client.wire = wire
+    if compression := h.compressionFor(endpoint); compression.Enabled && offersCompression(r) {
+        client.compression = compression
+    }
     h.options.Logger.Info("Created new client",
         "endpoint", endpoint,
-        "claims", claims.Custom,
+        "claims", claims.Attributes(),
     )
 
+    // Count the writer before the client is registered, so Shutdown waits for it once the client is visible
+    h.writers.Add(1)
     h.register <- client
     go client.WritePump()
     go client.ReadPump()
     return nil
 }
 
+// Upgrade upgrades a connection to WebSocket with the endpoint settings, used by protocol adapters
+// that run their own read and write loops. A Sec-WebSocket-Protocol response header selects the subprotocol.
+func (h *Hub) Upgrade(w http.ResponseWriter, r *http.Request, endpoint string, responseHeader http.Header) (*websocket.Conn, error) {
+    conn, _, err := h.upgrade(w, r, endpoint, responseHeader)
+    return conn, err
+}
+
+// upgrade upgrades a connection with a per-endpoint copy of the upgrader so compression can be negotiated per endpoint
+func (h *Hub) upgrade(w http.ResponseWriter, r *http.Request, endpoint string, responseHeader http.Header) (*websocket.Conn, *countingConn, error) {
+    compression := h.compressionFor(endpoint)
+    upgrader := *h.options.Upgrader
+    upgrader.EnableCompression = compression.Enabled
+
+    // Count bytes on the wire so the compression ratio can be reported
+    cw := &countingResponseWriter{ResponseWriter: w}
+    conn, err := upgrader.Upgrade(cw, r, responseHeader)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    if compression.Enabled && compression.Level != 0 {
+        if err := conn.SetCompressionLevel(compression.Level); err != nil {
+            h.options.Logger.Error("Invalid compression level",
+                "endpoint", endpoint,
+                "level", compression.Level,
+                "error", err,
+            )
+        }
+    }
+
+    return conn, cw.conn, nil
+}
+
+// Attach registers a client that is not backed by a websocket connection, e.g. a server-sent events stream.
+// The caller reads the client's deliveries until the channel is closed and calls Detach when it goes away.
+func (h *Hub) Attach(endpoint string, claims *jwt.Claims, options ...ClientOption) *Client {
+    client := NewClient(h, nil, endpoint, claims)
+    for _, opt := range options {
+        opt(client)
+    }
+    h.options.Logger.Info("Attached new client",
+        "endpoint", endpoint,
+        "claims", claims.Attributes(),
+    )
+
+    // Register synchronously so no message published after Attach returns is missed
+    h.addClient(client)
+    return client
+}
+
+// addClient adds a client to the hub
+func (h *Hub) addClient(client *Client) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    if h.closing {
+        // Reject clients registering during shutdown
+        client.setCloseReason(CloseShutdown)
+        client.close()
+        return
+    }
+    h.clients[client] = true
+    h.watchExpiry(client)
+    h.metrics.connections.With(client.endpoint).Inc()
+}
+
+// watchExpiry disconnects the client once its token expires, callers must hold mu
+func (h *Hub) watchExpiry(client *Client) {
+    if client.claims == nil || client.claims.ExpiresAt == nil {
+        return
+    }
+    client.expiry = time.AfterFunc(time.Until(client.claims.ExpiresAt.Time), func() {
+        h.disconnect(client, CloseTokenExpired)
+    })
+}
+
+// removeClient removes a client from the hub and closes its send channel, callers must hold mu
+func (h *Hub) removeClient(client *Client) {
+    if client.expiry != nil {
+        client.expiry.Stop()
+    }
+    delete(h.clients, client)
+    client.close()
+    h.metrics.connections.With(client.endpoint).Dec()
+    h.metrics.disconnects.With(client.endpoint, client.CloseReason().Reason).Inc()
+}
+
+// disconnect disconnects a client, telling it why and when to reconnect
+func (h *Hub) disconnect(client *Client, reason CloseReason) {
+    client.setCloseReason(reason)
+    h.unregisterClient(client)
+}
+
+// Kick disconnects the clients of an endpoint whose claims match, telling them they were kicked.
+// Returns the number of disconnected clients.
+func (h *Hub) Kick(endpoint string, match func(*jwt.Claims) bool) int {
+    h.mu.RLock()
+    var kicked []*Client
+    for client := range h.clients {
+        if client.endpoint == endpoint && client.claims != nil && match(client.claims) {
+            kicked = append(kicked, client)
+        }
+    }
+    h.mu.RUnlock()
+
+    for _, client := range kicked {
+        h.disconnect(client, CloseKicked)
+    }
+    h.options.Logger.Info("Kicked clients", "endpoint", endpoint, "count", len(kicked))
+    return len(kicked)
+}
+
+// UpdateAttributes sets and removes targeting attributes of the clients of an endpoint whose token claims
+// match every dot-separated path of the selector, values match like targeting rules. Returns the number of updated clients.
+func (h *Hub) UpdateAttributes(endpoint string, selector map[string]interface{}, set map[string]interface{}, remove []string) (int, error) {
+    if len(selector) == 0 {
+        return 0, fmt.Errorf("selector requires at least one claim")
+    }
+    for path, value := range selector {
+        if err := validateTargetValue(path, value); err != nil {
+            return 0, fmt.Errorf("invalid selector: %w", err)
+        }
+    }
+    if len(set) == 0 && len(remove) == 0 {
+        return 0, fmt.Errorf("no attributes to set or remove")
+    }
+
+    h.mu.RLock()
+    var updated []*Client
+    for client := range h.clients {
+        if client.endpoint == endpoint && client.claims != nil && matchClaims(client.claims, selector) {
+            updated = append(updated, client)
+        }
+    }
+    h.mu.RUnlock()
+
+    for _, client := range updated {
+        client.updateAttributes(set, remove)
+    }
+    h.options.Logger.Info("Updated client attributes", "endpoint", endpoint, "count", len(updated))
+    return len(updated), nil
+}
+
+// matchClaims reports whether the token claims match every path of the selector
+func matchClaims(claims *jwt.Claims, selector map[string]interface{}) bool {
+    for path, value := range selector {
+        claim, exists := claims.GetClaim(path)
+        if !exists || !MatchValue(claim, value) {
+            return false
+        }
+    }
+    return true
+}
+
+// Shutdown disconnects all clients and waits until their close frames are written or the context is done
+func (h *Hub) Shutdown(ctx context.Context) error {
+    h.mu.Lock()
+    h.closing = true
+    count := len(h.clients)
+    for client := range h.clients {
+        client.setCloseReason(CloseShutdown)
+        h.removeClient(client)
+    }
+    h.mu.Unlock()
+
+    h.options.Logger.Info("Disconnected clients for shutdown", "count", count)
+
+    done := make(chan struct{})
+    go func() {
+        h.writers.Wait()
+        close(done)
+    }()
+
+    select {
+    case <-done:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// PingInterval returns the interval of pings and heartbeats sent to clients
+func (h *Hub) PingInterval() time.Duration {
+    return h.options.PingInterval
+}
+
+// Metrics returns the metrics registry used by the hub
+func (h *Hub) Metrics() *metrics.Registry {
+    return h.options.Metrics
+}
+
 // unregisterClient unregisters a client from the hub
 func (h *Hub) unregisterClient(client *Client) {
     select {
@@ -116,23 +371,3 @@ func (h *Hub) unregisterClient(client *Client) {
         }()
     }
 }
-
-// Helper functions
-func contains(slice []string, str string) bool {
-    for _, s := range slice {
-        if s == str {
-            return true
-        }
-    }
-    return false
-}
-
-func removeString(slice []string, str string) []string {
-    result := make([]string, 0, len(slice))
-    for _, s := range slice {
-        if s != str {
-            result = append(result, s)
-        }
-    }
-    return result
-}
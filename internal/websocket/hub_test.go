@@ -0,0 +1,139 @@
+package websocket
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/make0x20/driplet/internal/jwt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestHub(t *testing.T, options ...Option) *Hub {
+	t.Helper()
+	hub := NewHub(slog.New(slog.NewTextHandler(io.Discard, nil)), options...)
+	go hub.Run()
+	return hub
+}
+
+// attachTestClient attaches a client with the claims of a JSON token payload, subscribed to the topic
+func attachTestClient(t *testing.T, hub *Hub, payload string, topic string) *Client {
+	t.Helper()
+	var claims jwt.Claims
+	if err := json.Unmarshal([]byte(payload), &claims); err != nil {
+		t.Fatal(err)
+	}
+	c := hub.Attach("web", &claims)
+	t.Cleanup(c.Detach)
+	if err := c.Subscribe(SubscriptionMessage{Topic: topic}); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// dialTestClient connects a WebSocket client with the claims to the hub, a nil dialer uses the default dialer.
+// It returns once the hub runs the client.
+func dialTestClient(t *testing.T, hub *Hub, claims *jwt.Claims, dialer *websocket.Dialer) *websocket.Conn {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := hub.HandleConnection(w, r, "web", claims); err != nil {
+			t.Error(err)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	// The pong is only sent once the client is registered and its pumps run
+	if err := conn.WriteJSON(PingMessage{Type: MessageTypePing, ID: "ready"}); err != nil {
+		t.Fatal(err)
+	}
+	var pong PongMessage
+	if err := readJSON(conn, &pong); err != nil || pong.ID != "ready" {
+		t.Fatalf("expected the client to be ready, got %+v: %v", pong, err)
+	}
+	return conn
+}
+
+// readJSON reads the next message of a client connection into v
+func readJSON(conn *websocket.Conn, v interface{}) error {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// receives reports whether a message is delivered to the client within the timeout
+func receives(c *Client, timeout time.Duration) bool {
+	select {
+	case _, ok := <-c.Deliveries():
+		return ok
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// TestUpdateAttributes verifies that attributes set or removed on live clients are used for targeting:
+// - Updates require a selector
+// - Set attributes replace the claims, removed attributes hide them
+// - Dotted paths resolve through updated attributes, removing a parent hides the paths below it
+func TestUpdateAttributes(t *testing.T) {
+	hub := newTestHub(t)
+	promoted := attachTestClient(t, hub, `{"sub":"42","custom":{"role":"user","team":{"id":"a"}}}`, "news")
+	demoted := attachTestClient(t, hub, `{"sub":"7","custom":{"role":"admin","team":{"id":"a"}}}`, "news")
+
+	if _, err := hub.UpdateAttributes("web", nil, map[string]interface{}{"role": "admin"}, nil); err == nil {
+		t.Error("expected update without selector to be rejected")
+	}
+	set := map[string]interface{}{"role": "admin", "team": map[string]interface{}{"id": "b"}}
+	if n, err := hub.UpdateAttributes("web", map[string]interface{}{"sub": "42"}, set, nil); err != nil || n != 1 {
+		t.Fatalf("expected 1 updated client, got %d, %v", n, err)
+	}
+	if n, err := hub.UpdateAttributes("web", map[string]interface{}{"sub": "7"}, nil, []string{"role", "team"}); err != nil || n != 1 {
+		t.Fatalf("expected 1 updated client, got %d, %v", n, err)
+	}
+
+	tests := []struct {
+		name     string
+		include  map[string]interface{}
+		promoted bool
+		demoted  bool
+	}{
+		{"set attribute", map[string]interface{}{"role": "admin"}, true, false},
+		{"path below set attribute", map[string]interface{}{"team.id": "b"}, true, false},
+		{"path below replaced claim", map[string]interface{}{"team.id": "a"}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := hub.Broadcast(BroadcastMessage{
+				Endpoint: "web",
+				Topic:    "news",
+				Message:  json.RawMessage(`{"n":1}`),
+				Target:   Target{Include: tt.include},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := receives(promoted, 100*time.Millisecond); got != tt.promoted {
+				t.Errorf("promoted client: expected delivery %v, got %v", tt.promoted, got)
+			}
+			if got := receives(demoted, 50*time.Millisecond); got != tt.demoted {
+				t.Errorf("demoted client: expected delivery %v, got %v", tt.demoted, got)
+			}
+		})
+	}
+}
@@ -1,14 +1,19 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"reflect"
 )
 
 const (
 	MessageTypeSubscribe   = "subscribe"
 	MessageTypeUnsubscribe = "unsubscribe"
+	MessageTypeError       = "error"
+	MessageTypePing        = "ping"
+	MessageTypePong        = "pong"
 )
 
 // Message is the main message struct
@@ -20,8 +25,36 @@ type Message struct {
 
 // SubscriptionMessage is a subscription message
 type SubscriptionMessage struct {
+	Type   string `json:"type"`
+	Topic  string `json:"topic"`
+	Filter string `json:"filter,omitempty"`
+	// History requests the last N messages of the topic
+	History int `json:"history,omitempty"`
+	// Since requests the messages of the topic after the given sequence
+	Since *uint64 `json:"since,omitempty"`
+}
+
+// ErrorMessage is sent to a client when one of its commands fails
+type ErrorMessage struct {
 	Type  string `json:"type"`
-	Topic string `json:"topic"`
+	Topic string `json:"topic,omitempty"`
+	Error string `json:"error"`
+}
+
+// PingMessage is an application-level ping sent by a client
+type PingMessage struct {
+	Type      string `json:"type"`
+	ID        string `json:"id,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+}
+
+// PongMessage answers a ping with the server time and the measured round trip time
+type PongMessage struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id,omitempty"`
+	Timestamp  int64    `json:"timestamp,omitempty"`
+	ServerTime int64    `json:"server_time"`
+	RTT        *float64 `json:"rtt,omitempty"`
 }
 
 // Target is the target struct
@@ -36,6 +69,29 @@ type BroadcastMessage struct {
 	Target   Target          `json:"target"`
 	Endpoint string          `json:"endpoint"`
 	Topic    string          `json:"topic,omitempty"`
+	// Sequence is the history sequence of the main topic, Sequences holds the sequence of every topic
+	Sequence  uint64            `json:"sequence,omitempty"`
+	Sequences map[string]uint64 `json:"sequences,omitempty"`
+	Replayed  bool              `json:"replayed,omitempty"`
+	// ID is an optional publisher supplied message id
+	ID string `json:"id,omitempty"`
+	// Event is an optional event name for transports with named events
+	Event string `json:"event,omitempty"`
+	// Topics are additional topics the message is published to
+	Topics []string `json:"topics,omitempty"`
+	// Format is an optional HTML fragment format of the message for server-sent events, "turbo-stream" or "htmx"
+	Format string `json:"format,omitempty"`
+	// Private messages are only delivered to clients authorized for one of their topics
+	Private bool `json:"private,omitempty"`
+	// ExcludeClient is the id of a client that does not receive the message, e.g. the publisher's own socket
+	ExcludeClient string `json:"-"`
+	// UserID is the presence channel user that sent a Pusher client event
+	UserID string `json:"user_id,omitempty"`
+}
+
+// AllTopics returns the main topic followed by the additional topics of the message
+func (m BroadcastMessage) AllTopics() []string {
+	return append([]string{m.Topic}, m.Topics...)
 }
 
 // Broadcast sends a message to all clients subscribed to the given topic.
@@ -43,18 +99,41 @@ func (h *Hub) Broadcast(msg BroadcastMessage) error {
 	if msg.Topic == "" {
 		return fmt.Errorf("topic is required for broadcasting messages")
 	}
+	for _, topic := range msg.Topics {
+		if topic == "" {
+			return fmt.Errorf("additional topics must not be empty")
+		}
+	}
 
 	// Validate the target
 	if err := validateTarget(msg.Target); err != nil {
 		return fmt.Errorf("invalid target structure: %w", err)
 	}
 
+	// Assign the topic sequence and keep the message in the topic history
+	msg.Replayed = false
+	h.history.append(&msg)
+
 	// Marshal the message
 	msgBytes, err := json.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal broadcast message: %w", err)
 	}
 
+	// Decode the payload lazily, only when a subscription filter needs it
+	var payload interface{}
+	var decoded bool
+	decodePayload := func() interface{} {
+		if !decoded {
+			decoded = true
+			if err := json.Unmarshal(msg.Message, &payload); err != nil {
+				payload = nil
+			}
+		}
+		return payload
+	}
+
+	topics := msg.AllTopics()
 	var unregisterClients []*Client
 
 	h.mu.RLock()
@@ -71,33 +150,42 @@ func (h *Hub) Broadcast(msg BroadcastMessage) error {
 			continue
 		}
 
+		// skip the excluded client
+		if msg.ExcludeClient != "" && client.id == msg.ExcludeClient {
+			continue
+		}
+
 		// Check if the client is subscribed to the topic
-		client.topicsMu.RLock()
-		subscribed := contains(client.topics, msg.Topic)
-		client.topicsMu.RUnlock()
+		sub, topic, subscribed := client.match(topics)
 		if !subscribed {
 			continue
 		}
 
-		// Check if the client should receive the message based targets
-		if h.shouldReceiveMessage(client, msg.Target) {
-			select {
-			case client.send <- msgBytes:
-				h.options.Logger.Debug("Message sent to client",
-					"endpoint", client.endpoint,
-					"topic", msg.Topic,
-				)
-			default:
-				h.options.Logger.Debug("Client send buffer full, marking for unregistration",
-					"endpoint", client.endpoint,
-				)
-				unregisterClients = append(unregisterClients, client)
-			}
+		// Skip messages already replayed from the history of the matched topic, messages without history have no sequence
+		if sequence := msg.Sequences[topic]; sequence != 0 && sequence <= sub.replayedUpTo {
+			continue
+		}
+
+		// Check targets and the subscription filter
+		if !h.shouldDeliver(client, sub, msg, decodePayload) {
+			continue
+		}
+
+		if client.enqueue(Delivery{Data: msgBytes, Message: &msg, Topic: topic}) {
+			h.options.Logger.Debug("Message sent to client",
+				"endpoint", client.endpoint,
+				"topic", msg.Topic,
+			)
+		} else {
+			h.options.Logger.Debug("Client send buffer full, marking for unregistration",
+				"endpoint", client.endpoint,
+			)
+			unregisterClients = append(unregisterClients, client)
 		}
 	}
 
 	for _, client := range unregisterClients {
-		h.unregisterClient(client)
+		h.disconnect(client, CloseSlowConsumer)
 	}
 
 	if len(unregisterClients) > 0 {
@@ -109,13 +197,52 @@ func (h *Hub) Broadcast(msg BroadcastMessage) error {
 	return nil
 }
 
+// shouldDeliver checks if a message should be delivered on a client subscription based on targets and filter.
+// payload returns the decoded message, it is decoded from msg when nil.
+func (h *Hub) shouldDeliver(client *Client, sub *subscription, msg BroadcastMessage, payload func() interface{}) bool {
+	if msg.Private && !client.authorized(msg.AllTopics()) {
+		h.options.Logger.Debug("Client not authorized for private message",
+			"endpoint", client.endpoint,
+			"topic", msg.Topic,
+		)
+		return false
+	}
+
+	if !h.shouldReceiveMessage(client, msg.Target) {
+		return false
+	}
+
+	if sub.filter == nil {
+		return true
+	}
+
+	var matched bool
+	if payload != nil {
+		matched = sub.filter.Match(payload())
+	} else {
+		matched = sub.filter.MatchJSON(msg.Message)
+	}
+	if !matched {
+		h.options.Logger.Debug("Message rejected by subscription filter",
+			"endpoint", client.endpoint,
+			"topic", msg.Topic,
+			"filter", sub.filter.String(),
+		)
+	}
+	return matched
+}
+
 // shouldReceiveMessage checks if a client should receive a message based on the target.
 func (h *Hub) shouldReceiveMessage(client *Client, target Target) bool {
-	h.options.Logger.Debug("Checking message targeting",
-		"client_claims", client.claims.Custom,
-		"target_include", target.Include,
-		"target_exclude", target.Exclude,
-	)
+	// The attributes are only copied for debug logs, this runs for every client on every broadcast
+	if h.options.Logger.Enabled(context.Background(), slog.LevelDebug) {
+		h.options.Logger.Debug("Checking message targeting",
+			"client_claims", client.claims.Attributes(),
+			"client_attributes", client.updatedAttributes(),
+			"target_include", target.Include,
+			"target_exclude", target.Exclude,
+		)
+	}
 
 	// No targeting = all clients receive
 	if len(target.Include) == 0 && len(target.Exclude) == 0 {
@@ -123,16 +250,22 @@ func (h *Hub) shouldReceiveMessage(client *Client, target Target) bool {
 		return true
 	}
 
+	// Anonymous clients cannot be told apart, exclude rules would not keep them out
+	if client.anonymous {
+		h.options.Logger.Debug("Anonymous client skipped for targeted message")
+		return false
+	}
+
 	// Check if excluded
 	for path, targetValue := range target.Exclude {
-		claimValue, exists := client.claims.GetCustomClaim(path)
+		claimValue, exists := client.attribute(path)
 		h.options.Logger.Debug("Checking exclude rule",
 			"path", path,
 			"target_value", targetValue,
 			"claim_exists", exists,
 			"claim_value", claimValue,
 		)
-		if exists && matchValue(claimValue, targetValue) {
+		if exists && MatchValue(claimValue, targetValue) {
 			h.options.Logger.Debug("Client excluded by matching exclude rule",
 				"path", path,
 				"target_value", targetValue,
@@ -150,14 +283,14 @@ func (h *Hub) shouldReceiveMessage(client *Client, target Target) bool {
 
 	// Check inclusions
 	for path, targetValue := range target.Include {
-		claimValue, exists := client.claims.GetCustomClaim(path)
+		claimValue, exists := client.attribute(path)
 		h.options.Logger.Debug("Checking include rule",
 			"path", path,
 			"target_value", targetValue,
 			"claim_exists", exists,
 			"claim_value", claimValue,
 		)
-		if exists && matchValue(claimValue, targetValue) {
+		if exists && MatchValue(claimValue, targetValue) {
 			h.options.Logger.Debug("Client included by matching include rule",
 				"path", path,
 				"target_value", targetValue,
@@ -211,8 +344,8 @@ func validateTargetValue(path string, value interface{}) error {
 	}
 }
 
-// matchValue checks if the claim value matches the target value.
-func matchValue(claimValue, targetValue interface{}) bool {
+// MatchValue checks if the claim value matches the target value, a list target matches if any of its values match.
+func MatchValue(claimValue, targetValue interface{}) bool {
 	if claimValue == nil || targetValue == nil {
 		return claimValue == targetValue
 	}
@@ -0,0 +1,39 @@
+package websocket
+
+import (
+	"github.com/make0x20/driplet/internal/jwt"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+// TestAnonymousTargeting verifies that anonymous clients only receive untargeted messages:
+// - Untargeted messages are delivered
+// - Messages with include or exclude rules are not, even if no exclude rule matches
+func TestAnonymousTargeting(t *testing.T) {
+	hub := NewHub(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	anonymous := NewClient(hub, nil, "web", &jwt.Claims{})
+	WithAnonymous()(anonymous)
+	identified := NewClient(hub, nil, "web", &jwt.Claims{})
+
+	tests := []struct {
+		name       string
+		target     Target
+		anonymous  bool
+		identified bool
+	}{
+		{"untargeted", Target{}, true, true},
+		{"exclude", Target{Exclude: map[string]interface{}{"user.id": "42"}}, false, true},
+		{"include", Target{Include: map[string]interface{}{"user.id": "42"}}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hub.shouldReceiveMessage(anonymous, tt.target); got != tt.anonymous {
+				t.Errorf("anonymous client: expected %v, got %v", tt.anonymous, got)
+			}
+			if got := hub.shouldReceiveMessage(identified, tt.target); got != tt.identified {
+				t.Errorf("identified client: expected %v, got %v", tt.identified, got)
+			}
+		})
+	}
+}
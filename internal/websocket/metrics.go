@@ -0,0 +1,36 @@
+package websocket
+
+import (
+	"github.com/make0x20/driplet/internal/metrics"
+)
+
+// hubMetrics holds the metric families reported by the hub
+type hubMetrics struct {
+	connections       *metrics.GaugeVec
+	disconnects       *metrics.CounterVec
+	messagesSent      *metrics.CounterVec
+	payloadBytes      *metrics.CounterVec
+	compressedPayload *metrics.CounterVec
+	compressedWire    *metrics.CounterVec
+	rtt               *metrics.HistogramVec
+}
+
+// newHubMetrics registers the hub metric families
+func newHubMetrics(r *metrics.Registry) *hubMetrics {
+	return &hubMetrics{
+		connections: r.NewGaugeVec("driplet_connections",
+			"Number of connected clients.", "endpoint"),
+		disconnects: r.NewCounterVec("driplet_disconnects_total",
+			"Number of disconnected clients by close reason.", "endpoint", "reason"),
+		messagesSent: r.NewCounterVec("driplet_messages_sent_total",
+			"Number of messages written to clients.", "endpoint"),
+		payloadBytes: r.NewCounterVec("driplet_payload_bytes_total",
+			"Uncompressed payload bytes written to clients.", "endpoint"),
+		compressedPayload: r.NewCounterVec("driplet_compressed_payload_bytes_total",
+			"Uncompressed payload bytes of messages sent with permessage-deflate.", "endpoint"),
+		compressedWire: r.NewCounterVec("driplet_compressed_wire_bytes_total",
+			"Bytes on the wire for messages sent with permessage-deflate.", "endpoint"),
+		rtt: r.NewHistogramVec("driplet_rtt_seconds",
+			"Round trip time of protocol-level pings.", nil, "endpoint"),
+	}
+}
@@ -0,0 +1,189 @@
+package websocket
+
+import (
+	"github.com/gorilla/websocket"
+	"io"
+	"sync"
+	"time"
+)
+
+// OutFrame is a frame queued for a write pump, a frame with a Close message ends the connection after its Data
+type OutFrame struct {
+	Data  []byte
+	Close []byte
+}
+
+// Outbox queues the frames of a protocol connection for its write pump
+type Outbox struct {
+	frames   chan OutFrame
+	done     chan struct{}
+	finished chan struct{}
+	once     sync.Once
+}
+
+// NewOutbox creates an outbox holding up to size frames
+func NewOutbox(size int) *Outbox {
+	return &Outbox{
+		frames:   make(chan OutFrame, size),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+}
+
+// Queue queues a frame without blocking, a connection that can not keep up is stopped
+func (o *Outbox) Queue(data []byte) {
+	o.QueueFrame(OutFrame{Data: data})
+}
+
+// QueueClose queues a close message ending the connection after the frames queued before it
+func (o *Outbox) QueueClose(message []byte) {
+	o.QueueFrame(OutFrame{Close: message})
+}
+
+// QueueFrame queues a frame without blocking, a connection that can not keep up is stopped
+func (o *Outbox) QueueFrame(frame OutFrame) {
+	select {
+	case o.frames <- frame:
+	case <-o.done:
+	default:
+		o.Stop()
+	}
+}
+
+// Send queues a frame, blocking until there is room or the outbox is stopped. Returns false if it is stopped.
+func (o *Outbox) Send(frame OutFrame) bool {
+	select {
+	case o.frames <- frame:
+		return true
+	case <-o.done:
+		return false
+	}
+}
+
+// Stop signals the write pump to close the connection
+func (o *Outbox) Stop() {
+	o.once.Do(func() {
+		close(o.done)
+	})
+}
+
+// Done is closed when the outbox is stopped
+func (o *Outbox) Done() <-chan struct{} {
+	return o.done
+}
+
+// Wait waits at most timeout for the write pump to return, e.g. to flush a final frame
+func (o *Outbox) Wait(timeout time.Duration) {
+	select {
+	case <-o.finished:
+	case <-time.After(timeout):
+	}
+}
+
+// Tick is a periodic write of a pump, e.g. a ping
+type Tick struct {
+	Interval time.Duration
+	Write    func() error
+}
+
+// Pump is the write loop of a protocol connection: it writes queued frames, hub deliveries and ticks
+// until a write fails, the hub closes the client or the outbox is stopped
+type Pump struct {
+	Outbox *Outbox
+	// Client is the hub client whose deliveries are written, none are if nil
+	Client *Client
+	// Conn is closed when the pump returns, which ends the read pump
+	Conn io.Closer
+	// Write writes a frame, WriteClose writes a close message
+	Write      func(data []byte) error
+	WriteClose func(message []byte)
+	// Deliver writes a hub delivery
+	Deliver func(delivery Delivery) error
+	// Closed tells the peer why the hub closed the client
+	Closed func(reason CloseReason)
+	// Stopped is called when the pump returns because the outbox was stopped
+	Stopped func()
+	// Ping and Heartbeat are written periodically if their interval is set
+	Ping      Tick
+	Heartbeat Tick
+}
+
+// Run runs the pump until the connection is done
+func (p Pump) Run() {
+	defer close(p.Outbox.finished)
+	if p.Conn != nil {
+		defer p.Conn.Close()
+	}
+
+	ping, stopPing := ticker(p.Ping.Interval)
+	defer stopPing()
+	heartbeat, stopHeartbeat := ticker(p.Heartbeat.Interval)
+	defer stopHeartbeat()
+	var deliveries <-chan Delivery
+	if p.Client != nil {
+		deliveries = p.Client.Deliveries()
+	}
+
+	for {
+		select {
+		case frame := <-p.Outbox.frames:
+			if frame.Data != nil {
+				if err := p.Write(frame.Data); err != nil {
+					return
+				}
+			}
+			if frame.Close != nil {
+				if p.WriteClose != nil {
+					p.WriteClose(frame.Close)
+				}
+				return
+			}
+
+		case delivery, ok := <-deliveries:
+			if !ok {
+				if p.Closed != nil {
+					p.Closed(p.Client.CloseReason())
+				}
+				return
+			}
+			if err := p.Deliver(delivery); err != nil {
+				return
+			}
+
+		case <-ping:
+			if err := p.Ping.Write(); err != nil {
+				return
+			}
+
+		case <-heartbeat:
+			if err := p.Heartbeat.Write(); err != nil {
+				return
+			}
+
+		case <-p.Outbox.done:
+			if p.Stopped != nil {
+				p.Stopped()
+			}
+			return
+		}
+	}
+}
+
+// ticker returns the channel of a ticker and its stop func, a nil channel if the interval is not set
+func ticker(interval time.Duration) (<-chan time.Time, func()) {
+	if interval <= 0 {
+		return nil, func() {}
+	}
+	t := time.NewTicker(interval)
+	return t.C, t.Stop
+}
+
+// PingTick returns a tick writing WebSocket pings to a connection at the interval
+func PingTick(ws *websocket.Conn, interval, writeWait time.Duration) Tick {
+	return Tick{
+		Interval: interval,
+		Write: func() error {
+			return ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+		},
+	}
+}
@@ -0,0 +1,162 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/make0x20/driplet/internal/filter"
+	"sort"
+)
+
+// TopicMatcher reports whether a published topic matches a subscription pattern
+type TopicMatcher func(topic string) bool
+
+// subscription holds a client's subscription to a topic
+type subscription struct {
+	topic  string
+	filter *filter.Expression
+	// matcher matches topics for pattern subscriptions, nil for exact topics
+	matcher TopicMatcher
+	// replayedUpTo is the last sequence replayed from history, live messages up to it are skipped
+	replayedUpTo uint64
+}
+
+// subscribe subscribes the client to a topic, replacing any previous subscription to it
+func (c *Client) subscribe(msg SubscriptionMessage) error {
+	if msg.Topic == "" {
+		return fmt.Errorf("topic is required")
+	}
+
+	sub := &subscription{topic: msg.Topic}
+	if msg.Filter != "" {
+		expr, err := filter.Compile(msg.Filter, c.hub.options.FilterLimits)
+		if err != nil {
+			return fmt.Errorf("invalid filter: %w", err)
+		}
+		sub.filter = expr
+	}
+
+	if msg.History <= 0 && msg.Since == nil {
+		c.addSubscription(sub)
+		return nil
+	}
+
+	// Replay history before any live message can be delivered on the new subscription
+	var since uint64
+	if msg.Since != nil {
+		since = *msg.Since
+	}
+	c.hub.history.replay(c.endpoint, msg.Topic, since, msg.History, func(msgs []BroadcastMessage, latest uint64) {
+		sub.replayedUpTo = latest
+		c.addSubscription(sub)
+
+		replayed := 0
+		for _, m := range msgs {
+			if !c.hub.shouldDeliver(c, sub, m, nil) {
+				continue
+			}
+			m.Replayed = true
+			data, err := json.Marshal(m)
+			if err != nil {
+				continue
+			}
+			if !c.enqueue(Delivery{Data: data, Message: &m, Topic: msg.Topic}) {
+				break
+			}
+			replayed++
+		}
+
+		c.hub.options.Logger.Debug("Replayed topic history",
+			"topic", msg.Topic,
+			"since", since,
+			"replayed", replayed,
+		)
+	})
+	return nil
+}
+
+// addSubscription adds a subscription to the client
+func (c *Client) addSubscription(sub *subscription) {
+	c.topicsMu.Lock()
+	c.topics[sub.topic] = sub
+	topics := c.topicNames()
+	c.topicsMu.Unlock()
+
+	filterSource := ""
+	if sub.filter != nil {
+		filterSource = sub.filter.String()
+	}
+	c.hub.options.Logger.Debug("Client subscribed to topic",
+		"topic", sub.topic,
+		"filter", filterSource,
+		"client_topics", topics,
+	)
+}
+
+// unsubscribe removes the client's subscription to a topic
+func (c *Client) unsubscribe(topic string) {
+	c.topicsMu.Lock()
+	delete(c.topics, topic)
+	topics := c.topicNames()
+	c.topicsMu.Unlock()
+
+	c.hub.options.Logger.Debug("Client unsubscribed from topic",
+		"topic", topic,
+		"client_topics", topics,
+	)
+}
+
+// SubscribePattern subscribes the client to all topics matched by match, keyed by pattern.
+// Pattern subscriptions do not replay history.
+func (c *Client) SubscribePattern(pattern string, match TopicMatcher, filterSource string) error {
+	if pattern == "" || match == nil {
+		return fmt.Errorf("pattern and matcher are required")
+	}
+
+	sub := &subscription{topic: pattern, matcher: match}
+	if filterSource != "" {
+		expr, err := filter.Compile(filterSource, c.hub.options.FilterLimits)
+		if err != nil {
+			return fmt.Errorf("invalid filter: %w", err)
+		}
+		sub.filter = expr
+	}
+
+	c.addSubscription(sub)
+	return nil
+}
+
+// match returns the client's subscription matching any of the given topics and the topic it matched
+func (c *Client) match(topics []string) (*subscription, string, bool) {
+	c.topicsMu.RLock()
+	defer c.topicsMu.RUnlock()
+
+	// Exact subscriptions first
+	for _, topic := range topics {
+		if sub, ok := c.topics[topic]; ok && sub.matcher == nil {
+			return sub, topic, true
+		}
+	}
+
+	// Then pattern subscriptions
+	for _, sub := range c.topics {
+		if sub.matcher == nil {
+			continue
+		}
+		for _, topic := range topics {
+			if sub.matcher(topic) {
+				return sub, topic, true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// topicNames returns the sorted names of subscribed topics, callers must hold topicsMu
+func (c *Client) topicNames() []string {
+	names := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		names = append(names, topic)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/make0x20/driplet/internal/jwt"
+	"testing"
+)
+
+// subscribe subscribes a client connection followed by a ping, the "subscribed" pong marks the subscription as handled
+func subscribe(t *testing.T, conn *websocket.Conn, msg SubscriptionMessage) {
+	t.Helper()
+	msg.Type = MessageTypeSubscribe
+	if err := conn.WriteJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	// Commands are handled in order, the pong follows the subscription
+	if err := conn.WriteJSON(PingMessage{Type: MessageTypePing, ID: "subscribed"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSubscriptionFilter verifies payload filters of WebSocket subscriptions:
+// - Messages that do not match the filter are dropped, matching messages are delivered
+// - An invalid filter rejects the subscription with an error reply
+func TestSubscriptionFilter(t *testing.T) {
+	hub := newTestHub(t)
+	conn := dialTestClient(t, hub, &jwt.Claims{}, nil)
+
+	subscribe(t, conn, SubscriptionMessage{Topic: "orders", Filter: "amount > 10"})
+	var pong PongMessage
+	if err := readJSON(conn, &pong); err != nil || pong.ID != "subscribed" {
+		t.Fatalf("expected the subscription to be handled, got %+v: %v", pong, err)
+	}
+
+	for _, message := range []string{`{"amount":5}`, `{"amount":50}`} {
+		if err := hub.Broadcast(BroadcastMessage{Endpoint: "web", Topic: "orders", Message: json.RawMessage(message)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var received BroadcastMessage
+	if err := readJSON(conn, &received); err != nil {
+		t.Fatal(err)
+	}
+	if string(received.Message) != `{"amount":50}` {
+		t.Errorf("expected the filtered message to be dropped, got %s", received.Message)
+	}
+
+	subscribe(t, conn, SubscriptionMessage{Topic: "invoices", Filter: "amount >"})
+	var reply ErrorMessage
+	if err := readJSON(conn, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Type != MessageTypeError || reply.Topic != "invoices" || reply.Error == "" {
+		t.Errorf("expected an error reply for the invalid filter, got %+v", reply)
+	}
+	if err := readJSON(conn, &pong); err != nil || pong.ID != "subscribed" {
+		t.Fatalf("expected the pong after the error, got %+v: %v", pong, err)
+	}
+
+	// The rejected subscription receives nothing
+	if err := hub.Broadcast(BroadcastMessage{Endpoint: "web", Topic: "invoices", Message: json.RawMessage(`{"amount":50}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := hub.Broadcast(BroadcastMessage{Endpoint: "web", Topic: "orders", Message: json.RawMessage(`{"amount":60}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := readJSON(conn, &received); err != nil || received.Topic != "orders" {
+		t.Errorf("expected only the orders message, got %+v: %v", received, err)
+	}
+}
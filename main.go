@@ -1,10 +1,19 @@
 package main
 
 import (
+    "context"
+    "crypto/tls"
+    "errors"
+    "github.com/make0x20/driplet/internal/metrics"
+    "github.com/make0x20/driplet/internal/tcp"
     "github.com/make0x20/driplet/internal/websocket"
 	"github.com/make0x20/driplet/routes"
+	"net"
 	"net/http"
 	"os"
+    "os/signal"
+    "syscall"
+    "time"
     "fmt"
 )
 
@@ -19,26 +28,94 @@ func main() {
     logger.Debug("Loaded Driplet config",
         "bind_address", fmt.Sprintf("%s:%d", cfg.Global.BindAddress, cfg.Global.Port),
         "endpoint_count", len(cfg.Endpoints),
-        "endpoints", fmt.Sprintf("%+v", cfg.Endpoints),
+        "endpoints", endpointNames(cfg),
     )
 
 	// Log the endpoints
     logger.Info(configEndpoints(cfg))
 
+	// Create a metrics registry
+    registry := metrics.NewRegistry()
+
 	// Create a websocket hub
-    hub := websocket.NewHub(logger)
+    hub := websocket.NewHub(logger, hubOptions(cfg, registry)...)
     go hub.Run()
 
+	// Load the revocation list of client tokens
+    revocations := openRevocations(cfg)
+
+	// Shut down gracefully on SIGINT/SIGTERM
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+	// Create a JWT validator with the keys of every endpoint, key sets are refreshed until shutdown
+    validator := newValidator(ctx, cfg, logger, registry, revocations)
+
 	// Setup routes
-    r := routes.Setup(logger, cfg, hub)
+    r := routes.Setup(ctx, logger, cfg, hub, validator, revocations)
 	addr := fmt.Sprintf("%s:%d", cfg.Global.BindAddress, cfg.Global.Port)
+    server := &http.Server{Addr: addr, Handler: r}
+
+	// Start the optional newline-delimited JSON over TCP listener
+    var tcpSrv *tcp.Server
+    if cfg.Global.TCP.Enabled {
+        var tlsConfig *tls.Config
+        tcpSrv, tlsConfig = tcpServer(cfg, logger, hub, validator)
+        tcpAddr := fmt.Sprintf("%s:%d", cfg.Global.TCP.BindAddress, cfg.Global.TCP.Port)
+        go func() {
+            logger.Info("Starting Driplet TCP listener", "address", tcpAddr, "tls", tlsConfig != nil)
+            if err := tcpSrv.ListenAndServe(tcpAddr, tlsConfig); err != nil && !errors.Is(err, net.ErrClosed) {
+                logger.Error("error starting TCP listener", "error", err)
+                os.Exit(1)
+            }
+        }()
+    }
+
+	// Start the optional separate metrics listener
+    var metricsSrv *http.Server
+    if cfg.Global.Metrics.Enabled && cfg.Global.Metrics.Port != 0 {
+        metricsAddr := fmt.Sprintf("%s:%d", cfg.Global.Metrics.BindAddress, cfg.Global.Metrics.Port)
+        metricsSrv = &http.Server{Addr: metricsAddr, Handler: routes.Metrics(logger, cfg, hub)}
+        go func() {
+            logger.Info("Starting Driplet metrics listener", "address", metricsAddr)
+            if err := metricsSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+                logger.Error("error starting metrics listener", "error", err)
+                os.Exit(1)
+            }
+        }()
+    }
+
+    shutdownDone := make(chan struct{})
+    go func() {
+        defer close(shutdownDone)
+        <-ctx.Done()
+        logger.Info("Shutting down Driplet server")
+
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        defer cancel()
+
+        // Disconnect clients first so streaming requests can finish
+        if err := hub.Shutdown(shutdownCtx); err != nil {
+            logger.Error("error disconnecting clients", "error", err)
+        }
+        if tcpSrv != nil {
+            tcpSrv.Close()
+        }
+        if metricsSrv != nil {
+            metricsSrv.Shutdown(shutdownCtx)
+        }
+        if err := server.Shutdown(shutdownCtx); err != nil {
+            logger.Error("error shutting down server", "error", err)
+        }
+    }()
 
 	// Start the server
 	logger.Info("Starting Driplet server", "address", addr)
-	if err := http.ListenAndServe(addr, r); err != nil {
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.Error("error starting server", "error", err)
 		os.Exit(1)
 	}
-}
-
 
+	// Wait for the shutdown to finish
+    <-shutdownDone
+}
@@ -1,32 +1,104 @@
 package routes
 
 import (
+	"context"
 	"github.com/make0x20/driplet/handlers"
 	"github.com/make0x20/driplet/middleware"
 	"github.com/make0x20/driplet/internal/config"
+	"github.com/make0x20/driplet/internal/graphql"
 	"github.com/make0x20/driplet/internal/jwt"
-	"github.com/make0x20/driplet/internal/nonce"
+	"github.com/make0x20/driplet/internal/longpoll"
+	"github.com/make0x20/driplet/internal/mqtt"
+	"github.com/make0x20/driplet/internal/multiplex"
+	"github.com/make0x20/driplet/internal/pusher"
+	"github.com/make0x20/driplet/internal/revocation"
+	"github.com/make0x20/driplet/internal/stomp"
 	"github.com/make0x20/driplet/internal/websocket"
 	"log/slog"
 	"net/http"
+	"time"
 )
 
-func Setup(logger *slog.Logger, cfg *config.Config, hub *websocket.Hub) http.Handler {
+// Setup registers the routes, background work of the handlers stops when ctx is done
+func Setup(ctx context.Context, logger *slog.Logger, cfg *config.Config, hub *websocket.Hub, validator *jwt.Validator, revocations *revocation.List) http.Handler {
 	mux := http.NewServeMux()
 
 	// Default middleware chain
 	defaultChain := middleware.DefaultChain(logger)
-	// JWT validator
-	validator := jwt.NewValidator(nonce.NewMemoryStore())
+	// Long-polling sessions
+	polls := longpoll.NewManager(ctx, logger, hub, time.Duration(cfg.Global.PollSessionTimeout)*time.Second)
+	// Pusher protocol connections
+	pushers := pusher.NewServer(logger, hub)
+	// MQTT over WebSocket connections
+	brokers := mqtt.NewServer(logger, hub)
+	// STOMP over WebSocket connections
+	stomps := stomp.NewServer(logger, hub)
+	// GraphQL over WebSocket connections
+	subscriptions := graphql.NewServer(logger, hub)
+	// Multiplexed WebSocket connections
+	multiplexer := multiplex.NewServer(logger, hub)
 
 	// Frontpage - returns json status ok
 	mux.Handle("GET /", defaultChain(
 		http.HandlerFunc(handlers.Front())),
 	)
 
-	// Websocket endpoint
+	// Websocket endpoint - subprotocol connections are handled by their protocol adapter
 	mux.Handle("GET /ws/{name}", defaultChain(
-		http.HandlerFunc(handlers.WebSocket(logger, cfg, hub, validator))),
+		http.HandlerFunc(handlers.Subprotocols(
+			handlers.WebSocket(logger, cfg, hub, validator),
+			map[string]http.HandlerFunc{
+				mqtt.Subprotocol:    handlers.MQTT(logger, cfg, hub, brokers, validator),
+				stomp.Subprotocol:   handlers.STOMP(logger, cfg, hub, stomps, validator),
+				graphql.Subprotocol: handlers.GraphQL(logger, cfg, hub, subscriptions, validator),
+			},
+		))),
+	)
+
+	// Multiplexed websocket endpoint - several endpoints over one connection
+	mux.Handle("GET /ws", defaultChain(
+		http.HandlerFunc(handlers.Multiplex(logger, cfg, hub, multiplexer, validator))),
+	)
+
+	// Server-sent events endpoint
+	mux.Handle("GET /sse/{name}", defaultChain(
+		http.HandlerFunc(handlers.SSE(logger, cfg, hub, validator))),
+	)
+
+	// Long-polling endpoints
+	mux.Handle("POST /poll/{name}", defaultChain(
+		http.HandlerFunc(handlers.LongPollSession(logger, cfg, polls, validator))),
+	)
+	mux.Handle("GET /poll/{name}/{session}", defaultChain(
+		http.HandlerFunc(handlers.LongPoll(logger, cfg, polls))),
+	)
+	mux.Handle("POST /poll/{name}/{session}/{action}", defaultChain(
+		http.HandlerFunc(handlers.LongPollSubscribe(logger, polls))),
+	)
+	mux.Handle("DELETE /poll/{name}/{session}", defaultChain(
+		http.HandlerFunc(handlers.LongPollClose(polls))),
+	)
+
+	// Mercure protocol endpoints
+	mux.Handle("GET /.well-known/mercure/{name}", defaultChain(
+		http.HandlerFunc(handlers.MercureSubscribe(logger, cfg, hub, validator))),
+	)
+	mux.Handle("POST /.well-known/mercure/{name}", defaultChain(
+		http.HandlerFunc(handlers.MercurePublish(logger, cfg, hub, validator))),
+	)
+	mux.Handle("OPTIONS /.well-known/mercure/{name}", defaultChain(
+		http.HandlerFunc(handlers.MercurePreflight(logger, cfg))),
+	)
+
+	// Pusher protocol endpoints
+	mux.Handle("GET /app/{key}", defaultChain(
+		http.HandlerFunc(handlers.PusherWebSocket(logger, cfg, hub, pushers, validator))),
+	)
+	mux.Handle("POST /apps/{id}/events", defaultChain(
+		http.HandlerFunc(handlers.PusherEvents(logger, cfg, hub, validator, false))),
+	)
+	mux.Handle("POST /apps/{id}/batch_events", defaultChain(
+		http.HandlerFunc(handlers.PusherEvents(logger, cfg, hub, validator, true))),
 	)
 
 	// Publish message endpoint
@@ -34,6 +106,23 @@ func Setup(logger *slog.Logger, cfg *config.Config, hub *websocket.Hub) http.Han
 		http.HandlerFunc(handlers.PublishMessage(logger, cfg, hub, validator))),
 	)
 
+	// Revocation endpoint - revoked tokens are rejected and their connections closed
+	mux.Handle("POST /api/{name}/revoke", defaultChain(
+		http.HandlerFunc(handlers.Revoke(logger, cfg, hub, validator, revocations))),
+	)
+
+	// Attributes endpoint - sets or removes targeting attributes of live connections
+	mux.Handle("POST /api/{name}/attributes", defaultChain(
+		http.HandlerFunc(handlers.UpdateAttributes(logger, cfg, hub, validator))),
+	)
+
+	// Metrics endpoint - Prometheus text format, served here only if it has no listener of its own
+	if cfg.Global.Metrics.Enabled && cfg.Global.Metrics.Port == 0 {
+		mux.Handle("GET /metrics", defaultChain(
+			http.HandlerFunc(handlers.Metrics(cfg, hub))),
+		)
+	}
+
 	// Ping endpoint
 	mux.Handle("GET /api/{name}/ping", defaultChain(
 		http.HandlerFunc(handlers.Ping(logger, cfg))),
@@ -41,3 +130,12 @@ func Setup(logger *slog.Logger, cfg *config.Config, hub *websocket.Hub) http.Han
 
 	return mux
 }
+
+// Metrics returns the handler of the metrics listener
+func Metrics(logger *slog.Logger, cfg *config.Config, hub *websocket.Hub) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", middleware.DefaultChain(logger)(
+		http.HandlerFunc(handlers.Metrics(cfg, hub))),
+	)
+	return mux
+}